@@ -1,16 +1,39 @@
 package main
 
 import (
+        "context"
         "github.com/jessevdk/go-flags"
         "github.com/matlockx/datadog-backup/internal"
+        "github.com/pkg/errors"
         "github.com/sirupsen/logrus"
         prefixed "github.com/x-cray/logrus-prefixed-formatter"
         "github.com/zorkian/go-datadog-api"
+        "golang.org/x/crypto/ssh/terminal"
+        "io"
+        "net"
+        "net/http"
+        "os"
+        "os/signal"
+        "syscall"
+        "time"
 )
 
 const Pull = "pull"
 const Push = "push"
 const Delete = "delete"
+const Diff = "diff"
+const Prune = "prune"
+const Adopt = "adopt"
+const Validate = "validate"
+const Lint = "lint"
+const References = "references"
+const Serve = "serve"
+const History = "history"
+const Rollback = "rollback"
+
+// exit codes of the status of a run, 1 is used for errors before the run started
+const exitPartialFailure = 2
+const exitFailure = 3
 
 var ddClient *datadog.Client
 var file string = "backup/monitors.yaml"
@@ -18,53 +41,350 @@ var file string = "backup/monitors.yaml"
 func main() {
 
         var opts struct {
-                DataDogApiKey  string `long:"api-key" description:"api key for datadog account" required:"true"`
-                DataDogAppKey  string `long:"app-key" description:"app key for datadog account" required:"true"`
-                Action         string `long:"action" choice:"push" choice:"pull" choice:"delete" description:"push, pull or delete"`
-                ConfigDir      string `long:"config-dir" default:"config" description:"config directory of monitors, dashboards, etc "`
-                BackupDir      string `long:"backup-dir" default:"backup" description:"backup dir for configs where to backup the old config file before pulling new entries from datadog"`
-                Sync           bool   `long:"sync" description:"sync config file with datadog"`
-                OverrideRemote bool   `long:"override-remote" description:"override remote monitor with local one"`
-                DryRun         bool   `long:"dry-run" description:"just show changes"`
-                NoBackup       bool   `long:"no-backup" description:"deactivates backup of local file before pulling new content from remote"`
-        }
-        logrus.SetFormatter(&prefixed.TextFormatter{
-                FullTimestamp:   true,
-                DisableSorting:  false,
-                ForceFormatting: true,
-                ForceColors:     true,
-        })
-
-        _, err := flags.Parse(&opts)
+                DataDogApiKey  string        `long:"api-key" description:"api key for datadog account, required for all actions talking to datadog"`
+                DataDogAppKey  string        `long:"app-key" description:"app key for datadog account, required for all actions talking to datadog"`
+                Action         string        `long:"action" choice:"push" choice:"pull" choice:"delete" choice:"diff" choice:"prune" choice:"adopt" choice:"validate" choice:"lint" choice:"references" choice:"serve" choice:"history" choice:"rollback" description:"push, pull, delete (delete the elements selected by --id, --tag, --name-regex or --delete-list-file after backing them up), diff (local config against datadog), prune (delete owned elements missing in the config), adopt (add the owner tag to existing elements), validate (check the config files locally), lint (check the config files against policy rules), references (check references between elements, of composite monitors and downtimes to monitors, locally and against datadog; dashboards are timeboards without monitor references), serve (pull on a schedule and report drift until stopped), history (changes of one element over the backups, takes the config type and the id or name as arguments) or rollback (revert the changes of a push, takes the journal in the backup dir as argument)"`
+                ConfigDir      string        `long:"config-dir" default:"config" description:"config directory of monitors, dashboards, etc "`
+                BackupDir      string        `long:"backup-dir" default:"backup" description:"backup dir for configs where to backup the old config file before pulling new entries from datadog, use s3://bucket/prefix for an s3 compatible object storage"`
+                S3Endpoint     string        `long:"s3-endpoint" env:"S3_ENDPOINT" description:"endpoint of the s3 compatible object storage, e.g. http://localhost:9000 for minio (default: aws s3 of the region)"`
+                S3Region       string        `long:"s3-region" env:"AWS_REGION" default:"us-east-1" description:"region of the s3 compatible object storage"`
+                Sync           bool          `long:"sync" description:"sync config file with datadog"`
+                OverrideRemote bool          `long:"override-remote" description:"override remote elements with the local ones, also update and rename remote elements which only match by content"`
+                DryRun         bool          `long:"dry-run" description:"just show changes"`
+                RollbackOnErr  bool          `long:"rollback-on-error" description:"revert the changes of a push if it fails, using the journal written to the backup dir"`
+                Yes            bool          `long:"yes" description:"apply destructive changes of push, delete and prune without asking, required if stdin is not a terminal"`
+                NoBackup       bool          `long:"no-backup" description:"deactivates backup of local file before pulling new content from remote"`
+                Concurrency    int           `long:"concurrency" default:"8" description:"number of elements fetched from datadog in parallel"`
+                MaxRetries     int           `long:"max-retries" default:"5" description:"retries of rate limited (429) and failed (5xx) datadog requests"`
+                Timeout        time.Duration `long:"timeout" description:"timeout of the whole run, e.g. 10m (default: no timeout)"`
+                RequestTimeout time.Duration `long:"request-timeout" default:"60s" description:"timeout of a single datadog or s3 request"`
+                ReportFile     string        `long:"report-file" description:"write the summary of the run as json into this file"`
+                Types          []string      `long:"type" description:"only process these config types, comma separated or repeated, e.g. monitors,dashboards (default: all)"`
+                Tags           []string      `long:"tag" description:"only process elements having this tag, can be repeated, all tags must match"`
+                NameRegex      string        `long:"name-regex" description:"only process elements whose name matches this regular expression"`
+                Ids            []int         `long:"id" description:"only process the element with this id, can be repeated, delete requires a single --type with it"`
+                ExcludeIds     []int         `long:"exclude-id" description:"do not process the element with this id, can be repeated"`
+                DeleteListFile string        `long:"delete-list-file" description:"delete: file with the elements to delete, one '<type> <id|name>' per line"`
+                OwnerTag       string        `long:"owner-tag" description:"ownership marker like managed-by:datadog-backup/team-x, added to created elements, pull, diff, prune and delete only touch elements carrying it"`
+                RulesFile      string        `long:"rules-file" description:"yaml file with custom lint rules, checked by lint and before push"`
+                BuiltinRules   bool          `long:"builtin-rules" description:"check the built-in lint rules as well: a team: tag, a notification handle, a renotify interval for critical thresholds and @here only for env:prod monitors"`
+                BlockOnLint    bool          `long:"block-push-on-lint-errors" description:"abort push before any change if a lint rule with severity error is violated"`
+                PushExpired    bool          `long:"push-expired-downtimes" description:"push downtimes which already ended, they are skipped by default"`
+                Schedule       string        `long:"schedule" default:"1h" description:"serve: interval like 15m or cron expression like '*/15 * * * *' of the drift checks"`
+                ListenAddress  string        `long:"listen-address" default:":9090" description:"serve: address of the http server for /metrics and /api"`
+                ApiToken       string        `long:"api-token" env:"API_TOKEN" description:"serve: token of the http api to trigger pulls and inspect snapshots, the api is disabled without it"`
+                MetricsFile    string        `long:"metrics-textfile" description:"write the metrics of the run into this file for the textfile collector of the node exporter, e.g. /var/lib/node_exporter/datadog_backup.prom"`
+                PostDrift      bool          `long:"post-drift-events" description:"serve: post detected drift as datadog event"`
+                NotifiersFile  string        `long:"notifiers-file" description:"yaml file with the webhook, slack and email notifiers of drift, push failures and prune previews"`
+                LogFormat      string        `long:"log-format" choice:"text" choice:"json" default:"text" description:"format of the log lines, text is colored on a terminal"`
+                LogLevel       string        `long:"log-level" choice:"trace" choice:"debug" choice:"info" choice:"warn" choice:"error" default:"info" description:"minimum level of logged lines"`
+                IdMapFile      string        `long:"id-map-file" description:"name of a yaml file in the backup dir mapping ids of elements to the ids they got when push re-created them, read and extended by push to keep references valid, requires backups"`
+        }
+        setupLogging("text", "info")
+        args, err := flags.Parse(&opts)
         fatalOnError(err, "cannot parse args")
+        setupLogging(opts.LogFormat, opts.LogLevel)
+        if opts.Action == History && len(args) != 2 {
+                logrus.Fatalf("usage: --action history <type> <id|name>")
+        }
+        if opts.Action == Rollback && len(args) != 1 {
+                logrus.Fatalf("usage: --action rollback <journal>")
+        }
+
+        if opts.Action != Validate && opts.Action != Lint && opts.Action != History && (opts.DataDogApiKey == "" || opts.DataDogAppKey == "") {
+                logrus.Fatalf("--api-key and --app-key are required for action %s", opts.Action)
+        }
 
         if opts.DryRun {
                 logrus.Infof("starting dry run, no changes will be made")
         }
 
+        // requests are bound to the abort context, which only the second signal cancels, so the
+        // rollback of a push that timed out can still reach datadog and the backup dir. The run
+        // context stops the run on --timeout, in-flight requests end by --request-timeout.
+        abortCtx, abort := context.WithCancel(context.Background())
+        defer abort()
+        var ctx context.Context
+        var cancel context.CancelFunc
+        if opts.Timeout > 0 {
+                ctx, cancel = context.WithTimeout(abortCtx, opts.Timeout)
+        } else {
+                ctx, cancel = context.WithCancel(abortCtx)
+        }
+        defer cancel()
+
+        var rules []internal.Rule
+        if opts.BuiltinRules {
+                rules = internal.BuiltinRules()
+        }
+        if opts.RulesFile != "" {
+                customRules, err := internal.LoadRules(opts.RulesFile)
+                fatalOnError(err, "rules")
+                rules = append(rules, customRules...)
+        }
+
+        var notifiers []internal.Notifier
+        if opts.NotifiersFile != "" {
+                notifiers, err = internal.LoadNotifiers(opts.NotifiersFile)
+                fatalOnError(err, "notifiers")
+        }
+
+        var deleteList *internal.DeleteList
+        if opts.DeleteListFile != "" {
+                deleteList, err = internal.LoadDeleteList(opts.DeleteListFile)
+                fatalOnError(err, "delete list")
+        }
+
+        // destructive changes are confirmed one by one, without a terminal they need --yes. A plain
+        // push only creates elements, the updates of elements matched by identity are skipped and
+        // make the run a partial failure.
+        var confirmation *internal.Confirmation
+        if !opts.Yes && !opts.DryRun && (opts.Action == Push || opts.Action == Delete || opts.Action == Prune || opts.Action == Rollback) {
+                if isTerminal(os.Stdin) {
+                        confirmation = internal.NewConfirmation(os.Stdin, os.Stderr)
+                } else if opts.Action != Push || opts.OverrideRemote || opts.RollbackOnErr {
+                        logrus.Fatalf("refusing to %s without --yes, stdin is not a terminal to confirm the changes", opts.Action)
+                } else {
+                        logrus.Warn("stdin is not a terminal, updates of remote elements matched by identity are skipped without --yes and fail the run partially")
+                }
+        }
+
+        metrics := internal.NewMetrics()
+        // the outer transport aborts requests and rate limit waits when the run is aborted, the
+        // inner one limits every single attempt
+        rateLimitTransport := internal.NewRateLimitTransport(
+                internal.NewMetricsTransport(internal.NewContextTransport(abortCtx, opts.RequestTimeout, http.DefaultTransport), metrics), opts.MaxRetries)
+        metrics.CollectRateLimits(rateLimitTransport.Stats)
         ddClient = datadog.NewClient(opts.DataDogApiKey, opts.DataDogAppKey)
+        ddClient.HttpClient = &http.Client{Transport: internal.NewContextTransport(abortCtx, 0, rateLimitTransport)}
+        // retries are left to the rate limit transport, the client library would retry failed GET
+        // requests on top of it for up to its retry timeout, 0 would retry them forever
+        ddClient.RetryTimeout = time.Nanosecond
         backupClient := internal.NewBackupService(ddClient, internal.BackupConfig{
-                ConfigDir:      opts.ConfigDir,
-                BackupDir:      opts.BackupDir,
-                DryRun:         opts.DryRun,
-                OverrideRemote: opts.OverrideRemote,
-                DoBackup:       !opts.NoBackup,
+                ConfigDir:             opts.ConfigDir,
+                BackupDir:             opts.BackupDir,
+                DryRun:                opts.DryRun,
+                OverrideRemote:        opts.OverrideRemote,
+                DoBackup:              !opts.NoBackup,
+                Concurrency:           opts.Concurrency,
+                OwnerTag:              opts.OwnerTag,
+                Rules:                 rules,
+                BlockPushOnLintErrors: opts.BlockOnLint,
+                IdMapFile:             opts.IdMapFile,
+                PushExpiredDowntimes:  opts.PushExpired,
+                Metrics:               metrics,
+                Notifiers:             notifiers,
+                Confirmation:          confirmation,
+                AssumeYes:             opts.Yes,
+                RollbackOnError:       opts.RollbackOnErr,
+                DeleteList:            deleteList,
+                ApiKey:                opts.DataDogApiKey,
+                AppKey:                opts.DataDogAppKey,
+                Filter: internal.FilterConfig{
+                        Types:      opts.Types,
+                        Tags:       opts.Tags,
+                        NameRegex:  opts.NameRegex,
+                        IncludeIds: opts.Ids,
+                        ExcludeIds: opts.ExcludeIds,
+                },
+                Storage: internal.StorageConfig{
+                        S3Endpoint:     opts.S3Endpoint,
+                        S3Region:       opts.S3Region,
+                        Context:        abortCtx,
+                        RequestTimeout: opts.RequestTimeout,
+                },
         })
 
+        go handleSignals(backupClient, abort)
+
+        var action string
         switch opts.Action {
         case "push":
-                err := backupClient.Push()
-                fatalOnError(err, "push")
-                err = backupClient.Pull()
-                fatalOnError(err, "push-pull")
+                action = "push"
+                err = backupClient.Push(ctx)
+                if err == nil {
+                        action = "push-pull"
+                        backupClient.Report().Refresh()
+                        err = backupClient.Pull(ctx)
+                }
         case "pull":
-                err := backupClient.Pull()
-                fatalOnError(err, "pull")
+                action = "pull"
+                err = backupClient.Pull(ctx)
         case "delete":
-                err := backupClient.Delete()
-                fatalOnError(err, "delete")
+                action = "delete"
+                err = backupClient.Delete(ctx)
+        case "diff":
+                action = "diff"
+                var diffs []internal.ElementDiff
+                diffs, err = backupClient.Diff(ctx)
+                logrus.Infof("found %d difference(s) between config and datadog", len(diffs))
+        case "prune":
+                action = "prune"
+                err = backupClient.Prune(ctx)
+        case "adopt":
+                action = "adopt"
+                err = backupClient.Adopt(ctx)
+        case "validate":
+                action = "validate"
+                var validationErrors []internal.ValidationError
+                validationErrors, err = backupClient.Validate()
+                if err == nil && len(validationErrors) > 0 {
+                        err = errors.Errorf("found %d validation error(s)", len(validationErrors))
+                }
+        case "lint":
+                action = "lint"
+                var findings []internal.LintFinding
+                findings, err = backupClient.Lint()
+                logrus.Infof("found %d lint finding(s)", len(findings))
+                if errorCount := internal.CountErrors(findings); err == nil && errorCount > 0 {
+                        err = errors.Errorf("found %d lint error(s)", errorCount)
+                }
+        case "serve":
+                action = "serve"
+                var schedule internal.Schedule
+                if schedule, err = internal.ParseSchedule(opts.Schedule); err == nil {
+                        daemon := internal.NewDaemon(backupClient, schedule, opts.PostDrift)
+                        var server *http.Server
+                        if server, err = serveHttp(opts.ListenAddress, metrics, daemon, opts.ApiToken); err == nil {
+                                err = daemon.Run(ctx)
+                                shutdownHttp(server)
+                        }
+                }
+                // stopping is the regular end of serve
+                if errors.Is(err, internal.ErrStopped) {
+                        err = nil
+                }
+        case "rollback":
+                action = "rollback"
+                err = backupClient.Rollback(ctx, args[0])
+        case "history":
+                action = "history"
+                _, err = backupClient.History(os.Stdout, args[0], args[1])
+        case "references":
+                action = "references"
+                var broken []internal.BrokenReference
+                broken, err = backupClient.References(ctx)
+                if err == nil && len(broken) > 0 {
+                        err = errors.Errorf("found %d broken reference(s)", len(broken))
+                }
+        }
+
+        stats := rateLimitTransport.Stats()
+        logrus.Infof("%d request(s) were throttled by datadog rate limits, %d request(s) were retried", stats.Throttled, stats.Retries)
+
+        if opts.MetricsFile != "" {
+                if metricsErr := metrics.WriteTextfile(opts.MetricsFile); metricsErr != nil {
+                        logrus.WithError(metricsErr).Error("metrics")
+                }
+        }
+
+        // from here on the exit code is the status of the run, even if the report cannot be written
+        report := backupClient.Report()
+        status := report.Finish(err)
+        if reportErr := report.WriteTable(os.Stdout); reportErr != nil {
+                logrus.WithError(reportErr).Error("report")
         }
+        if opts.ReportFile != "" {
+                if reportErr := writeReportFile(report, opts.ReportFile); reportErr != nil {
+                        logrus.WithError(reportErr).Error("report")
+                        status = internal.StatusFailure
+                }
+        }
+        if errors.Is(err, internal.ErrStopped) {
+                logrus.WithError(err).Warn(action)
+        } else if err != nil {
+                logrus.WithError(err).Error(action)
+        }
+
+        switch status {
+        case internal.StatusPartialFailure:
+                os.Exit(exitPartialFailure)
+        case internal.StatusFailure:
+                os.Exit(exitFailure)
+        }
+}
+
+// setupLogging sets the format and level of the log, text is only colored if the log is written
+// to a terminal
+func setupLogging(format, level string) {
+        if format == "json" {
+                logrus.SetFormatter(&logrus.JSONFormatter{})
+        } else {
+                terminal := isTerminal(logrus.StandardLogger().Out)
+                logrus.SetFormatter(&prefixed.TextFormatter{
+                        FullTimestamp:   true,
+                        DisableSorting:  false,
+                        ForceFormatting: true,
+                        ForceColors:     terminal,
+                        DisableColors:   !terminal,
+                })
+        }
+        logLevel, err := logrus.ParseLevel(level)
+        fatalOnError(err, "invalid log level")
+        logrus.SetLevel(logLevel)
+}
+
+func isTerminal(w io.Writer) bool {
+        file, ok := w.(*os.File)
+        return ok && terminal.IsTerminal(int(file.Fd()))
+}
+
+func writeReportFile(report *internal.Report, name string) error {
+        file, err := os.Create(name)
+        if err != nil {
+                return err
+        }
+        if err = report.WriteJSON(file); err != nil {
+                _ = file.Close()
+                return err
+        }
+        return file.Close()
+}
+
+// serveHttp starts the http server of serve in the background, it fails the run if the
+// address cannot be listened on. The api is only served if a token is set.
+func serveHttp(address string, metrics *internal.Metrics, daemon *internal.Daemon, apiToken string) (*http.Server, error) {
+        mux := http.NewServeMux()
+        mux.Handle("/metrics", metrics)
+        if apiToken != "" {
+                mux.Handle("/api/", internal.NewApiServer(daemon, apiToken))
+        }
+        server := &http.Server{Addr: address, Handler: mux}
+        listener, err := net.Listen("tcp", address)
+        if err != nil {
+                return nil, errors.WithMessage(err, "http server")
+        }
+        go func() {
+                if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+                        logrus.WithError(err).Error("http server")
+                }
+        }()
+        logrus.Infof("serving metrics on %s/metrics", address)
+        if apiToken != "" {
+                logrus.Infof("serving api on %s/api/", address)
+        }
+        return server, nil
+}
+
+func shutdownHttp(server *http.Server) {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        if err := server.Shutdown(ctx); err != nil {
+                logrus.WithError(err).Warn("http server shutdown")
+        }
+}
 
+// handleSignals stops the backup service gracefully on the first SIGINT or SIGTERM and aborts
+// everything on the second one
+func handleSignals(backupClient interface{ Stop() }, cancel context.CancelFunc) {
+        signals := make(chan os.Signal, 2)
+        signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+        sig := <-signals
+        logrus.Warnf("received %s, finishing the element in flight, send again to abort immediately", sig)
+        backupClient.Stop()
+        sig = <-signals
+        logrus.Warnf("received %s, aborting", sig)
+        cancel()
 }
 
 func fatalOnError(err error, msg string) {
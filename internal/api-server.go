@@ -0,0 +1,171 @@
+package internal
+
+import (
+        "crypto/subtle"
+        "encoding/json"
+        "github.com/sirupsen/logrus"
+        "gopkg.in/yaml.v3"
+        "net/http"
+        "strings"
+)
+
+// ApiServer serves the http api of serve: trigger pull and diff, list snapshots, show an element
+// of a snapshot and diff two snapshots. Every request needs the token as bearer token.
+//
+//      POST /api/pull
+//      POST /api/diff
+//      GET  /api/snapshots
+//      GET  /api/snapshots/<snapshot>/<type>/<id or name>
+//      GET  /api/diff/<type>?from=<snapshot>&to=<snapshot>
+//
+// Snapshots are the unix times of the backup files or current for the config files. The backup
+// files of a snapshot are written before a pull replaces the config files, so a snapshot holds
+// the state pulled at the previous snapshot: /api/snapshots lists its time and pulled, the time
+// its content is from.
+type ApiServer struct {
+        daemon *Daemon
+        token  string
+        log    *logrus.Entry
+        mux    *http.ServeMux
+}
+
+func NewApiServer(daemon *Daemon, token string) *ApiServer {
+        s := &ApiServer{
+                daemon: daemon,
+                token:  token,
+                log:    logrus.WithField("prefix", "api"),
+                mux:    http.NewServeMux(),
+        }
+        s.mux.HandleFunc("/api/pull", s.post(s.pull))
+        s.mux.HandleFunc("/api/diff", s.post(s.diff))
+        s.mux.HandleFunc("/api/diff/", s.get(s.diffSnapshots))
+        s.mux.HandleFunc("/api/snapshots", s.get(s.snapshots))
+        s.mux.HandleFunc("/api/snapshots/", s.get(s.element))
+        return s
+}
+
+func (s *ApiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+        if !s.authorized(r) {
+                w.Header().Set("WWW-Authenticate", "Bearer")
+                s.error(w, http.StatusUnauthorized, "missing or invalid token")
+                return
+        }
+        s.mux.ServeHTTP(w, r)
+}
+
+func (s *ApiServer) authorized(r *http.Request) bool {
+        header := r.Header.Get("Authorization")
+        if !strings.HasPrefix(header, "Bearer ") {
+                return false
+        }
+        token := strings.TrimPrefix(header, "Bearer ")
+        return s.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+func (s *ApiServer) pull(w http.ResponseWriter, r *http.Request) {
+        if err := s.daemon.Pull(r.Context()); err != nil {
+                s.error(w, http.StatusInternalServerError, err.Error())
+                return
+        }
+        s.json(w, map[string]string{"status": "pulled"})
+}
+
+func (s *ApiServer) diff(w http.ResponseWriter, r *http.Request) {
+        diffs, err := s.daemon.Diff(r.Context())
+        if err != nil {
+                s.error(w, http.StatusInternalServerError, err.Error())
+                return
+        }
+        s.json(w, nonNil(diffs))
+}
+
+func (s *ApiServer) diffSnapshots(w http.ResponseWriter, r *http.Request) {
+        configType := strings.TrimPrefix(r.URL.Path, "/api/diff/")
+        from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+        if configType == "" || from == "" || to == "" {
+                s.error(w, http.StatusBadRequest, "usage: /api/diff/<type>?from=<snapshot>&to=<snapshot>")
+                return
+        }
+        diffs, err := s.daemon.service.DiffSnapshots(configType, from, to)
+        if err != nil {
+                s.error(w, http.StatusNotFound, err.Error())
+                return
+        }
+        s.json(w, nonNil(diffs))
+}
+
+func (s *ApiServer) snapshots(w http.ResponseWriter, r *http.Request) {
+        snapshots, err := s.daemon.service.Snapshots()
+        if err != nil {
+                s.error(w, http.StatusInternalServerError, err.Error())
+                return
+        }
+        s.json(w, snapshots)
+}
+
+func (s *ApiServer) element(w http.ResponseWriter, r *http.Request) {
+        parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/snapshots/"), "/", 3)
+        if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+                s.error(w, http.StatusBadRequest, "usage: /api/snapshots/<snapshot>/<type>/<id or name>")
+                return
+        }
+        element, err := s.daemon.service.SnapshotElement(parts[0], parts[1], parts[2])
+        if err != nil {
+                s.error(w, http.StatusNotFound, err.Error())
+                return
+        }
+        if element == nil {
+                s.error(w, http.StatusNotFound, "no element "+parts[2]+" in snapshot "+parts[0])
+                return
+        }
+        content, err := yaml.Marshal(element)
+        if err != nil {
+                s.error(w, http.StatusInternalServerError, err.Error())
+                return
+        }
+        w.Header().Set("Content-Type", "application/yaml")
+        _, _ = w.Write(content)
+}
+
+func (s *ApiServer) get(handler http.HandlerFunc) http.HandlerFunc {
+        return s.method(http.MethodGet, handler)
+}
+
+func (s *ApiServer) post(handler http.HandlerFunc) http.HandlerFunc {
+        return s.method(http.MethodPost, handler)
+}
+
+func (s *ApiServer) method(method string, handler http.HandlerFunc) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+                if r.Method != method {
+                        w.Header().Set("Allow", method)
+                        s.error(w, http.StatusMethodNotAllowed, "use "+method)
+                        return
+                }
+                s.log.Infof("%s %s", r.Method, r.URL.Path)
+                handler(w, r)
+        }
+}
+
+func (s *ApiServer) json(w http.ResponseWriter, value interface{}) {
+        w.Header().Set("Content-Type", "application/json")
+        encoder := json.NewEncoder(w)
+        encoder.SetIndent("", "  ")
+        if err := encoder.Encode(value); err != nil {
+                s.log.WithError(err).Error("cannot write response")
+        }
+}
+
+func (s *ApiServer) error(w http.ResponseWriter, status int, message string) {
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(status)
+        _ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// nonNil lets empty diffs be encoded as an empty list instead of null
+func nonNil(diffs []ElementDiff) []ElementDiff {
+        if diffs == nil {
+                return []ElementDiff{}
+        }
+        return diffs
+}
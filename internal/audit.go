@@ -0,0 +1,167 @@
+package internal
+
+import (
+        "context"
+        "fmt"
+        "github.com/pkg/errors"
+        "net/http"
+        "strconv"
+        "strings"
+        "time"
+)
+
+// AuditEntry is the last change of an element in the datadog audit trail
+type AuditEntry struct {
+        Author string    `json:"author" yaml:"author"`
+        Action string    `json:"action" yaml:"action"`
+        Time   time.Time `json:"time" yaml:"time"`
+}
+
+func (e AuditEntry) String() string {
+        return fmt.Sprintf("%s by %s at %s", e.Action, e.Author, e.Time.Format(time.RFC3339))
+}
+
+// auditAssetTypes are the asset types of the config types in the audit trail
+var auditAssetTypes = map[string]string{
+        "monitors":   "monitor",
+        "dashboards": "dashboard",
+        "downtimes":  "downtime",
+}
+
+// auditLookback limits the search if there is no snapshot yet, it is the retention of the audit trail
+const auditLookback = 90 * 24 * time.Hour
+
+// auditBatchSize limits the number of ids in one audit query
+const auditBatchSize = 50
+
+type auditSearchRequest struct {
+        Filter struct {
+                Query string `json:"query"`
+                From  string `json:"from"`
+                To    string `json:"to"`
+        } `json:"filter"`
+        Page struct {
+                Cursor string `json:"cursor,omitempty"`
+                Limit  int    `json:"limit"`
+        } `json:"page"`
+        Sort string `json:"sort"`
+}
+
+type auditSearchResponse struct {
+        Data []struct {
+                Attributes struct {
+                        Timestamp  time.Time `json:"timestamp"`
+                        Attributes struct {
+                                Action string `json:"action"`
+                                Usr    struct {
+                                        Email string `json:"email"`
+                                        Name  string `json:"name"`
+                                } `json:"usr"`
+                                Asset struct {
+                                        Id   string `json:"id"`
+                                        Type string `json:"type"`
+                                } `json:"asset"`
+                        } `json:"attributes"`
+                } `json:"attributes"`
+        } `json:"data"`
+        Meta struct {
+                Page struct {
+                        After string `json:"after"`
+                } `json:"page"`
+        } `json:"meta"`
+}
+
+// lastAuditEntries returns the newest audit entry of each of the element ids of an asset type
+// since the given time
+func (a *apiClient) lastAuditEntries(ctx context.Context, assetType string, ids []int, since time.Time) (map[int]AuditEntry, error) {
+        entries := map[int]AuditEntry{}
+        for start := 0; start < len(ids); start += auditBatchSize {
+                end := start + auditBatchSize
+                if end > len(ids) {
+                        end = len(ids)
+                }
+                var idQuery []string
+                for _, id := range ids[start:end] {
+                        idQuery = append(idQuery, strconv.Itoa(id))
+                }
+                request := auditSearchRequest{Sort: "-timestamp"}
+                request.Filter.Query = fmt.Sprintf("@asset.type:%s @asset.id:(%s)", assetType, strings.Join(idQuery, " OR "))
+                request.Filter.From = since.UTC().Format(time.RFC3339)
+                request.Filter.To = "now"
+                request.Page.Limit = 100
+                for {
+                        var response auditSearchResponse
+                        if err := a.doJson(ctx, "POST", "/api/v2/audit/events/search", &request, &response); err != nil {
+                                return entries, err
+                        }
+                        for _, event := range response.Data {
+                                attributes := event.Attributes.Attributes
+                                id, err := strconv.Atoi(attributes.Asset.Id)
+                                if err != nil {
+                                        continue
+                                }
+                                // events are sorted newest first
+                                if _, ok := entries[id]; ok {
+                                        continue
+                                }
+                                author := attributes.Usr.Email
+                                if author == "" {
+                                        author = attributes.Usr.Name
+                                }
+                                entries[id] = AuditEntry{Author: author, Action: attributes.Action, Time: event.Attributes.Timestamp}
+                        }
+                        if response.Meta.Page.After == "" || len(response.Data) == 0 {
+                                break
+                        }
+                        request.Page.Cursor = response.Meta.Page.After
+                }
+        }
+        return entries, nil
+}
+
+// annotateDrift adds the author and time of the last change since the given time to the drift of
+// one config type. The audit trail is optional, without access to it the drift stays as it is.
+func (b *backupService) annotateDrift(ctx context.Context, configType string, diffs []ElementDiff, since time.Time) {
+        assetType, ok := auditAssetTypes[configType]
+        if !ok {
+                return
+        }
+        var ids []int
+        for _, diff := range diffs {
+                if diff.Kind == DiffChanged || diff.Kind == DiffCreated || diff.Kind == DiffDeleted {
+                        ids = append(ids, diff.Id)
+                }
+        }
+        if len(ids) == 0 {
+                return
+        }
+        if since.IsZero() || time.Since(since) > auditLookback {
+                since = time.Now().Add(-auditLookback)
+        }
+        entries, err := b.api.lastAuditEntries(ctx, assetType, ids, since)
+        var apiError *APIError
+        if errors.As(err, &apiError) && (apiError.StatusCode == http.StatusForbidden || apiError.StatusCode == http.StatusNotFound) {
+                b.log.WithField("client", configType).Debugf("audit trail is not available: %s", err)
+                return
+        } else if err != nil {
+                b.log.WithField("client", configType).WithError(err).Warn("cannot read the audit trail, the drift is not fully annotated")
+        }
+        for i := range diffs {
+                if entry, ok := entries[diffs[i].Id]; ok {
+                        diffs[i].Audit = &entry
+                }
+        }
+}
+
+// lastSnapshotTime returns the time of the newest backup, which is the time of the last pull,
+// or the zero time if there is none
+func (b *backupService) lastSnapshotTime() time.Time {
+        if b.backupStorage == nil {
+                return time.Time{}
+        }
+        snapshots, err := b.Snapshots()
+        if err != nil || len(snapshots) == 0 {
+                return time.Time{}
+        }
+        return snapshots[len(snapshots)-1].Time
+}
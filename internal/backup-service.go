@@ -1,6 +1,7 @@
 package internal
 
 import (
+        "context"
         "fmt"
         "github.com/pkg/errors"
         "github.com/sirupsen/logrus"
@@ -8,20 +9,44 @@ import (
         "gopkg.in/yaml.v3"
         "io"
         "io/ioutil"
-        "os"
+        "net/http"
+        "sync"
         "time"
 )
 
+// ErrStopped is returned by actions which were stopped gracefully with Stop
+var ErrStopped = errors.New("stopped")
+
 type backupService struct {
         ddClient       *datadog.Client
+        api            *apiClient
         log            *logrus.Entry
         overrideRemote bool
         dryRun         bool
         backup         bool
         configClients  []DatadogConfigClient
+        filter         *Filter
+        owner          string
+        linter         *Linter
+        blockOnLint    bool
+        report         *Report
+        idMap          *IdMap
+        idMapFile      string
+        metrics        *Metrics
+        notifiers      []Notifier
+        confirmation   *Confirmation
+        assumeYes      bool
+        // journal records the changes of the running push if backups are enabled
+        journal         *Journal
+        journalName     string
+        rollbackOnError bool
+        deleteList      *DeleteList
+
+        configStorage Storage
+        backupStorage Storage
 
-        configDir string
-        backupDir string
+        stop     chan struct{}
+        stopOnce sync.Once
 }
 
 type BackupConfig struct {
@@ -30,241 +55,972 @@ type BackupConfig struct {
         DryRun         bool
         OverrideRemote bool
         DoBackup       bool
+        Concurrency    int
+        Storage        StorageConfig
+        Filter         FilterConfig
+        // OwnerTag scopes the service to the elements carrying it, created elements get it added
+        OwnerTag string
+        // Rules are checked by lint and before push, push is aborted on errors if BlockPushOnLintErrors is set
+        Rules                 []Rule
+        BlockPushOnLintErrors bool
+        // ApiKey and AppKey are used for the endpoints the datadog client library does not support
+        ApiKey string
+        AppKey string
+        // PushExpiredDowntimes pushes downtimes which already ended, they are skipped by default
+        PushExpiredDowntimes bool
+        // Metrics collects pulls and drift if set
+        Metrics *Metrics
+        // Notifiers are notified about drift, push failures and prune previews
+        Notifiers []Notifier
+        // Confirmation asks before each destructive change. Without it they are only applied with
+        // AssumeYes and skipped otherwise.
+        Confirmation *Confirmation
+        AssumeYes    bool
+        // RollbackOnError reverts the changes of a push which failed, using its journal
+        RollbackOnError bool
+        // DeleteList selects the elements delete removes, in addition to the filter
+        DeleteList *DeleteList
+        // IdMapFile is the name of the file in the backup dir keeping the ids of elements re-created
+        // by push, it is read and extended by every push
+        IdMapFile string
 }
 
 func NewBackupService(ddClient *datadog.Client, config BackupConfig) *backupService {
+        api := newApiClient(ddClient, config.ApiKey, config.AppKey)
         service := &backupService{
-                ddClient:       ddClient,
-                log:            logrus.WithField("prefix", "backup-service"),
-                overrideRemote: config.OverrideRemote,
-                dryRun:         config.DryRun,
-                backup:         config.DoBackup,
-                configDir:      config.ConfigDir,
-                backupDir:      config.BackupDir,
+                ddClient:        ddClient,
+                api:             api,
+                log:             logrus.WithField("prefix", "backup-service"),
+                overrideRemote:  config.OverrideRemote,
+                dryRun:          config.DryRun,
+                backup:          config.DoBackup,
+                stop:            make(chan struct{}),
+                report:          NewReport(),
+                idMap:           NewIdMap(),
+                idMapFile:       config.IdMapFile,
+                metrics:         config.Metrics,
+                notifiers:       config.Notifiers,
+                confirmation:    config.Confirmation,
+                assumeYes:       config.AssumeYes,
+                rollbackOnError: config.RollbackOnError,
+                deleteList:      config.DeleteList,
+                owner:           config.OwnerTag,
+                blockOnLint:     config.BlockPushOnLintErrors,
                 configClients: []DatadogConfigClient{
-                        NewMonitorsClient(ddClient),
-                        NewDashboardsClient(ddClient),
-                        NewDowntimesClient(ddClient),
+                        NewMonitorsClient(ddClient, api),
+                        NewDashboardsClient(ddClient, config.Concurrency),
+                        NewDowntimesClient(ddClient, config.PushExpiredDowntimes),
                 },
         }
-        if _, err := os.Stat(service.configDir); os.IsNotExist(err) {
+        var err error
+        if service.filter, err = NewFilter(config.Filter); err != nil {
+                service.log.WithError(err).Fatal("invalid filter")
+        }
+        if service.linter, err = NewLinter(config.Rules); err != nil {
+                service.log.WithError(err).Fatal("invalid rules")
+        }
+        if len(service.clients()) == 0 {
+                service.log.Fatal("type filter matches none of the config types")
+        }
+        if service.configStorage, err = NewStorage(config.ConfigDir, config.Storage); err != nil {
                 service.log.WithError(err).Fatal("config dir does not exist")
         }
-        if _, err := os.Stat(service.backupDir); os.IsNotExist(err) && service.backup {
-                service.log.WithError(err).Fatal("backup dir does not exist")
+        if service.backup {
+                if service.backupStorage, err = NewStorage(config.BackupDir, config.Storage); err != nil {
+                        service.log.WithError(err).Fatal("backup dir does not exist")
+                }
+        } else if service.idMapFile != "" {
+                service.log.Fatal("the id map file is kept in the backup dir, it requires backups")
         }
         return service
 }
 
-func (b *backupService) Pull() error {
-        for _, c := range b.configClients {
-                if err := b.pull(c); err != nil {
-                        return errors.WithMessagef(err, "pull client %s", c.ConfigClientName())
+// Stop lets running actions finish the element in flight and then return ErrStopped, in contrast
+// to cancelling the context, which aborts requests in flight as well
+func (b *backupService) Stop() {
+        b.stopOnce.Do(func() {
+                close(b.stop)
+        })
+}
+
+// validateRemote lets datadog validate every element of the config files, before anything is
+// written. It returns an error if any element is invalid.
+func (b *backupService) validateRemote(ctx context.Context) error {
+        invalid := 0
+        for _, c := range b.clients() {
+                validator, ok := c.(RemoteValidator)
+                if !ok {
+                        continue
+                }
+                logger := b.log.WithFields(logrus.Fields{"client": c.ConfigClientName(), "action": "validate-remote"})
+                configElements, err := b.readConfigFileIfExists(c)
+                if err != nil {
+                        return errors.WithMessage(err, "validate remote")
                 }
+                for _, e := range b.filter.Apply(configElements) {
+                        if err := b.interrupted(ctx); err != nil {
+                                return errors.WithMessage(err, "validate remote")
+                        }
+                        logger := withElement(logger, e)
+                        err := validator.ValidateRemote(ctx, e)
+                        var apiError *APIError
+                        if errors.As(err, &apiError) && apiError.StatusCode == http.StatusBadRequest {
+                                logger.WithError(err).Errorf("validate remote: element %d (%s) is invalid", e.GetId(), e.GetName())
+                                invalid++
+                        } else if err != nil {
+                                return errors.WithMessagef(err, "validate remote: cannot validate element %d (%s)", e.GetId(), e.GetName())
+                        }
+                }
+        }
+        if invalid > 0 {
+                return errors.Errorf("%d element(s) were rejected by datadog validation, nothing was pushed", invalid)
         }
         return nil
 }
 
-func (b *backupService) Push() error {
-        for _, c := range b.configClients {
-                if err := b.push(c); err != nil {
-                        return errors.WithMessagef(err, "push client %s", c.ConfigClientName())
+// Diff compares the local config files with the remote elements
+func (b *backupService) Diff(ctx context.Context) ([]ElementDiff, error) {
+        var diffs []ElementDiff
+        for _, c := range b.clients() {
+                if err := b.interrupted(ctx); err != nil {
+                        return diffs, errors.WithMessagef(err, "diff client %s", c.ConfigClientName())
                 }
+                clientDiffs, err := b.diff(ctx, c)
+                if err != nil {
+                        return diffs, errors.WithMessagef(err, "diff client %s", c.ConfigClientName())
+                }
+                diffs = append(diffs, clientDiffs...)
+        }
+        b.notifyDrift(diffs)
+        return diffs, nil
+}
+
+// Prune deletes the remote elements of the owner which are not in the local config files
+func (b *backupService) Prune(ctx context.Context) error {
+        if b.owner == "" {
+                return errors.New("prune: an owner tag is required to prune")
+        }
+        // a missing config file would look like an empty one and prune every owned element
+        for _, c := range b.clients() {
+                name := b.configFileName(c.ConfigClientName())
+                exists, err := b.configStorage.Exists(name)
+                if err != nil {
+                        return errors.WithMessagef(err, "prune client %s", c.ConfigClientName())
+                }
+                if !exists {
+                        return errors.Errorf("prune: there is no config file %s, select the types to prune with --type", b.configStorage.Location(name))
+                }
+        }
+        // elements re-created by push have new ids, which are not in the config files
+        if err := b.loadIdMap(); err != nil {
+                return errors.WithMessage(err, "prune")
+        }
+        var pruned []ElementDiff
+        for _, c := range b.clients() {
+                clientPruned, err := b.prune(ctx, c)
+                pruned = append(pruned, clientPruned...)
+                if err != nil {
+                        return errors.WithMessagef(err, "prune client %s", c.ConfigClientName())
+                }
+        }
+        if b.dryRun && len(pruned) > 0 {
+                b.notify(Notification{
+                        Event: EventPrunePreview,
+                        Title: fmt.Sprintf("datadog-backup prune would delete %d element(s) missing in the config files", len(pruned)),
+                        Diffs: pruned,
+                })
         }
         return nil
 }
 
-func (b *backupService) Delete() error {
+// Adopt adds the owner marker to the remote elements selected by the filter
+func (b *backupService) Adopt(ctx context.Context) error {
+        if b.owner == "" {
+                return errors.New("adopt: an owner tag is required to adopt elements")
+        }
+        for _, c := range b.clients() {
+                if err := b.adopt(ctx, c); err != nil {
+                        return errors.WithMessagef(err, "adopt client %s", c.ConfigClientName())
+                }
+        }
+        return nil
+}
+
+func (b *backupService) diff(ctx context.Context, client DatadogConfigClient) ([]ElementDiff, error) {
+        configType := client.ConfigClientName()
+        logger := b.log.WithFields(logrus.Fields{"client": configType, "action": "diff"})
+
+        localElements, err := b.readConfigFileIfExists(client)
+        if err != nil {
+                return nil, errors.WithMessage(err, "diff")
+        }
+        remoteElements, err := b.remoteElements(ctx, client)
+        if err != nil {
+                return nil, errors.WithMessage(err, "diff")
+        }
+        diffs := DiffElements(configType, b.filter.Apply(localElements), remoteElements.Elements)
+        b.annotateDrift(ctx, configType, diffs, b.lastSnapshotTime())
+        b.report.AddDrift(diffs)
+        for _, d := range diffs {
+                logger := logger.WithFields(logrus.Fields{"element_id": d.Id, "element_name": d.Name, "outcome": d.Kind})
+                logger.Infof("diff: %s", d)
+                for _, change := range d.Changes {
+                        logger.Infof("diff:   %s: %q -> %q", change.Path, change.Local, change.Remote)
+                }
+        }
+        return diffs, nil
+}
+
+// prune returns the deleted elements, as elements which only exist remotely
+func (b *backupService) prune(ctx context.Context, client DatadogConfigClient) ([]ElementDiff, error) {
+        configType := client.ConfigClientName()
+        logger := b.log.WithFields(logrus.Fields{"client": configType, "action": "prune"})
+        var pruned []ElementDiff
+
+        localElements, err := b.readConfigFile(client)
+        if err != nil {
+                return pruned, errors.WithMessage(err, "prune")
+        }
+        localIds := map[int]bool{}
+        for _, e := range localElements {
+                id, _ := b.idMap.Lookup(configType, e.GetId())
+                localIds[id] = true
+        }
+        remoteElements, err := b.remoteElements(ctx, client)
+        if err != nil {
+                return pruned, errors.WithMessage(err, "prune")
+        }
+        for _, e := range remoteElements.Elements {
+                if localIds[e.GetId()] {
+                        continue
+                }
+                logger := withElement(logger, e)
+                if err := b.interrupted(ctx); err != nil {
+                        return pruned, errors.WithMessage(err, "prune")
+                }
+                if !b.confirm(describeChange("delete", configType, e, nil)) {
+                        logger.WithField("outcome", OutcomeSkipped).Warnf("prune: deleting element %d (%s) was not confirmed, skipping it", e.GetId(), e.GetName())
+                        b.report.Count(configType, OutcomeSkipped)
+                        continue
+                }
+                if !b.dryRun {
+                        if err := client.Delete(ctx, e.GetId()); err != nil {
+                                logger.WithField("outcome", OutcomeFailed).WithError(err).Errorf("prune: cannot delete element %d (%s)", e.GetId(), e.GetName())
+                                b.report.Fail(configType, e.GetId(), e.GetName(), err)
+                                continue
+                        }
+                }
+                logger.WithField("outcome", OutcomeDeleted).Infof("prune: deleted element %d (%s), it is not in the config file", e.GetId(), e.GetName())
+                b.report.Count(configType, OutcomeDeleted)
+                pruned = append(pruned, ElementDiff{Type: configType, Id: e.GetId(), Name: e.GetName(), Kind: DiffCreated})
+        }
+        return pruned, nil
+}
+
+func (b *backupService) adopt(ctx context.Context, client DatadogConfigClient) error {
+        configType := client.ConfigClientName()
+        logger := b.log.WithFields(logrus.Fields{"client": configType, "action": "adopt"})
+
+        remoteElements, err := client.GetAll(ctx)
+        var elementErrors ElementErrors
+        if err != nil && !errors.As(err, &elementErrors) {
+                return errors.WithMessage(err, "adopt")
+        }
+        for _, e := range b.filter.Apply(remoteElements.Elements) {
+                logger := withElement(logger, e)
+                if e.IsOwnedBy(b.owner) {
+                        logger.WithField("outcome", OutcomeSkipped).Debugf("adopt: element %d (%s) is owned by %s already", e.GetId(), e.GetName(), b.owner)
+                        b.report.Count(configType, OutcomeSkipped)
+                        continue
+                }
+                if err := b.interrupted(ctx); err != nil {
+                        return errors.WithMessage(err, "adopt")
+                }
+                e.SetOwner(b.owner)
+                if !b.dryRun {
+                        if err := client.Update(ctx, e); err != nil {
+                                logger.WithField("outcome", OutcomeFailed).WithError(err).Errorf("adopt: cannot update element %d (%s)", e.GetId(), e.GetName())
+                                b.report.Fail(configType, e.GetId(), e.GetName(), err)
+                                continue
+                        }
+                }
+                logger.WithField("outcome", OutcomeUpdated).Infof("adopt: element %d (%s) is now owned by %s", e.GetId(), e.GetName(), b.owner)
+                b.report.Count(configType, OutcomeUpdated)
+        }
+        return errors.WithMessage(err, "adopt")
+}
+
+// remoteElements loads all remote elements of the client which match the filter and belong to
+// the owner. Elements which could not be loaded are returned as ElementErrors together with the rest.
+func (b *backupService) remoteElements(ctx context.Context, client DatadogConfigClient) (*ConfigElements, error) {
+        configElements, err := client.GetAll(ctx)
+        var elementErrors ElementErrors
+        if err != nil && !errors.As(err, &elementErrors) {
+                return nil, err
+        }
+        configElements = configElements.Filter(b.filter)
+        if b.owner != "" {
+                owned := &ConfigElements{}
+                for i, e := range configElements.Elements {
+                        if e.IsOwnedBy(b.owner) {
+                                owned.Elements = append(owned.Elements, e)
+                                owned.Delegate = append(owned.Delegate, configElements.Delegate[i])
+                        }
+                }
+                configElements = owned
+        }
+        return configElements, err
+}
+
+// owned returns true if no owner is configured or the element belongs to it
+func (b *backupService) owned(e ConfigElement) bool {
+        return b.owner == "" || e.IsOwnedBy(b.owner)
+}
+
+// clients returns the config clients selected by the type filter
+func (b *backupService) clients() []DatadogConfigClient {
+        var clients []DatadogConfigClient
         for _, c := range b.configClients {
-                if err := b.delete(c); err != nil {
-                        return errors.WithMessagef(err, "delete client %s", c.ConfigClientName())
+                if b.filter.MatchesType(c.ConfigClientName()) {
+                        clients = append(clients, c)
+                }
+        }
+        return clients
+}
+
+// interrupted returns an error if the context is done or the service was stopped
+func (b *backupService) interrupted(ctx context.Context) error {
+        if err := ctx.Err(); err != nil {
+                return err
+        }
+        select {
+        case <-b.stop:
+                return ErrStopped
+        default:
+                return nil
+        }
+}
+
+// Report returns the outcome of the elements processed so far
+func (b *backupService) Report() *Report {
+        return b.report
+}
+
+func (b *backupService) Pull(ctx context.Context) error {
+        for _, c := range b.clients() {
+                if err := b.interrupted(ctx); err != nil {
+                        return errors.WithMessagef(err, "pull client %s", c.ConfigClientName())
+                }
+                if err := b.pull(ctx, c); err != nil {
+                        return errors.WithMessagef(err, "pull client %s", c.ConfigClientName())
                 }
         }
         return nil
 }
 
-func (b *backupService) push(client DatadogConfigClient) error {
-        logger := b.log.WithField("client", client.ConfigClientName())
-        if b.overrideRemote {
-                logger.Warnf("remote override active, will override remote monitors")
+// Push creates the elements of the config files which do not exist remotely, failures are
+// notified
+func (b *backupService) Push(ctx context.Context) error {
+        err := b.pushAll(ctx)
+        failures := b.report.failures()
+        failed := (err != nil && !errors.Is(err, ErrStopped)) || len(failures) > 0
+        if failed && b.rollbackOnError && b.journal != nil && b.journal.Len() > 0 {
+                b.log.Warnf("push: failed, rolling back %d change(s) of %s", b.journal.Len(), b.backupStorage.Location(b.journalName))
+                if rollbackErr := b.rollbackFailedPush(); rollbackErr != nil {
+                        b.log.WithError(rollbackErr).Error("push: rollback failed")
+                }
         }
+        if failed {
+                n := Notification{
+                        Event:    EventPushFailure,
+                        Title:    fmt.Sprintf("datadog-backup push failed for %d element(s)", len(failures)),
+                        Failures: failures,
+                }
+                if err != nil {
+                        n.Title = "datadog-backup push failed"
+                        n.Error = err.Error()
+                }
+                b.notify(n)
+        }
+        return err
+}
 
-        file, err := b.openConfigFile(b.configFilePath(client.ConfigClientName()), false, true)
+func (b *backupService) pushAll(ctx context.Context) error {
+        findings, err := b.Lint()
         if err != nil {
                 return errors.WithMessage(err, "push")
         }
-        defer closeQuietly(file)
+        if errorCount := CountErrors(findings); errorCount > 0 && b.blockOnLint {
+                return errors.Errorf("push: %d lint error(s), nothing was pushed", errorCount)
+        }
+        if err := b.validateRemote(ctx); err != nil {
+                return errors.WithMessage(err, "push")
+        }
+        if err := b.loadIdMap(); err != nil {
+                return errors.WithMessage(err, "push")
+        }
+        defer b.writeIdMap()
+        // all config files are loaded first, so referenced elements are created before the
+        // elements referring to them, the clients are ordered that way already
+        graph, elements, err := b.loadReferenceGraph(b.readConfigFile)
+        if err != nil {
+                return errors.WithMessage(err, "push")
+        }
+        broken, err := graph.BrokenRemote(ctx, b.configClients)
+        if err != nil {
+                return errors.WithMessage(err, "push")
+        }
+        for _, ref := range broken {
+                b.log.WithFields(logrus.Fields{"client": ref.Type, "action": "push", "element_id": ref.Id, "element_name": ref.Name}).Warnf("push: %s", ref)
+        }
+        if err := b.startJournal(ctx, elements); err != nil {
+                return errors.WithMessage(err, "push")
+        }
+        for _, c := range b.clients() {
+                configType := c.ConfigClientName()
+                if err := b.push(ctx, c, graph.Order(configType, elements[configType])); err != nil {
+                        return errors.WithMessagef(err, "push client %s", c.ConfigClientName())
+                }
+        }
+        return nil
+}
 
-        configElements, err := client.DecodeFile(file)
+// loadIdMap reads the ids of the elements re-created by previous pushes, if there is an id map file
+func (b *backupService) loadIdMap() error {
+        if b.idMapFile == "" {
+                return nil
+        }
+        idMap, err := LoadIdMap(b.backupStorage, b.idMapFile)
         if err != nil {
-                return errors.WithMessagef(err, "push: cannot decode file %s", file.Name())
+                return err
         }
+        b.idMap = idMap
+        return nil
+}
+
+// writeIdMap writes the ids of the elements re-created by push, even if push failed half way
+func (b *backupService) writeIdMap() {
+        if b.dryRun || b.idMapFile == "" || b.idMap.Len() == 0 {
+                return
+        }
+        if err := b.idMap.Write(b.backupStorage, b.idMapFile); err != nil {
+                b.log.WithError(err).Error("push: cannot write id map")
+                return
+        }
+        b.log.Infof("push: wrote %d re-created id(s) to %s", b.idMap.Len(), b.backupStorage.Location(b.idMapFile))
+}
 
+// Delete deletes the remote elements selected by the filter and the delete list. Their full
+// definitions are written to the backup dir first, pushing that file re-creates them.
+func (b *backupService) Delete(ctx context.Context) error {
+        if b.deleteList == nil && !b.filter.SelectsElements() {
+                return errors.New("delete: no elements are selected, select them by id, name or tags or with a delete list")
+        }
+        if b.filter.SelectsIds() && len(b.clients()) != 1 {
+                return errors.New("delete: ids are only unique within a config type, select exactly one type with --type when deleting by id")
+        }
+        if b.backupStorage == nil && !b.dryRun {
+                return errors.New("delete: backups are disabled, the deleted elements could not be restored")
+        }
+        if b.deleteList != nil {
+                for _, configType := range b.deleteList.Types() {
+                        if b.client(configType) == nil {
+                                return errors.Errorf("delete: unknown config type %s in the delete list", configType)
+                        }
+                }
+        }
+        for _, c := range b.clients() {
+                if b.deleteList != nil && !containsString(b.deleteList.Types(), c.ConfigClientName()) {
+                        continue
+                }
+                if err := b.delete(ctx, c); err != nil {
+                        return errors.WithMessagef(err, "delete client %s", c.ConfigClientName())
+                }
+        }
+        return nil
+}
+
+func (b *backupService) push(ctx context.Context, client DatadogConfigClient, configElements []ConfigElement) error {
+        configType := client.ConfigClientName()
+        logger := b.log.WithFields(logrus.Fields{"client": configType, "action": "push"})
+        if b.overrideRemote {
+                logger.Warnf("remote override active, will override remote monitors")
+        }
+
+        warnDuplicateNames(logger, configElements)
+
+        // remote elements known by id are never matched by identity to another local element
+        matcher := NewIdentityMatcher(configType)
         for _, configElement := range configElements {
+                if id := configElement.GetId(); id != -1 {
+                        mappedId, _ := b.idMap.Lookup(configType, id)
+                        matcher.Claim(mappedId)
+                }
+        }
+
+        var applied []string
+        for e, configElement := range configElements {
+                if err := b.interrupted(ctx); err != nil {
+                        logInterrupted(logger, "push", applied, configElements[e:])
+                        return errors.WithMessage(err, "push")
+                }
+                logger := withElement(logger, configElement)
                 name := configElement.GetName()
                 if name == "" {
-                        logger.Errorf("push: configElement %+v has no name, skipping", configElement.GetDelegate())
+                        logger.WithField("outcome", OutcomeSkipped).Errorf("push: configElement %+v has no name, skipping", configElement.GetDelegate())
+                        b.report.Count(configType, OutcomeSkipped)
                         continue
                 }
 
-                id := configElement.GetId()
+                if preparer, ok := client.(PushPreparer); ok {
+                        if reason := preparer.PreparePush(configElement); reason != "" {
+                                logger.WithField("outcome", OutcomeSkipped).Infof("push: skipping configElement %s, %s", name, reason)
+                                b.report.Count(configType, OutcomeSkipped)
+                                continue
+                        }
+                }
+
+                overridden := false
+                localId := configElement.GetId()
+                id := localId
+                if mappedId, ok := b.idMap.Lookup(configType, localId); ok && localId != -1 {
+                        logger.Debugf("push: configElement %d was re-created as %d by an earlier push", localId, mappedId)
+                        id = mappedId
+                }
                 if id != -1 {
-                        remoteElement, err := client.GetById(id)
+                        remoteElement, err := client.GetById(ctx, id)
                         if err == nil && remoteElement != nil {
+                                if !b.owned(remoteElement) {
+                                        logger.WithField("outcome", OutcomeSkipped).Warnf("push: existing configElement with id %d is not owned by %s, skipping it", id, b.owner)
+                                        b.report.Count(configType, OutcomeSkipped)
+                                        continue
+                                }
                                 if b.overrideRemote {
+                                        change := describeChange("delete and re-create", configType, remoteElement, DiffFields(configType, remoteElement, configElement))
+                                        if !b.confirm(change) {
+                                                logger.WithField("outcome", OutcomeSkipped).Warnf("push: overriding remote configElement %d was not confirmed, skipping it", id)
+                                                b.report.Count(configType, OutcomeSkipped)
+                                                continue
+                                        }
                                         if !b.dryRun {
-                                                err := b.ddClient.DeleteMonitor(id)
+                                                err := client.Delete(ctx, id)
                                                 if err != nil {
-                                                        logger.WithError(err).Errorf("push: cannot delete remote configElement %+v", configElement)
+                                                        logger.WithField("outcome", OutcomeFailed).WithError(err).Errorf("push: cannot delete remote configElement %+v", configElement)
+                                                        b.report.Fail(configType, id, name, err)
                                                         continue
                                                 }
+                                                b.journalChange(configType, JournalDeleted, id, remoteElement.GetName(), remoteElement)
                                         }
                                         logger.Warnf("push: deleted existing remote configElement with id %d, overriding it with version from file", id)
+                                        overridden = true
                                 } else {
-                                        logger.Warnf("push: found existing configElement with id %d, skipping it", id)
+                                        logger.WithField("outcome", OutcomeSkipped).Warnf("push: found existing configElement with id %d, skipping it", id)
+                                        b.report.Count(configType, OutcomeSkipped)
                                         continue
                                 }
                         }
                 }
 
-                remoteElements, err := client.GetByName(name)
+                if !overridden {
+                        if !matcher.Loaded() {
+                                if err := b.loadMatcher(ctx, client, matcher); err != nil {
+                                        return errors.WithMessage(err, "push")
+                                }
+                        }
+                        match, by, err := matcher.Match(configElement)
+                        if err != nil {
+                                logger.WithField("outcome", OutcomeSkipped).WithError(err).Errorf("push: cannot tell which remote configElement is %s, skipping it", name)
+                                b.report.Count(configType, OutcomeSkipped)
+                                continue
+                        }
+                        if match != nil && by == MatchByFingerprint && match.GetName() != name && !b.overrideRemote {
+                                // equal content alone does not make another element the same one,
+                                // e.g. a new monitor may reuse the query of an existing monitor
+                                matcher.Release(match.GetId())
+                                logger.Warnf("push: configElement %s has the content of remote configElement %d (%s) but no matching external id, creating a new one; use --override-remote to update and rename the remote configElement instead", name, match.GetId(), match.GetName())
+                                match = nil
+                        }
+                        if match != nil {
+                                if match.GetName() == name && !b.overrideRemote {
+                                        logger.WithField("outcome", OutcomeSkipped).Warnf("push: configElement %s matches remote configElement %d by %s, skipping it", name, match.GetId(), by)
+                                        b.report.Count(configType, OutcomeSkipped)
+                                        continue
+                                }
+                                change := describeChange("update", configType, match, DiffFields(configType, match, configElement))
+                                if !b.confirm(change) {
+                                        logger.WithField("outcome", OutcomeSkipped).Warnf("push: updating remote configElement %d was not confirmed, skipping it", match.GetId())
+                                        b.report.Count(configType, OutcomeSkipped)
+                                        continue
+                                }
+                                if err := b.updateMatch(ctx, client, configElement, match, by); err != nil {
+                                        logger.WithField("outcome", OutcomeFailed).WithError(err).Errorf("push: cannot update remote configElement %d with %s, skipping", match.GetId(), name)
+                                        b.report.Fail(configType, match.GetId(), name, err)
+                                        continue
+                                }
+                                logger.WithField("outcome", OutcomeUpdated).Infof("push: updated remote configElement %d with %s", match.GetId(), name)
+                                applied = append(applied, name)
+                                b.report.Count(configType, OutcomeUpdated)
+                                continue
+                        }
+                }
+
+                var remoteElements []ConfigElement
+                var err error
+                if finder, ok := client.(DuplicateFinder); ok {
+                        remoteElements, err = finder.FindDuplicates(ctx, configElement)
+                } else {
+                        remoteElements, err = client.GetByName(ctx, name)
+                }
                 if err != nil {
-                        logger.WithError(err).Warnf("push: cannot get monitors with name from %+v, trying to create a new one now", configElement)
+                        logger.WithError(err).Warnf("push: cannot get remote elements with name from %+v, trying to create a new one now", configElement)
                 }
                 if len(remoteElements) > 0 {
-                        logger.Warnf("push: configElement %+v has remote configElement with same name, skipping", configElement)
+                        logger.WithField("outcome", OutcomeSkipped).Warnf("push: configElement %+v has remote configElement with same name, skipping", configElement)
+                        b.report.Count(configType, OutcomeSkipped)
                         continue
                 }
-                createdElement := configElement.GetDelegate()
+                if b.owner != "" {
+                        configElement.SetOwner(b.owner)
+                }
+                if configElement.GetExternalId() == "" {
+                        externalId, err := newExternalId()
+                        if err != nil {
+                                return errors.WithMessage(err, "push")
+                        }
+                        configElement.SetExternalId(externalId)
+                }
+                b.remapReferences(logger, configElement)
+                createdElement := configElement
                 if !b.dryRun {
-                        createdElement, err = client.Create(configElement)
+                        createdElement, err = client.Create(ctx, configElement)
                         if err != nil {
-                                logger.WithError(err).Errorf("push: cannot create configElement %+v, skipping", configElement)
+                                logger.WithField("outcome", OutcomeFailed).WithError(err).Errorf("push: cannot create configElement %+v, skipping", configElement)
+                                b.report.Fail(configType, localId, name, err)
                                 continue
                         }
+                        b.journalChange(configType, JournalCreated, createdElement.GetId(), createdElement.GetName(), nil)
+                        if localId != -1 && createdElement.GetId() != localId {
+                                b.idMap.Add(configType, localId, createdElement.GetId())
+                        }
                 }
-                logger.Infof("push: created configElement %#v", createdElement)
+                outcome := OutcomeCreated
+                if overridden {
+                        outcome = OutcomeUpdated
+                }
+                logger.WithField("outcome", outcome).Infof("push: created configElement %d (%s)", createdElement.GetId(), createdElement.GetName())
+                applied = append(applied, name)
+                b.report.Count(configType, outcome)
+        }
+        return nil
+}
 
+// updateMatch updates the remote element matched by identity with the local element, e.g. to
+// rename it. The external id of the remote element is kept.
+func (b *backupService) updateMatch(ctx context.Context, client DatadogConfigClient, configElement, match ConfigElement, by MatchBy) error {
+        configType := client.ConfigClientName()
+        logger := b.log.WithFields(logrus.Fields{"client": configType, "action": "push"})
+        if match.GetName() != configElement.GetName() {
+                logger.Infof("push: configElement %s matches remote configElement %d (%s) by %s, renaming it", configElement.GetName(), match.GetId(), match.GetName(), by)
+        } else {
+                logger.Warnf("push: configElement %s matches remote configElement %d by %s, overriding it with version from file", configElement.GetName(), match.GetId(), by)
+        }
+        externalId := match.GetExternalId()
+        if externalId == "" {
+                externalId = configElement.GetExternalId()
+        }
+        if externalId == "" {
+                var err error
+                if externalId, err = newExternalId(); err != nil {
+                        return err
+                }
+        }
+        configElement.SetExternalId(externalId)
+        if b.owner != "" {
+                configElement.SetOwner(b.owner)
+        }
+        b.remapReferences(logger, configElement)
+        configElement.SetRemoteId(match.GetId())
+        if b.dryRun {
+                return nil
+        }
+        if err := client.Update(ctx, configElement); err != nil {
+                return err
+        }
+        b.journalChange(configType, JournalUpdated, match.GetId(), match.GetName(), match)
+        if localId := configElement.GetId(); localId != -1 && localId != match.GetId() {
+                b.idMap.Add(configType, localId, match.GetId())
         }
         return nil
 }
 
-func (b *backupService) pull(client DatadogConfigClient) error {
-        logger := b.log.WithField("client", client.ConfigClientName())
+// remapReferences rewrites the references of the element to elements re-created by push
+func (b *backupService) remapReferences(logger *logrus.Entry, configElement ConfigElement) {
+        for _, ref := range remapReferences(configElement, b.idMap) {
+                newId, _ := b.idMap.Lookup(ref.Type, ref.Id)
+                logger.Infof("push: configElement %s refers to re-created %s %d in %s, using new id %d", configElement.GetName(), ref.Type, ref.Id, ref.Field, newId)
+        }
+}
 
-        if b.backup && !b.dryRun {
-                err := b.backupFile(client.ConfigClientName())
+func (b *backupService) pull(ctx context.Context, client DatadogConfigClient) error {
+        configType := client.ConfigClientName()
+        logger := b.log.WithFields(logrus.Fields{"client": configType, "action": "pull"})
+
+        configElements, err := b.remoteElements(ctx, client)
+        var elementErrors ElementErrors
+        if err != nil && !errors.As(err, &elementErrors) {
+                return errors.WithMessage(err, "pull")
+        }
+        elements := configElements.Elements
+        // elements which could not be loaded keep their last local version instead of being
+        // dropped from the config file, the failures only go into the report
+        if len(elementErrors) > 0 {
+                logger.Warnf("pull: %d config element(s) could not be loaded, keeping their last local version", len(elementErrors))
+                failed := map[int]bool{}
+                for _, elementError := range elementErrors {
+                        logger.WithFields(logrus.Fields{"element_id": elementError.Id, "element_name": elementError.Name, "outcome": OutcomeFailed}).
+                                WithError(elementError.Err).Warn("pull: cannot load element")
+                        b.report.Fail(configType, elementError.Id, elementError.Name, elementError.Err)
+                        failed[elementError.Id] = true
+                }
+                local, err := b.readConfigFileIfExists(client)
                 if err != nil {
                         return errors.WithMessage(err, "pull")
                 }
+                for _, e := range local {
+                        if failed[e.GetId()] {
+                                elements = append(elements, e)
+                        }
+                }
         }
-
-        configFilePath := b.configFilePath(client.ConfigClientName())
-        configFile, err := b.openConfigFile(configFilePath, false, b.dryRun)
-        if err != nil {
+        if err := b.writeConfigFile(client, elements); err != nil {
                 return errors.WithMessage(err, "pull")
         }
-        defer closeQuietly(configFile)
+        for range configElements.Elements {
+                b.report.Count(configType, OutcomePulled)
+        }
+        if len(elementErrors) == 0 {
+                b.metrics.PullSucceeded(configType, len(elements))
+        }
+        return nil
+}
 
-        configElements, err := client.GetAll()
-        if err != nil {
-                return errors.WithMessage(err, "pull")
+// writeConfigFile backs up the config file of the client and replaces it with the elements
+func (b *backupService) writeConfigFile(client DatadogConfigClient, configElements []ConfigElement) error {
+        configType := client.ConfigClientName()
+        logger := b.log.WithFields(logrus.Fields{"client": configType, "action": "pull"})
+
+        if b.backup && !b.dryRun {
+                err := b.backupFile(configType)
+                if err != nil {
+                        return err
+                }
         }
-        logger.Infof("writing %d config element(s) into configFile %s", len(configElements.Elements), configFile.Name())
+
+        configFileName := b.configFileName(configType)
+        logger.Infof("writing %d config element(s) into configFile %s", len(configElements), b.configStorage.Location(configFileName))
 
         if !b.dryRun {
-                encoder := yaml.NewEncoder(configFile)
-                defer closeQuietly(encoder)
+                configFile, err := b.configStorage.Write(configFileName)
+                if err != nil {
+                        return err
+                }
 
-                err = encoder.Encode(configElements.Elements)
-                return errors.WithMessage(err, "pull")
+                encoder := yaml.NewEncoder(configFile)
+                if err = encoder.Encode(configElements); err == nil {
+                        err = encoder.Close()
+                }
+                if err != nil {
+                        closeQuietly(configFile)
+                        return err
+                }
+                // closing flushes the file to remote storages, so the error matters here
+                if err = configFile.Close(); err != nil {
+                        return err
+                }
         }
         return nil
 }
 
-func (b *backupService) delete(client DatadogConfigClient) error {
-        logger := b.log.WithField("client", client.ConfigClientName())
+func (b *backupService) delete(ctx context.Context, client DatadogConfigClient) error {
+        configType := client.ConfigClientName()
+        logger := b.log.WithFields(logrus.Fields{"client": configType, "action": "delete"})
 
-        file, err := b.openConfigFile(b.configFilePath(client.ConfigClientName()), true, true)
-        if err != nil {
+        // the remote elements are selected, so the backup has their full definitions
+        remoteElements, err := b.remoteElements(ctx, client)
+        var elementErrors ElementErrors
+        if errors.As(err, &elementErrors) {
+                logger.Warnf("delete: %d element(s) could not be loaded, they are neither backed up nor deleted", len(elementErrors))
+                for _, elementError := range elementErrors {
+                        logger.WithFields(logrus.Fields{"element_id": elementError.Id, "element_name": elementError.Name, "outcome": OutcomeFailed}).
+                                WithError(elementError.Err).Warn("delete: cannot load element")
+                        b.report.Fail(configType, elementError.Id, elementError.Name, elementError.Err)
+                }
+        } else if err != nil {
                 return errors.WithMessage(err, "delete")
         }
-        defer closeQuietly(file)
-        configElements, err := client.DecodeFile(file)
-        if err != nil {
-                return errors.WithMessagef(err, "delete: cannot decode file %s", file.Name())
+        configElements := remoteElements.Elements
+        if b.deleteList != nil {
+                var problems []string
+                configElements, problems = b.deleteList.Select(configType, configElements)
+                for _, problem := range problems {
+                        logger.Warnf("delete: skipping entry of the delete list, %s", problem)
+                }
+        }
+        if len(configElements) == 0 {
+                logger.Info("delete: no elements selected")
+                return nil
+        }
+        if err := b.backupDeleted(logger, configType, configElements); err != nil {
+                return errors.WithMessage(err, "delete")
         }
 
-        for _, configElement := range configElements {
+        var applied []string
+        for e, configElement := range configElements {
+                if err := b.interrupted(ctx); err != nil {
+                        logInterrupted(logger, "delete", applied, configElements[e:])
+                        return errors.WithMessage(err, "delete")
+                }
 
+                logger := withElement(logger, configElement)
                 id := configElement.GetId()
-                if id != -1 {
-                        if !b.dryRun {
-                                err = client.Delete(id)
-                                if err != nil {
-                                        logger.WithError(err).Errorf("delete: cannot delete element %d", id)
-                                        continue
-                                }
+                if !b.confirm(describeChange("delete", configType, configElement, nil)) {
+                        logger.WithField("outcome", OutcomeSkipped).Warnf("delete: deleting element %d was not confirmed, skipping it", id)
+                        b.report.Count(configType, OutcomeSkipped)
+                        continue
+                }
+                if !b.dryRun {
+                        if err := client.Delete(ctx, id); err != nil {
+                                logger.WithField("outcome", OutcomeFailed).WithError(err).Errorf("delete: cannot delete element %d", id)
+                                b.report.Fail(configType, id, configElement.GetName(), err)
+                                continue
                         }
-                } else {
-                        logger.WithError(err).Errorf("delete: cannot delete element, id is missing: %+v", configElement.GetDelegate())
                 }
-                logger.Infof("deleted element %#v", configElement)
+                logger.WithField("outcome", OutcomeDeleted).Infof("delete: deleted element %d (%s)", id, configElement.GetName())
+                b.report.Count(configType, OutcomeDeleted)
+                applied = append(applied, configElement.GetName())
         }
         return nil
 }
 
-func (b *backupService) backupFile(configClientName string) error {
-        oldFile := fmt.Sprintf("%s/%s.yaml", b.configDir, configClientName)
-        backupFile := fmt.Sprintf("%s/%d_%s.yaml", b.backupDir, time.Now().Unix(), configClientName)
-        _, err := os.Stat(oldFile)
-        if err == nil {
-                old, err := ioutil.ReadFile(oldFile)
-                if err != nil {
-                        return errors.WithMessagef(err, "backupFile: cannot read config file %s", oldFile)
-                }
-                err = ioutil.WriteFile(backupFile, old, 0644)
-                return errors.WithMessage(err, "backup")
-        } else if !os.IsNotExist(err) {
+// backupDeleted writes the elements about to be deleted to the backup dir in the format of the
+// config files. The file is not matched by backupFileRegex, so it is no snapshot.
+func (b *backupService) backupDeleted(logger *logrus.Entry, configType string, configElements []ConfigElement) error {
+        backupFileName := fmt.Sprintf("%d_deleted-%s.yaml", time.Now().Unix(), configType)
+        if b.dryRun {
+                logger.Infof("delete: would back up %d element(s) to %s", len(configElements), backupFileName)
+                return nil
+        }
+        writer, err := b.backupStorage.Write(backupFileName)
+        if err != nil {
                 return errors.WithMessage(err, "backup")
         }
+        encoder := yaml.NewEncoder(writer)
+        if err = encoder.Encode(configElements); err == nil {
+                err = encoder.Close()
+        }
+        if err != nil {
+                closeQuietly(writer)
+                return errors.WithMessagef(err, "backup: cannot write backup file %s", b.backupStorage.Location(backupFileName))
+        }
+        if err = writer.Close(); err != nil {
+                return errors.WithMessagef(err, "backup: cannot write backup file %s", b.backupStorage.Location(backupFileName))
+        }
+        logger.Infof("delete: backed up %d element(s) to %s, push it as %s to re-create them",
+                len(configElements), b.backupStorage.Location(backupFileName), b.configFileName(configType))
         return nil
 }
 
-func (b *backupService) openConfigFile(name string, readOnly, append bool) (*os.File, error) {
-
-        fileMode := os.O_RDWR
-        if !readOnly {
-                if append {
-                        fileMode |= os.O_CREATE | os.O_APPEND
-                } else {
-                        fileMode |= os.O_CREATE | os.O_TRUNC
-                }
+func (b *backupService) backupFile(configClientName string) error {
+        configFileName := b.configFileName(configClientName)
+        backupFileName := fmt.Sprintf("%d_%s.yaml", time.Now().Unix(), configClientName)
+        exists, err := b.configStorage.Exists(configFileName)
+        if err != nil {
+                return errors.WithMessage(err, "backup")
+        }
+        if !exists {
+                return nil
         }
 
-        monitorsFile, err := os.OpenFile(name, fileMode, 0666)
+        reader, err := b.configStorage.Read(configFileName)
+        if err != nil {
+                return errors.WithMessage(err, "backup")
+        }
+        defer closeQuietly(reader)
+        old, err := ioutil.ReadAll(reader)
+        if err != nil {
+                return errors.WithMessagef(err, "backupFile: cannot read config file %s", b.configStorage.Location(configFileName))
+        }
 
-        return monitorsFile, errors.WithMessagef(err, "cannot open file %s", name)
+        writer, err := b.backupStorage.Write(backupFileName)
+        if err != nil {
+                return errors.WithMessage(err, "backup")
+        }
+        if _, err = writer.Write(old); err != nil {
+                closeQuietly(writer)
+                return errors.WithMessagef(err, "backup: cannot write backup file %s", b.backupStorage.Location(backupFileName))
+        }
+        return errors.WithMessage(writer.Close(), "backup")
 }
 
-func (b *backupService) readConfigFile(name string) ([]ConfigElement, error) {
-        file, err := b.openConfigFile(name, true, true)
+func (b *backupService) readConfigFile(client DatadogConfigClient) ([]ConfigElement, error) {
+        name := b.configFileName(client.ConfigClientName())
+        file, err := b.configStorage.Read(name)
         if err != nil {
                 return nil, errors.WithMessage(err, "readConfigFile")
         }
         defer closeQuietly(file)
 
-        var configElements []ConfigElement
-
-        decoder := yaml.NewDecoder(file)
-        if err := decoder.Decode(&configElements); err != nil {
-                return nil, errors.WithMessage(err, "readConfigFile: cannot read monitors file")
+        configElements, err := client.DecodeFile(file)
+        if err != nil {
+                return nil, errors.WithMessagef(err, "readConfigFile: cannot decode file %s", b.configStorage.Location(name))
         }
         return configElements, nil
 }
 
-func (b *backupService) configFilePath(name string) string {
-        return b.configDir + "/" + name + ".yaml"
+// readConfigFileIfExists returns no elements if there is no config file for the client
+func (b *backupService) readConfigFileIfExists(client DatadogConfigClient) ([]ConfigElement, error) {
+        exists, err := b.configStorage.Exists(b.configFileName(client.ConfigClientName()))
+        if err != nil || !exists {
+                return nil, err
+        }
+        return b.readConfigFile(client)
+}
+
+func (b *backupService) configFileName(name string) string {
+        return name + ".yaml"
+}
+
+// warnDuplicateNames warns about elements of a config file sharing a name, only the first of
+// them is created by push if the others are not matched by id or identity
+func warnDuplicateNames(logger *logrus.Entry, configElements []ConfigElement) {
+        count := map[string]int{}
+        var names []string
+        for _, configElement := range configElements {
+                name := configElement.GetName()
+                if count[name] == 1 {
+                        names = append(names, name)
+                }
+                count[name]++
+        }
+        for _, name := range names {
+                logger.Warnf("push: %d elements in the config file are named %q", count[name], name)
+        }
+}
+
+// withElement adds the id and name of the element to the log fields
+func withElement(logger *logrus.Entry, e ConfigElement) *logrus.Entry {
+        return logger.WithFields(logrus.Fields{"element_id": e.GetId(), "element_name": e.GetName()})
+}
+
+// logInterrupted logs which elements were applied before an action was interrupted and which were not
+func logInterrupted(logger *logrus.Entry, action string, applied []string, notApplied []ConfigElement) {
+        logger.Warnf("%s: interrupted, %d element(s) were applied, %d element(s) were not applied", action, len(applied), len(notApplied))
+        for _, name := range applied {
+                logger.Warnf("%s: applied: %s", action, name)
+        }
+        for _, configElement := range notApplied {
+                logger.Warnf("%s: not applied: %s", action, configElement.GetName())
+        }
 }
 
 func closeQuietly(closer io.Closer) {
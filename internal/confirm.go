@@ -0,0 +1,88 @@
+package internal
+
+import (
+        "bufio"
+        "fmt"
+        "io"
+        "strings"
+        "sync"
+)
+
+// Confirmation asks the user before each destructive change, like deleting or overriding a
+// remote element
+type Confirmation struct {
+        mutex sync.Mutex
+        in    *bufio.Reader
+        out   io.Writer
+        all   bool
+        quit  bool
+}
+
+func NewConfirmation(in io.Reader, out io.Writer) *Confirmation {
+        return &Confirmation{in: bufio.NewReader(in), out: out}
+}
+
+// Confirm shows the change and asks y(es), n(o), a(ll) or q(uit). It returns if the change may
+// be applied and if the user wants to stop, after all every change is confirmed without asking.
+func (c *Confirmation) Confirm(change string) (ok bool, quit bool) {
+        c.mutex.Lock()
+        defer c.mutex.Unlock()
+        if c.all {
+                return true, false
+        }
+        if c.quit {
+                return false, true
+        }
+        for {
+                _, _ = fmt.Fprintf(c.out, "%s\napply? [y]es, [n]o, [a]ll, [q]uit: ", change)
+                answer, err := c.in.ReadString('\n')
+                switch strings.ToLower(strings.TrimSpace(answer)) {
+                case "y", "yes":
+                        return true, false
+                case "n", "no":
+                        return false, false
+                case "a", "all":
+                        c.all = true
+                        return true, false
+                case "q", "quit":
+                        c.quit = true
+                        return false, true
+                }
+                // the input ended without an answer
+                if err != nil {
+                        _, _ = fmt.Fprintln(c.out)
+                        c.quit = true
+                        return false, true
+                }
+        }
+}
+
+// confirm asks before a destructive change, nothing is asked in dry runs. Without a confirmation
+// the change is only applied if yes is assumed, otherwise it is counted as unconfirmed, which
+// makes the run a partial failure. Quitting stops the service, like the first SIGINT does.
+func (b *backupService) confirm(change string) bool {
+        if b.dryRun {
+                return true
+        }
+        if b.confirmation == nil {
+                if !b.assumeYes {
+                        b.report.Unconfirm()
+                }
+                return b.assumeYes
+        }
+        ok, quit := b.confirmation.Confirm(change)
+        if quit {
+                b.Stop()
+        }
+        return ok
+}
+
+// describeChange describes a change of an element for a confirmation, with the changed fields
+func describeChange(change string, configType string, e ConfigElement, changes []FieldChange) string {
+        var description strings.Builder
+        fmt.Fprintf(&description, "%s %s %d (%s)", change, configType, e.GetId(), e.GetName())
+        for _, fieldChange := range changes {
+                fmt.Fprintf(&description, "\n    %s: %q -> %q", fieldChange.Path, fieldChange.Local, fieldChange.Remote)
+        }
+        return description.String()
+}
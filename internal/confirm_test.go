@@ -0,0 +1,65 @@
+package internal
+
+import (
+        "io/ioutil"
+        "reflect"
+        "strings"
+        "testing"
+)
+
+func TestConfirmation(t *testing.T) {
+        tests := []struct {
+                name  string
+                input string
+                want  []bool
+                quit  bool
+        }{
+                {"yes and no", "y\nno\nYES\n", []bool{true, false, true}, false},
+                {"asks again on other answers", "maybe\nn\ny\n", []bool{false, true}, false},
+                {"all confirms the rest", "a\n", []bool{true, true, true}, false},
+                {"quit declines the rest", "y\nq\n", []bool{true, false, false}, true},
+                {"end of input quits", "y\n", []bool{true, false}, true},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        confirmation := NewConfirmation(strings.NewReader(test.input), ioutil.Discard)
+                        var got []bool
+                        var quit bool
+                        for range test.want {
+                                var ok bool
+                                ok, quit = confirmation.Confirm("delete monitors 1 (m)")
+                                got = append(got, ok)
+                        }
+                        if !reflect.DeepEqual(got, test.want) || quit != test.quit {
+                                t.Errorf("confirmed %v, quit %t, want %v, %t", got, quit, test.want, test.quit)
+                        }
+                })
+        }
+}
+
+func TestBackupServiceConfirm(t *testing.T) {
+        tests := []struct {
+                name         string
+                dryRun       bool
+                assumeYes    bool
+                confirmation *Confirmation
+                want         bool
+                status       string
+        }{
+                {"no terminal", false, false, nil, false, StatusPartialFailure},
+                {"yes", false, true, nil, true, StatusSuccess},
+                {"dry run", true, false, nil, true, StatusSuccess},
+                {"confirmed", false, false, NewConfirmation(strings.NewReader("y\n"), ioutil.Discard), true, StatusSuccess},
+                {"declined", false, true, NewConfirmation(strings.NewReader("n\n"), ioutil.Discard), false, StatusSuccess},
+        }
+        for _, test := range tests {
+                service := &backupService{dryRun: test.dryRun, assumeYes: test.assumeYes, confirmation: test.confirmation, stop: make(chan struct{}), report: NewReport()}
+                if got := service.confirm("delete monitors 1 (m)"); got != test.want {
+                        t.Errorf("%s: confirm = %t, want %t", test.name, got, test.want)
+                }
+                // a change declined on the terminal is a decision, one nobody could confirm is not
+                if status := service.report.Finish(nil); status != test.status {
+                        t.Errorf("%s: status = %s, want %s", test.name, status, test.status)
+                }
+        }
+}
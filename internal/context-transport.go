@@ -0,0 +1,70 @@
+package internal
+
+import (
+        "context"
+        "io"
+        "net/http"
+        "time"
+)
+
+// ContextTransport binds the requests of the datadog client, which does not support contexts,
+// to a context. A request is aborted when either its own context or the bound context is done,
+// or when it takes longer than the optional timeout. The bound context is only watched until the
+// response is handed back, the client does not close every response body, reading the body is
+// limited by the timeout only.
+type ContextTransport struct {
+        ctx     context.Context
+        timeout time.Duration
+        next    http.RoundTripper
+}
+
+func NewContextTransport(ctx context.Context, timeout time.Duration, next http.RoundTripper) *ContextTransport {
+        if next == nil {
+                next = http.DefaultTransport
+        }
+        return &ContextTransport{
+                ctx:     ctx,
+                timeout: timeout,
+                next:    next,
+        }
+}
+
+func (t *ContextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+        var ctx context.Context
+        var cancel context.CancelFunc
+        if t.timeout > 0 {
+                ctx, cancel = context.WithTimeout(req.Context(), t.timeout)
+        } else {
+                ctx, cancel = context.WithCancel(req.Context())
+        }
+        responded := make(chan struct{})
+        go func() {
+                select {
+                case <-t.ctx.Done():
+                        cancel()
+                case <-ctx.Done():
+                case <-responded:
+                }
+        }()
+
+        resp, err := t.next.RoundTrip(req.WithContext(ctx))
+        close(responded)
+        if err != nil {
+                cancel()
+                return nil, err
+        }
+        resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+        return resp, nil
+}
+
+// cancelOnClose releases the context of a request once its response body is consumed, which stops
+// the timer of the timeout early
+type cancelOnClose struct {
+        io.ReadCloser
+        cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+        defer c.cancel()
+        return c.ReadCloser.Close()
+}
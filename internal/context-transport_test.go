@@ -0,0 +1,57 @@
+package internal
+
+import (
+        "context"
+        "net/http"
+        "net/http/httptest"
+        "runtime"
+        "testing"
+        "time"
+)
+
+func TestContextTransportAbortsOnTheBoundContext(t *testing.T) {
+        release := make(chan struct{})
+        server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                <-release
+        }))
+        defer server.Close()
+        defer close(release)
+        ctx, cancel := context.WithCancel(context.Background())
+        client := &http.Client{Transport: NewContextTransport(ctx, 0, nil)}
+        time.AfterFunc(50*time.Millisecond, cancel)
+        done := make(chan error, 1)
+        go func() {
+                _, err := client.Get(server.URL)
+                done <- err
+        }()
+        select {
+        case err := <-done:
+                if err == nil {
+                        t.Error("expected an error of the aborted request")
+                }
+        case <-time.After(5 * time.Second):
+                t.Fatal("request was not aborted with the bound context")
+        }
+}
+
+// the datadog client leaves some response bodies open, they must not keep a goroutine each
+func TestContextTransportReleasesUnclosedResponses(t *testing.T) {
+        server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                w.WriteHeader(http.StatusBadGateway)
+        }))
+        defer server.Close()
+        client := &http.Client{Transport: NewContextTransport(context.Background(), 0, nil)}
+        before := runtime.NumGoroutine()
+        for i := 0; i < 50; i++ {
+                if _, err := client.Get(server.URL); err != nil {
+                        t.Fatal(err)
+                }
+        }
+        deadline := time.Now().Add(5 * time.Second)
+        for runtime.NumGoroutine() > before+10 {
+                if time.Now().After(deadline) {
+                        t.Fatalf("%d goroutines before and %d after 50 unclosed responses", before, runtime.NumGoroutine())
+                }
+                time.Sleep(10 * time.Millisecond)
+        }
+}
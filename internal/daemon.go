@@ -0,0 +1,218 @@
+package internal
+
+import (
+        "context"
+        "fmt"
+        "github.com/pkg/errors"
+        "github.com/sirupsen/logrus"
+        "github.com/zorkian/go-datadog-api"
+        "strings"
+        "sync"
+        "time"
+)
+
+// Daemon pulls the remote elements on a schedule and detects drift: elements which changed,
+// appeared or were deleted remotely since the last snapshot. The snapshot is kept in memory,
+// the config files are only written if something changed.
+type Daemon struct {
+        service    *backupService
+        schedule   Schedule
+        postEvents bool
+        log        *logrus.Entry
+
+        // checkMutex serializes checks, snapshots and lastCheck are guarded by it as well
+        checkMutex sync.Mutex
+        snapshots  map[string][]ConfigElement
+        lastCheck  time.Time
+}
+
+// NewDaemon returns a daemon checking the elements of the service on the schedule, drift is
+// posted as a datadog event if postEvents is set
+func NewDaemon(service *backupService, schedule Schedule, postEvents bool) *Daemon {
+        return &Daemon{
+                service:    service,
+                schedule:   schedule,
+                postEvents: postEvents,
+                log:        logrus.WithField("prefix", "daemon"),
+                snapshots:  map[string][]ConfigElement{},
+        }
+}
+
+// Run checks for drift right away and then on the schedule, until the context is done or the
+// service is stopped. Failed checks are logged and retried on the next run.
+func (d *Daemon) Run(ctx context.Context) error {
+        for {
+                if _, err := d.Check(ctx); err != nil {
+                        if interrupted := d.service.interrupted(ctx); interrupted != nil {
+                                return interrupted
+                        }
+                        d.log.WithError(err).Error("serve: check failed")
+                }
+                next := d.schedule.Next(time.Now())
+                d.log.Infof("serve: next check at %s", next.Format(time.RFC3339))
+                timer := time.NewTimer(time.Until(next))
+                select {
+                case <-ctx.Done():
+                        timer.Stop()
+                        return ctx.Err()
+                case <-d.service.stop:
+                        timer.Stop()
+                        return ErrStopped
+                case <-timer.C:
+                }
+        }
+}
+
+// Check compares the remote elements with the last snapshot and returns the drift. The first
+// check compares with the config files. The report holds the drift of the latest check.
+func (d *Daemon) Check(ctx context.Context) ([]ElementDiff, error) {
+        d.checkMutex.Lock()
+        defer d.checkMutex.Unlock()
+        d.service.report.ResetDrift()
+        var drift []ElementDiff
+        defer func() { d.service.report.AddDrift(drift) }()
+        for _, c := range d.service.clients() {
+                if err := d.service.interrupted(ctx); err != nil {
+                        return drift, errors.WithMessage(err, "check")
+                }
+                clientDrift, err := d.check(ctx, c)
+                if err != nil {
+                        return drift, errors.WithMessagef(err, "check client %s", c.ConfigClientName())
+                }
+                drift = append(drift, clientDrift...)
+        }
+        d.lastCheck = time.Now()
+        if len(drift) == 0 {
+                d.log.Info("serve: no drift detected")
+        } else {
+                if d.postEvents {
+                        d.postEvent(drift)
+                }
+                d.service.notifyDrift(drift)
+        }
+        return drift, nil
+}
+
+// Pull pulls all elements into the config files, backing up the old ones, and takes them as
+// the snapshot of the next check
+func (d *Daemon) Pull(ctx context.Context) error {
+        d.checkMutex.Lock()
+        defer d.checkMutex.Unlock()
+        err := d.service.Pull(ctx)
+        d.snapshots = map[string][]ConfigElement{}
+        return err
+}
+
+// Diff compares the config files with the remote elements, it waits for a running check or pull
+// which writes the config files
+func (d *Daemon) Diff(ctx context.Context) ([]ElementDiff, error) {
+        d.checkMutex.Lock()
+        defer d.checkMutex.Unlock()
+        return d.service.Diff(ctx)
+}
+
+// LastCheck returns the time of the last successful check
+func (d *Daemon) LastCheck() time.Time {
+        d.checkMutex.Lock()
+        defer d.checkMutex.Unlock()
+        return d.lastCheck
+}
+
+func (d *Daemon) check(ctx context.Context, client DatadogConfigClient) ([]ElementDiff, error) {
+        configType := client.ConfigClientName()
+        logger := d.log.WithFields(logrus.Fields{"client": configType, "action": "serve"})
+
+        remote, err := d.service.remoteElements(ctx, client)
+        var elementErrors ElementErrors
+        if err != nil && !errors.As(err, &elementErrors) {
+                return nil, err
+        }
+
+        previous, ok := d.snapshots[configType]
+        if !ok {
+                exists, err := d.service.configStorage.Exists(d.service.configFileName(configType))
+                if err != nil {
+                        return nil, err
+                }
+                // without a config file the first snapshot is the baseline, there is no drift yet
+                if !exists {
+                        logger.Infof("serve: no config file yet, taking %d remote element(s) as baseline", len(remote.Elements))
+                        previous = remote.Elements
+                } else if previous, err = d.service.readConfigFile(client); err != nil {
+                        return nil, err
+                } else {
+                        previous = d.service.filter.Apply(previous)
+                }
+        }
+        current := remote.Elements
+        // elements which could not be loaded keep their last known version instead of showing up
+        // as deleted
+        if len(elementErrors) > 0 {
+                logger.Warnf("serve: %d element(s) could not be loaded, keeping their last known version", len(elementErrors))
+                failed := map[int]bool{}
+                for _, elementError := range elementErrors {
+                        failed[elementError.Id] = true
+                }
+                for _, e := range previous {
+                        if failed[e.GetId()] {
+                                current = append(current, e)
+                        }
+                }
+        }
+
+        since := d.lastCheck
+        if since.IsZero() {
+                since = d.service.lastSnapshotTime()
+        }
+        diffs := DiffElements(configType, previous, current)
+        d.service.annotateDrift(ctx, configType, diffs, since)
+        var drift []ElementDiff
+        for _, diff := range diffs {
+                switch diff.Kind {
+                case DiffChanged:
+                        logger.Warnf("serve: drift: %s", diff)
+                        for _, change := range diff.Changes {
+                                logger.Warnf("serve: drift:   %s: %q -> %q", change.Path, change.Local, change.Remote)
+                        }
+                case DiffDeleted:
+                        logger.Errorf("serve: unexpected remote deletion of %s %d (%s)", configType, diff.Id, diff.Name)
+                case DiffCreated:
+                        logger.Infof("serve: new remote element %s %d (%s)", configType, diff.Id, diff.Name)
+                default:
+                        continue
+                }
+                drift = append(drift, diff)
+        }
+
+        if !ok || len(drift) > 0 {
+                if err := d.service.writeConfigFile(client, current); err != nil {
+                        return drift, err
+                }
+                for range remote.Elements {
+                        d.service.report.Count(configType, OutcomePulled)
+                }
+        }
+        d.snapshots[configType] = current
+        if len(elementErrors) == 0 {
+                d.service.metrics.PullSucceeded(configType, len(current))
+        }
+        d.service.metrics.DriftDetected(drift)
+        return drift, nil
+}
+
+// postEvent posts the drift as a datadog event, failures are only logged
+func (d *Daemon) postEvent(drift []ElementDiff) {
+        var lines []string
+        for _, diff := range drift {
+                lines = append(lines, "- "+diff.String())
+        }
+        _, err := d.service.ddClient.PostEvent(&datadog.Event{
+                Title:     datadog.String(fmt.Sprintf("datadog-backup detected drift in %d element(s)", len(drift))),
+                Text:      datadog.String(strings.Join(lines, "\n")),
+                AlertType: datadog.String("warning"),
+                Tags:      []string{"source:datadog-backup"},
+        })
+        if err != nil {
+                d.log.WithError(err).Error("serve: cannot post drift event")
+        }
+}
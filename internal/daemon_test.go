@@ -0,0 +1,45 @@
+package internal
+
+import (
+        "context"
+        "testing"
+        "time"
+)
+
+func TestDaemonCheckResetsTheDrift(t *testing.T) {
+        service := &backupService{report: NewReport()}
+        service.report.AddDrift([]ElementDiff{{Type: "monitors", Id: 1, Kind: DiffChanged}})
+        daemon := NewDaemon(service, intervalSchedule(1), false)
+        for i := 0; i < 2; i++ {
+                if _, err := daemon.Check(context.Background()); err != nil {
+                        t.Fatal(err)
+                }
+                if len(service.report.Drift) != 0 {
+                        t.Errorf("check %d: drift = %v, want only the drift of the latest check", i, service.report.Drift)
+                }
+        }
+}
+
+func TestDaemonDiffWaitsForTheCheck(t *testing.T) {
+        daemon := NewDaemon(&backupService{report: NewReport()}, intervalSchedule(1), false)
+        daemon.checkMutex.Lock()
+        done := make(chan error, 1)
+        go func() {
+                _, err := daemon.Diff(context.Background())
+                done <- err
+        }()
+        select {
+        case <-done:
+                t.Fatal("diff ran during a check")
+        case <-time.After(50 * time.Millisecond):
+        }
+        daemon.checkMutex.Unlock()
+        select {
+        case err := <-done:
+                if err != nil {
+                        t.Error(err)
+                }
+        case <-time.After(5 * time.Second):
+                t.Fatal("diff did not run after the check")
+        }
+}
@@ -1,29 +1,42 @@
 package internal
 
 import (
+        "context"
+        "encoding/json"
         "github.com/pkg/errors"
         "github.com/sirupsen/logrus"
         "github.com/zorkian/go-datadog-api"
-        "gopkg.in/yaml.v3"
-        "os"
+        "io"
+        "sync"
 )
 
 type dashboardsClient struct {
-        ddClient *datadog.Client
-        log      *logrus.Entry
+        ddClient    *datadog.Client
+        log         *logrus.Entry
+        concurrency int
+
+        // titles indexes the ids of the remote dashboards by title, it is loaded once per run and
+        // kept up to date by create, update and delete
+        titlesMutex sync.Mutex
+        titles      map[string][]int
 }
 
-func NewDashboardsClient(ddClient *datadog.Client) DatadogConfigClient {
+// NewDashboardsClient returns a client for dashboards, concurrency limits how many dashboards
+// are fetched from datadog in parallel
+func NewDashboardsClient(ddClient *datadog.Client, concurrency int) DatadogConfigClient {
+        if concurrency < 1 {
+                concurrency = 1
+        }
         return &dashboardsClient{
-                ddClient: ddClient,
-                log:      logrus.WithField("prefix", "dashboards"),
+                ddClient:    ddClient,
+                log:         logrus.WithField("prefix", "dashboards"),
+                concurrency: concurrency,
         }
 }
 
-func (d *dashboardsClient) DecodeFile(file *os.File) ([]ConfigElement, error) {
+func (d *dashboardsClient) DecodeFile(reader io.Reader) ([]ConfigElement, error) {
         var configElements []dashboardConfigElement
-        decoder := yaml.NewDecoder(file)
-        if err := decoder.Decode(&configElements); err != nil {
+        if err := decodeStrict(reader, &configElements); err != nil {
                 return nil, errors.WithMessage(err, "push: cannot read dashboard file")
         }
         result := make([]ConfigElement, len(configElements))
@@ -33,47 +46,169 @@ func (d *dashboardsClient) DecodeFile(file *os.File) ([]ConfigElement, error) {
         return result, nil
 }
 
-func (d *dashboardsClient) GetAll() (*ConfigElements, error) {
+// GetAll loads the full definition of all dashboards with a pool of workers. The order of the
+// result is the order of the dashboard list. Dashboards which cannot be loaded are left out of
+// the result and reported together as ElementErrors.
+func (d *dashboardsClient) GetAll(ctx context.Context) (*ConfigElements, error) {
+        if err := ctx.Err(); err != nil {
+                return nil, err
+        }
         dashboards, err := d.ddClient.GetDashboards()
         if err != nil {
                 return nil, errors.WithMessage(err, "get all dashboards")
         }
-        result := make([]ConfigElement, len(dashboards))
-        fullDashboards := make([]datadog.Dashboard, len(dashboards))
+        fullDashboards := make([]*datadog.Dashboard, len(dashboards))
+        loadErrors := make([]error, len(dashboards))
 
+        jobs := make(chan int)
+        var wg sync.WaitGroup
+        for w := 0; w < d.concurrency && w < len(dashboards); w++ {
+                wg.Add(1)
+                go func() {
+                        defer wg.Done()
+                        for e := range jobs {
+                                if loadErrors[e] = ctx.Err(); loadErrors[e] == nil {
+                                        fullDashboards[e], loadErrors[e] = d.ddClient.GetDashboard(*dashboards[e].Id)
+                                }
+                        }
+                }()
+        }
+        for e := range dashboards {
+                jobs <- e
+        }
+        close(jobs)
+        wg.Wait()
+        if err := ctx.Err(); err != nil {
+                return nil, errors.WithMessage(err, "get all dashboards")
+        }
+
+        result := make([]ConfigElement, 0, len(dashboards))
+        delegates := make([]datadog.Dashboard, 0, len(dashboards))
+        var elementErrors ElementErrors
         for e, dashboard := range dashboards {
-                fullDashboard, err := d.ddClient.GetDashboard(*dashboard.Id)
-                if err != nil {
-                        return nil, errors.WithMessagef(err, "cannot fully load dashboard %+v", dashboard)
+                if loadErrors[e] != nil {
+                        d.log.WithError(loadErrors[e]).Errorf("cannot fully load dashboard %d", dashboard.GetId())
+                        elementErrors = append(elementErrors, ElementError{Id: dashboard.GetId(), Name: dashboard.GetTitle(), Err: loadErrors[e]})
+                        continue
                 }
-                result[e] = d.newConfigElement(dashboard.Title, dashboard.Id, fullDashboard)
-                fullDashboards[e] = *fullDashboard
+                result = append(result, d.newConfigElement(dashboard.Title, dashboard.Id, fullDashboards[e]))
+                delegates = append(delegates, *fullDashboards[e])
         }
-        return &ConfigElements{
+        configElements := &ConfigElements{
                 Elements: result,
-                Delegate: d.toInterfaceSlice(fullDashboards),
-        }, errors.WithMessage(err, "get all dashboards")
+                Delegate: d.toInterfaceSlice(delegates),
+        }
+        if len(elementErrors) > 0 {
+                return configElements, errors.WithMessage(elementErrors, "get all dashboards")
+        }
+        return configElements, nil
 }
 
 func (d *dashboardsClient) ConfigClientName() string {
         return "dashboards"
 }
 
-func (d *dashboardsClient) GetById(id int) (interface{}, error) {
-        return d.ddClient.GetDashboard(id)
+func (d *dashboardsClient) GetById(ctx context.Context, id int) (ConfigElement, error) {
+        if err := ctx.Err(); err != nil {
+                return nil, err
+        }
+        dashboard, err := d.ddClient.GetDashboard(id)
+        if err != nil {
+                return nil, err
+        }
+        return d.newConfigElement(dashboard.Title, dashboard.Id, dashboard), nil
+}
+
+// GetByName returns the dashboards with the given title, datadog has no lookup by title, so the
+// titles of all dashboards are listed once and indexed
+func (d *dashboardsClient) GetByName(ctx context.Context, name string) ([]ConfigElement, error) {
+        ids, err := d.idsByTitle(ctx, name)
+        if err != nil {
+                return nil, err
+        }
+        result := []ConfigElement{}
+        for _, id := range ids {
+                dashboard, err := d.GetById(ctx, id)
+                if err != nil {
+                        return result, errors.WithMessagef(err, "get dashboard %d with title %s", id, name)
+                }
+                result = append(result, dashboard)
+        }
+        return result, nil
 }
 
-// there is no function to load a dashboard by name
-func (d *dashboardsClient) GetByName(name string) ([]interface{}, error) {
-        return []interface{}{}, nil
+func (d *dashboardsClient) idsByTitle(ctx context.Context, title string) ([]int, error) {
+        d.titlesMutex.Lock()
+        defer d.titlesMutex.Unlock()
+        if d.titles == nil {
+                if err := ctx.Err(); err != nil {
+                        return nil, err
+                }
+                dashboards, err := d.ddClient.GetDashboards()
+                if err != nil {
+                        return nil, errors.WithMessage(err, "get all dashboards")
+                }
+                d.titles = map[string][]int{}
+                for _, dashboard := range dashboards {
+                        d.titles[dashboard.GetTitle()] = append(d.titles[dashboard.GetTitle()], dashboard.GetId())
+                }
+        }
+        return append([]int{}, d.titles[title]...), nil
 }
 
-func (d *dashboardsClient) Create(e ConfigElement) (interface{}, error) {
-        return d.ddClient.CreateDashboard((e.GetDelegate()).(*datadog.Dashboard))
+// indexTitle moves the dashboard to the given title in the index, an empty title removes it
+func (d *dashboardsClient) indexTitle(id int, title string) {
+        d.titlesMutex.Lock()
+        defer d.titlesMutex.Unlock()
+        if d.titles == nil {
+                return
+        }
+        for t, ids := range d.titles {
+                for i, existing := range ids {
+                        if existing == id {
+                                d.titles[t] = append(ids[:i:i], ids[i+1:]...)
+                                break
+                        }
+                }
+        }
+        if title != "" {
+                d.titles[title] = append(d.titles[title], id)
+        }
 }
 
-func (d *dashboardsClient) Delete(id int) error {
-        return d.ddClient.DeleteDashboard(id)
+func (d *dashboardsClient) Create(ctx context.Context, e ConfigElement) (ConfigElement, error) {
+        if err := ctx.Err(); err != nil {
+                return nil, err
+        }
+        dashboard, err := d.ddClient.CreateDashboard((e.GetDelegate()).(*datadog.Dashboard))
+        if err != nil {
+                return nil, err
+        }
+        d.indexTitle(dashboard.GetId(), dashboard.GetTitle())
+        return d.newConfigElement(dashboard.Title, dashboard.Id, dashboard), nil
+}
+
+func (d *dashboardsClient) Update(ctx context.Context, e ConfigElement) error {
+        if err := ctx.Err(); err != nil {
+                return err
+        }
+        dashboard := (e.GetDelegate()).(*datadog.Dashboard)
+        if err := d.ddClient.UpdateDashboard(dashboard); err != nil {
+                return err
+        }
+        d.indexTitle(dashboard.GetId(), dashboard.GetTitle())
+        return nil
+}
+
+func (d *dashboardsClient) Delete(ctx context.Context, id int) error {
+        if err := ctx.Err(); err != nil {
+                return err
+        }
+        if err := d.ddClient.DeleteDashboard(id); err != nil {
+                return err
+        }
+        d.indexTitle(id, "")
+        return nil
 }
 
 func (d *dashboardsClient) toInterfaceSlice(dashboards []datadog.Dashboard) []interface{} {
@@ -98,6 +233,63 @@ func (d dashboardConfigElement) GetId() int {
         return d.Id
 }
 
+// dashboards have no tags
+func (d dashboardConfigElement) GetTags() []string {
+        return nil
+}
+
+// GetReferences returns nil, dashboards are legacy timeboards whose graphs only hold metric, log,
+// apm and process queries. The widgets referring to monitors by id (alert_graph, alert_value) exist
+// on screenboards and new dashboards only, which this client does not back up.
+func (d dashboardConfigElement) GetReferences() []Reference {
+        return nil
+}
+
+// RemapReferences does nothing, timeboards have no references, see GetReferences
+func (d dashboardConfigElement) RemapReferences(ids map[Reference]int) {
+}
+
+func (d dashboardConfigElement) GetExternalId() string {
+        if d.Delegate == nil {
+                return ""
+        }
+        return textMarkerValue(d.Delegate.Description, externalIdPrefix)
+}
+
+func (d dashboardConfigElement) SetExternalId(externalId string) {
+        if d.Delegate != nil && d.GetExternalId() == "" {
+                d.Delegate.Description = textWithMarker(d.Delegate.Description, externalIdPrefix+externalId)
+        }
+}
+
+// Fingerprint is built from the graphs of the dashboard
+func (d dashboardConfigElement) Fingerprint() string {
+        if d.Delegate == nil || len(d.Delegate.Graphs) == 0 {
+                return ""
+        }
+        graphs, err := json.Marshal(d.Delegate.Graphs)
+        if err != nil {
+                return ""
+        }
+        return fingerprint(string(graphs))
+}
+
+func (d dashboardConfigElement) SetRemoteId(id int) {
+        if d.Delegate != nil {
+                d.Delegate.SetId(id)
+        }
+}
+
+func (d dashboardConfigElement) IsOwnedBy(owner string) bool {
+        return d.Delegate != nil && textHasMarker(d.Delegate.Description, owner)
+}
+
+func (d dashboardConfigElement) SetOwner(owner string) {
+        if d.Delegate != nil {
+                d.Delegate.Description = textWithMarker(d.Delegate.Description, owner)
+        }
+}
+
 func (d dashboardConfigElement) GetDelegate() interface{} {
         return d.Delegate
 }
@@ -0,0 +1,96 @@
+package internal
+
+import (
+        "bytes"
+        "context"
+        "encoding/json"
+        "fmt"
+        "github.com/pkg/errors"
+        "github.com/zorkian/go-datadog-api"
+        "io/ioutil"
+        "net/http"
+        "strings"
+)
+
+// apiClient calls datadog endpoints which are not supported by the datadog client library. It
+// shares the http client of the library, so requests go through the same transports.
+type apiClient struct {
+        ddClient *datadog.Client
+        apiKey   string
+        appKey   string
+}
+
+func newApiClient(ddClient *datadog.Client, apiKey, appKey string) *apiClient {
+        return &apiClient{
+                ddClient: ddClient,
+                apiKey:   apiKey,
+                appKey:   appKey,
+        }
+}
+
+// APIError is a response of datadog with a non 2xx status code
+type APIError struct {
+        StatusCode int
+        Errors     []string
+        Body       string
+}
+
+func (e *APIError) Error() string {
+        if len(e.Errors) > 0 {
+                return fmt.Sprintf("API error %d: %s", e.StatusCode, strings.Join(e.Errors, ", "))
+        }
+        return fmt.Sprintf("API error %d: %s", e.StatusCode, e.Body)
+}
+
+// isNotFound reports whether an element does not exist in datadog, go-datadog-api reports a
+// non 2xx response as a plain error starting with the status
+func isNotFound(err error) bool {
+        var apiError *APIError
+        if errors.As(err, &apiError) {
+                return apiError.StatusCode == http.StatusNotFound
+        }
+        return err != nil && strings.HasPrefix(errors.Cause(err).Error(), "API error 404 ")
+}
+
+// doJson sends the body as json and decodes the response into out if it is not nil
+func (a *apiClient) doJson(ctx context.Context, method, path string, body, out interface{}) error {
+        var reqBody []byte
+        if body != nil {
+                var err error
+                if reqBody, err = json.Marshal(body); err != nil {
+                        return errors.WithMessagef(err, "cannot encode request to %s", path)
+                }
+        }
+        req, err := http.NewRequest(method, a.ddClient.GetBaseUrl()+path, bytes.NewReader(reqBody))
+        if err != nil {
+                return err
+        }
+        req = req.WithContext(ctx)
+        req.Header.Set("DD-API-KEY", a.apiKey)
+        req.Header.Set("DD-APPLICATION-KEY", a.appKey)
+        req.Header.Set("Content-Type", "application/json")
+
+        resp, err := a.ddClient.HttpClient.Do(req)
+        if err != nil {
+                return errors.WithMessagef(err, "request %s %s", method, path)
+        }
+        defer closeQuietly(resp.Body)
+        respBody, err := ioutil.ReadAll(resp.Body)
+        if err != nil {
+                return errors.WithMessagef(err, "cannot read response of %s", path)
+        }
+        if resp.StatusCode < 200 || resp.StatusCode > 299 {
+                apiError := &APIError{StatusCode: resp.StatusCode, Body: string(respBody)}
+                var errorResponse struct {
+                        Errors []string `json:"errors"`
+                }
+                if json.Unmarshal(respBody, &errorResponse) == nil {
+                        apiError.Errors = errorResponse.Errors
+                }
+                return apiError
+        }
+        if out == nil || len(respBody) == 0 {
+                return nil
+        }
+        return errors.WithMessagef(json.Unmarshal(respBody, out), "cannot decode response of %s", path)
+}
@@ -1,16 +1,63 @@
 package internal
 
-import "os"
+import (
+        "context"
+        "fmt"
+        "io"
+        "strings"
+)
 
 type DatadogConfigClient interface {
         ConfigClientName() string
-        DecodeFile(file *os.File) ([]ConfigElement, error)
-        GetAll() (*ConfigElements, error)
-        GetById(id int) (interface{}, error)
-        GetByName(name string) ([]interface{}, error)
+        DecodeFile(reader io.Reader) ([]ConfigElement, error)
+        GetAll(ctx context.Context) (*ConfigElements, error)
+        GetById(ctx context.Context, id int) (ConfigElement, error)
+        GetByName(ctx context.Context, name string) ([]ConfigElement, error)
 
-        Create(e ConfigElement) (interface{}, error)
-        Delete(id int) error
+        Create(ctx context.Context, e ConfigElement) (ConfigElement, error)
+        Update(ctx context.Context, e ConfigElement) error
+        Delete(ctx context.Context, id int) error
+}
+
+// ElementError is the error of a single config element which could not be processed
+type ElementError struct {
+        Id   int
+        Name string
+        Err  error
+}
+
+func (e ElementError) Error() string {
+        return fmt.Sprintf("element %d (%s): %s", e.Id, e.Name, e.Err)
+}
+
+// ElementErrors collects the errors of single config elements, it is returned together with
+// the elements that could be processed
+type ElementErrors []ElementError
+
+func (e ElementErrors) Error() string {
+        msgs := make([]string, len(e))
+        for i := range e {
+                msgs[i] = e[i].Error()
+        }
+        return fmt.Sprintf("%d element(s) failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// RemoteValidator is implemented by config clients which can let datadog validate an element
+// without creating it
+type RemoteValidator interface {
+        ValidateRemote(ctx context.Context, e ConfigElement) error
+}
+
+// PushPreparer is implemented by config clients which check or adjust elements right before
+// they are pushed, an element is skipped if PreparePush returns a reason
+type PushPreparer interface {
+        PreparePush(e ConfigElement) (skipReason string)
+}
+
+// DuplicateFinder is implemented by config clients whose elements are identified by more than
+// their name, push uses it instead of GetByName to find remote duplicates of an element
+type DuplicateFinder interface {
+        FindDuplicates(ctx context.Context, e ConfigElement) ([]ConfigElement, error)
 }
 
 type ConfigElements struct {
@@ -18,8 +65,36 @@ type ConfigElements struct {
         Delegate []interface{}
 }
 
+// Filter returns the elements matching the filter, together with their delegates
+func (c *ConfigElements) Filter(filter *Filter) *ConfigElements {
+        result := &ConfigElements{}
+        for i, e := range c.Elements {
+                if filter.Matches(e) {
+                        result.Elements = append(result.Elements, e)
+                        result.Delegate = append(result.Delegate, c.Delegate[i])
+                }
+        }
+        return result
+}
+
 type ConfigElement interface {
         GetName() string
         GetId() int
+        GetTags() []string
+        // IsOwnedBy returns true if the element carries the owner marker
+        IsOwnedBy(owner string) bool
+        // SetOwner adds the owner marker to the element
+        SetOwner(owner string)
+        // GetReferences returns the elements this element refers to by id
+        GetReferences() []Reference
+        // RemapReferences replaces the ids of the given references with new ids
+        RemapReferences(ids map[Reference]int)
+        // GetExternalId returns the external id written on creation, or an empty string
+        GetExternalId() string
+        SetExternalId(externalId string)
+        // Fingerprint identifies the content of the element independent of its name and id
+        Fingerprint() string
+        // SetRemoteId lets an update of the element go to the remote element with the given id
+        SetRemoteId(id int)
         GetDelegate() interface{}
 }
@@ -0,0 +1,114 @@
+package internal
+
+import (
+        "fmt"
+        "github.com/pkg/errors"
+        "io/ioutil"
+        "sort"
+        "strconv"
+        "strings"
+)
+
+// DeleteList selects the elements delete removes by config type and id or name
+type DeleteList struct {
+        ids   map[string]map[int]bool
+        names map[string]map[string]bool
+}
+
+// LoadDeleteList reads a file with one element per line in the form "<type> <id|name>", e.g.
+// "monitors 1234" or "dashboards Old team overview". Empty lines and lines starting with # are ignored.
+func LoadDeleteList(file string) (*DeleteList, error) {
+        content, err := ioutil.ReadFile(file)
+        if err != nil {
+                return nil, errors.WithMessagef(err, "cannot read delete list %s", file)
+        }
+        list := &DeleteList{ids: map[string]map[int]bool{}, names: map[string]map[string]bool{}}
+        for i, line := range strings.Split(string(content), "\n") {
+                line = strings.TrimSpace(line)
+                if line == "" || strings.HasPrefix(line, "#") {
+                        continue
+                }
+                parts := strings.SplitN(line, " ", 2)
+                if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+                        return nil, errors.Errorf("delete list %s line %d: expected <type> <id|name>, got %q", file, i+1, line)
+                }
+                configType, key := parts[0], strings.TrimSpace(parts[1])
+                if id, err := strconv.Atoi(key); err == nil {
+                        if list.ids[configType] == nil {
+                                list.ids[configType] = map[int]bool{}
+                        }
+                        list.ids[configType][id] = true
+                        continue
+                }
+                if list.names[configType] == nil {
+                        list.names[configType] = map[string]bool{}
+                }
+                list.names[configType][key] = true
+        }
+        return list, nil
+}
+
+// Types returns the config types on the list
+func (l *DeleteList) Types() []string {
+        var types []string
+        for configType := range l.ids {
+                types = append(types, configType)
+        }
+        for configType := range l.names {
+                if l.ids[configType] == nil {
+                        types = append(types, configType)
+                }
+        }
+        sort.Strings(types)
+        return types
+}
+
+// Select returns the elements of the config type on the list. A name matching more than one
+// element selects none of them, their ids have to be listed instead. Problems are entries which
+// match no element or too many.
+func (l *DeleteList) Select(configType string, elements []ConfigElement) (selected []ConfigElement, problems []string) {
+        named := map[string]int{}
+        for _, e := range elements {
+                named[e.GetName()]++
+        }
+        foundIds := map[int]bool{}
+        foundNames := map[string]bool{}
+        for _, e := range elements {
+                byId := l.ids[configType][e.GetId()]
+                byName := l.names[configType][e.GetName()]
+                if byId {
+                        foundIds[e.GetId()] = true
+                }
+                if byName {
+                        foundNames[e.GetName()] = true
+                }
+                if byId || byName && named[e.GetName()] == 1 {
+                        selected = append(selected, e)
+                }
+        }
+
+        var ids []int
+        for id := range l.ids[configType] {
+                ids = append(ids, id)
+        }
+        sort.Ints(ids)
+        for _, id := range ids {
+                if !foundIds[id] {
+                        problems = append(problems, fmt.Sprintf("%s %d does not exist or is not selected by the filter", configType, id))
+                }
+        }
+        var names []string
+        for name := range l.names[configType] {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+        for _, name := range names {
+                switch {
+                case !foundNames[name]:
+                        problems = append(problems, fmt.Sprintf("%s %q does not exist or is not selected by the filter", configType, name))
+                case named[name] > 1:
+                        problems = append(problems, fmt.Sprintf("%s %q is the name of %d elements, list their ids instead", configType, name, named[name]))
+                }
+        }
+        return selected, problems
+}
@@ -0,0 +1,168 @@
+package internal
+
+import (
+        "context"
+        "io/ioutil"
+        "os"
+        "path/filepath"
+        "reflect"
+        "strings"
+        "testing"
+)
+
+func writeDeleteList(t *testing.T, content string) string {
+        t.Helper()
+        dir, err := ioutil.TempDir("", "delete-list")
+        if err != nil {
+                t.Fatal(err)
+        }
+        t.Cleanup(func() { os.RemoveAll(dir) })
+        file := filepath.Join(dir, "delete.txt")
+        if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+                t.Fatal(err)
+        }
+        return file
+}
+
+func TestLoadDeleteList(t *testing.T) {
+        tests := []struct {
+                name    string
+                content string
+                types   []string
+                ids     map[string]map[int]bool
+                names   map[string]map[string]bool
+                wantErr string
+        }{
+                {
+                        name:    "ids and names",
+                        content: "# old elements\nmonitors 12\n\n  dashboards Old team overview  \nmonitors  cpu high\r\ndowntimes 3\n",
+                        types:   []string{"dashboards", "downtimes", "monitors"},
+                        ids:     map[string]map[int]bool{"monitors": {12: true}, "downtimes": {3: true}},
+                        names:   map[string]map[string]bool{"dashboards": {"Old team overview": true}, "monitors": {"cpu high": true}},
+                },
+                {
+                        name:  "empty",
+                        ids:   map[string]map[int]bool{},
+                        names: map[string]map[string]bool{},
+                },
+                {name: "type only", content: "monitors 1\nmonitors\n", wantErr: "line 2: expected <type> <id|name>"},
+                {name: "blank key", content: "monitors   \n", wantErr: "line 1"},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        list, err := LoadDeleteList(writeDeleteList(t, test.content))
+                        if test.wantErr != "" {
+                                if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+                                        t.Fatalf("error = %v, want %q", err, test.wantErr)
+                                }
+                                return
+                        }
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        if !reflect.DeepEqual(list.ids, test.ids) || !reflect.DeepEqual(list.names, test.names) {
+                                t.Errorf("ids %v and names %v, want %v and %v", list.ids, list.names, test.ids, test.names)
+                        }
+                        if types := list.Types(); !reflect.DeepEqual(types, test.types) {
+                                t.Errorf("types = %v, want %v", types, test.types)
+                        }
+                })
+        }
+        if _, err := LoadDeleteList(filepath.Join(os.TempDir(), "no-such-delete-list")); err == nil {
+                t.Error("expected an error for a missing file")
+        }
+}
+
+func TestDeleteListSelect(t *testing.T) {
+        monitors := namedMonitors(t,
+                "{name: cpu, type: metric alert, query: a}",
+                "{name: disk, type: metric alert, query: b}",
+                "{name: disk, type: metric alert, query: c}",
+                "{name: mem, type: metric alert, query: d}",
+        )
+        tests := []struct {
+                name     string
+                content  string
+                selected []int
+                problems []string
+        }{
+                {"by id", "monitors 2\nmonitors 4\n", []int{2, 4}, nil},
+                {"by name", "monitors cpu\n", []int{1}, nil},
+                {"by id and name of the same element", "monitors 1\nmonitors cpu\n", []int{1}, nil},
+                {"shared name selects none", "monitors disk\nmonitors 3\n", []int{3}, []string{`monitors "disk" is the name of 2 elements, list their ids instead`}},
+                {"missing", "monitors 9\nmonitors net\n", nil, []string{
+                        "monitors 9 does not exist or is not selected by the filter",
+                        `monitors "net" does not exist or is not selected by the filter`,
+                }},
+                {"other types", "dashboards 1\ndashboards cpu\n", nil, nil},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        list, err := LoadDeleteList(writeDeleteList(t, test.content))
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        selected, problems := list.Select("monitors", monitors)
+                        var ids []int
+                        for _, e := range selected {
+                                ids = append(ids, e.GetId())
+                        }
+                        if !reflect.DeepEqual(ids, test.selected) {
+                                t.Errorf("selected = %v, want %v", ids, test.selected)
+                        }
+                        if !reflect.DeepEqual(problems, test.problems) {
+                                t.Errorf("problems = %q, want %q", problems, test.problems)
+                        }
+                })
+        }
+}
+
+func TestDeleteByIdRequiresOneType(t *testing.T) {
+        tests := []struct {
+                name    string
+                filter  FilterConfig
+                wantErr string
+        }{
+                {"id of all types", FilterConfig{IncludeIds: []int{123}}, "select exactly one type"},
+                {"id of two types", FilterConfig{IncludeIds: []int{123}, Types: []string{"monitors,dashboards"}}, "select exactly one type"},
+                {"id of one type", FilterConfig{IncludeIds: []int{123}, Types: []string{"monitors"}}, "backups are disabled"},
+                {"excluded id of all types", FilterConfig{ExcludeIds: []int{123}, Tags: []string{"team:a"}}, "backups are disabled"},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        service := snapshotService(t, nil, nil)
+                        service.backupStorage = nil
+                        filter, err := NewFilter(test.filter)
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        service.filter = filter
+                        if err := service.Delete(context.Background()); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+                                t.Errorf("error = %v, want %q", err, test.wantErr)
+                        }
+                })
+        }
+}
+
+func TestFilterSelects(t *testing.T) {
+        tests := []struct {
+                config   FilterConfig
+                elements bool
+                ids      bool
+        }{
+                {FilterConfig{}, false, false},
+                {FilterConfig{Types: []string{"monitors"}, ExcludeIds: []int{1}}, false, false},
+                {FilterConfig{IncludeIds: []int{1}}, true, true},
+                {FilterConfig{NameRegex: "a"}, true, false},
+                {FilterConfig{Tags: []string{"team:a"}}, true, false},
+        }
+        for _, test := range tests {
+                filter, err := NewFilter(test.config)
+                if err != nil {
+                        t.Fatal(err)
+                }
+                if filter.SelectsElements() != test.elements || filter.SelectsIds() != test.ids {
+                        t.Errorf("%+v: selects elements %t and ids %t, want %t and %t", test.config, filter.SelectsElements(), filter.SelectsIds(), test.elements, test.ids)
+                }
+        }
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+        "fmt"
+        "gopkg.in/yaml.v3"
+        "sort"
+        "strings"
+)
+
+type DiffKind string
+
+const (
+        // DiffChanged elements exist locally and remotely but differ
+        DiffChanged DiffKind = "changed"
+        // DiffCreated elements exist only remotely
+        DiffCreated DiffKind = "created"
+        // DiffDeleted elements have an id locally but do not exist remotely anymore
+        DiffDeleted DiffKind = "deleted"
+        // DiffNotPushed elements exist only locally and have no id yet
+        DiffNotPushed DiffKind = "not-pushed"
+)
+
+// FieldChange is a single changed field of an element, identified by its yaml path
+type FieldChange struct {
+        Path   string `json:"path" yaml:"path"`
+        Local  string `json:"local" yaml:"local"`
+        Remote string `json:"remote" yaml:"remote"`
+}
+
+// ElementDiff is the difference between the local and the remote version of one element
+type ElementDiff struct {
+        Type    string        `json:"type" yaml:"type"`
+        Id      int           `json:"id" yaml:"id"`
+        Name    string        `json:"name" yaml:"name"`
+        Kind    DiffKind      `json:"kind" yaml:"kind"`
+        Changes []FieldChange `json:"changes,omitempty" yaml:"changes,omitempty"`
+        // Audit is the last change of the element in the datadog audit trail, if known
+        Audit *AuditEntry `json:"audit,omitempty" yaml:"audit,omitempty"`
+}
+
+func (d ElementDiff) String() string {
+        s := fmt.Sprintf("%s %d (%s) %s, %d field(s) changed", d.Type, d.Id, d.Name, d.Kind, len(d.Changes))
+        if d.Audit != nil {
+                s += ", last " + d.Audit.String()
+        }
+        return s
+}
+
+// volatileFields change on the remote side without anybody editing the element, so they are
+// ignored when comparing elements
+var volatileFields = map[string][]string{
+        "monitors":  {"state", "overallstate", "overallstatemodified"},
+        "downtimes": {"active"},
+}
+
+// DiffElements compares local and remote elements of one config type by id
+func DiffElements(configType string, local, remote []ConfigElement) []ElementDiff {
+        remoteById := map[int]ConfigElement{}
+        for _, e := range remote {
+                remoteById[e.GetId()] = e
+        }
+        var diffs []ElementDiff
+        seen := map[int]bool{}
+        for _, l := range local {
+                id := l.GetId()
+                if id == -1 {
+                        diffs = append(diffs, ElementDiff{Type: configType, Id: id, Name: l.GetName(), Kind: DiffNotPushed})
+                        continue
+                }
+                seen[id] = true
+                r, ok := remoteById[id]
+                if !ok {
+                        diffs = append(diffs, ElementDiff{Type: configType, Id: id, Name: l.GetName(), Kind: DiffDeleted})
+                        continue
+                }
+                if changes := DiffFields(configType, l, r); len(changes) > 0 {
+                        diffs = append(diffs, ElementDiff{Type: configType, Id: id, Name: r.GetName(), Kind: DiffChanged, Changes: changes})
+                }
+        }
+        for _, r := range remote {
+                if !seen[r.GetId()] {
+                        diffs = append(diffs, ElementDiff{Type: configType, Id: r.GetId(), Name: r.GetName(), Kind: DiffCreated})
+                }
+        }
+        return diffs
+}
+
+// DiffFields returns the changed fields between two versions of an element, fields which
+// only exist in one version are reported with an empty value for the other one
+func DiffFields(configType string, from, to ConfigElement) []FieldChange {
+        fromFields := FlattenElement(from)
+        toFields := FlattenElement(to)
+        paths := map[string]bool{}
+        for path := range fromFields {
+                paths[path] = true
+        }
+        for path := range toFields {
+                paths[path] = true
+        }
+        var changes []FieldChange
+        for path := range paths {
+                if isVolatileField(configType, path) || fromFields[path] == toFields[path] {
+                        continue
+                }
+                changes = append(changes, FieldChange{Path: path, Local: fromFields[path], Remote: toFields[path]})
+        }
+        sort.Slice(changes, func(i, j int) bool {
+                return changes[i].Path < changes[j].Path
+        })
+        return changes
+}
+
+// FlattenElement returns all scalar fields of the element's delegate by their yaml path,
+// e.g. options.thresholds.critical or tags[0]
+func FlattenElement(e ConfigElement) map[string]string {
+        fields := map[string]string{}
+        if e == nil {
+                return fields
+        }
+        out, err := yaml.Marshal(e.GetDelegate())
+        if err != nil {
+                return fields
+        }
+        var value interface{}
+        if err := yaml.Unmarshal(out, &value); err != nil {
+                return fields
+        }
+        flatten("", value, fields)
+        return fields
+}
+
+func flatten(path string, value interface{}, fields map[string]string) {
+        switch v := value.(type) {
+        case map[string]interface{}:
+                for key, child := range v {
+                        childPath := key
+                        if path != "" {
+                                childPath = path + "." + key
+                        }
+                        flatten(childPath, child, fields)
+                }
+        case []interface{}:
+                for i, child := range v {
+                        flatten(fmt.Sprintf("%s[%d]", path, i), child, fields)
+                }
+        case nil:
+        default:
+                fields[path] = fmt.Sprintf("%v", v)
+        }
+}
+
+func isVolatileField(configType, path string) bool {
+        for _, field := range volatileFields[configType] {
+                if path == field || strings.HasPrefix(path, field+".") || strings.HasPrefix(path, field+"[") {
+                        return true
+                }
+        }
+        return false
+}
@@ -0,0 +1,261 @@
+package internal
+
+import (
+        "context"
+        "encoding/json"
+        "github.com/sirupsen/logrus"
+        logtest "github.com/sirupsen/logrus/hooks/test"
+        "github.com/zorkian/go-datadog-api"
+        "io/ioutil"
+        "net/http"
+        "net/http/httptest"
+        "os"
+        "path/filepath"
+        "reflect"
+        "strconv"
+        "strings"
+        "testing"
+        "time"
+)
+
+// namedMonitors decodes monitors from flow mappings, named by their delegate and numbered 1, 2, ...
+func namedMonitors(t *testing.T, delegates ...string) []ConfigElement {
+        t.Helper()
+        var content strings.Builder
+        for i, delegate := range delegates {
+                name := strings.TrimSuffix(strings.SplitN(strings.TrimPrefix(delegate, "{name: "), ",", 2)[0], "}")
+                content.WriteString("- name: " + name + "\n  id: " + strconv.Itoa(i+1) + "\n  delegate: " + delegate + "\n")
+        }
+        elements, err := (&monitorsClient{}).DecodeFile(strings.NewReader(content.String()))
+        if err != nil {
+                t.Fatalf("cannot decode monitors %s: %s", content.String(), err)
+        }
+        return elements
+}
+
+// snapshotService returns a service with the files in its backup dir, current.yaml files are
+// written into the config dir as <type>.yaml
+func snapshotService(t *testing.T, backups map[string]string, current map[string]string) *backupService {
+        t.Helper()
+        dir, err := ioutil.TempDir("", "snapshots")
+        if err != nil {
+                t.Fatal(err)
+        }
+        t.Cleanup(func() { os.RemoveAll(dir) })
+        write := func(dir string, files map[string]string) Storage {
+                if err := os.MkdirAll(dir, 0755); err != nil {
+                        t.Fatal(err)
+                }
+                for name, content := range files {
+                        if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+                                t.Fatal(err)
+                        }
+                }
+                storage, err := newLocalStorage(dir)
+                if err != nil {
+                        t.Fatal(err)
+                }
+                return storage
+        }
+        return &backupService{
+                log:           logrus.WithField("prefix", "test"),
+                configClients: []DatadogConfigClient{&monitorsClient{}, &dashboardsClient{}},
+                configStorage: write(filepath.Join(dir, "config"), current),
+                backupStorage: write(filepath.Join(dir, "backup"), backups),
+        }
+}
+
+func monitorsFile(monitors ...string) string {
+        content := ""
+        for _, monitor := range monitors {
+                content += "- " + monitor + "\n"
+        }
+        return content
+}
+
+func TestDiffElements(t *testing.T) {
+        local := namedMonitors(t,
+                "{name: same, type: metric alert, query: a}",
+                "{name: changed, type: metric alert, query: b}",
+                "{name: state, type: metric alert, query: c, overallstate: OK}",
+                "{name: deleted, type: metric alert, query: d}",
+        )
+        remote := namedMonitors(t,
+                "{name: same, type: metric alert, query: a}",
+                "{name: renamed, type: metric alert, query: b2}",
+                "{name: state, type: metric alert, query: c, overallstate: Alert}",
+        )
+        remote = append(remote, monitorConfigElement{Name: "created", Id: 5})
+        local = append(local, monitorConfigElement{Name: "new", Id: -1})
+
+        var got []string
+        for _, diff := range DiffElements("monitors", local, remote) {
+                got = append(got, diff.String())
+        }
+        want := []string{
+                "monitors 2 (renamed) changed, 2 field(s) changed",
+                "monitors 4 (deleted) deleted, 0 field(s) changed",
+                "monitors -1 (new) not-pushed, 0 field(s) changed",
+                "monitors 5 (created) created, 0 field(s) changed",
+        }
+        if !reflect.DeepEqual(got, want) {
+                t.Errorf("diffs =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+        }
+}
+
+func TestDiffFields(t *testing.T) {
+        tests := []struct {
+                name       string
+                configType string
+                from, to   string
+                want       []FieldChange
+        }{
+                {
+                        name: "scalars", configType: "monitors",
+                        from: "{name: a, type: metric alert, query: q1}",
+                        to:   "{name: b, type: metric alert, query: q2}",
+                        want: []FieldChange{{"name", "a", "b"}, {"query", "q1", "q2"}},
+                },
+                {
+                        name: "nested fields and lists", configType: "monitors",
+                        from: "{name: a, tags: [x, y], options: {thresholds: {critical: 1}}}",
+                        to:   "{name: a, tags: [x], options: {thresholds: {critical: 2}, renotifyinterval: 10}}",
+                        want: []FieldChange{
+                                {"options.renotifyinterval", "", "10"},
+                                {"options.thresholds.critical", "1", "2"},
+                                {"tags[1]", "y", ""},
+                        },
+                },
+                {
+                        name: "volatile monitor fields", configType: "monitors",
+                        from: "{name: a, overallstate: OK, state: {groups: {a: {status: OK}}}}",
+                        to:   "{name: a, overallstate: Alert, state: {groups: {a: {status: Alert}}}}",
+                },
+                {
+                        name: "volatile fields of other types count", configType: "dashboards",
+                        from: "{name: a, overallstate: OK}",
+                        to:   "{name: a, overallstate: Alert}",
+                        want: []FieldChange{{"overallstate", "OK", "Alert"}},
+                },
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        monitors := namedMonitors(t, test.from, test.to)
+                        if got := DiffFields(test.configType, monitors[0], monitors[1]); !reflect.DeepEqual(got, test.want) {
+                                t.Errorf("changes = %v, want %v", got, test.want)
+                        }
+                })
+        }
+}
+
+func TestFlattenElement(t *testing.T) {
+        monitor := namedMonitors(t, "{name: a, tags: [x], options: {silenced: {'*': 0}}}")[0]
+        fields := FlattenElement(monitor)
+        for path, want := range map[string]string{"name": "a", "tags[0]": "x", "options.silenced.*": "0"} {
+                if fields[path] != want {
+                        t.Errorf("%s = %q, want %q", path, fields[path], want)
+                }
+        }
+        if _, ok := fields["query"]; ok {
+                t.Error("unset fields are not flattened")
+        }
+        if fields := FlattenElement(nil); len(fields) != 0 {
+                t.Errorf("fields of nil = %v, want none", fields)
+        }
+}
+
+func TestPruneRefusesTypesWithoutConfigFile(t *testing.T) {
+        service := snapshotService(t, nil, map[string]string{"monitors.yaml": "[]"})
+        service.owner = "managed-by:test"
+        filter, err := NewFilter(FilterConfig{})
+        if err != nil {
+                t.Fatal(err)
+        }
+        service.filter = filter
+        // the clients have no api, pruning any of them would panic
+        if err := service.Prune(context.Background()); err == nil || !strings.Contains(err.Error(), "no config file") || !strings.Contains(err.Error(), "dashboards.yaml") {
+                t.Errorf("error = %v, want a refusal for the missing dashboards.yaml", err)
+        }
+}
+
+func TestAnnotateDrift(t *testing.T) {
+        var queries []string
+        status := http.StatusOK
+        server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                var request auditSearchRequest
+                if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+                        t.Error(err)
+                }
+                if r.URL.Path != "/api/v2/audit/events/search" || r.Header.Get("DD-APPLICATION-KEY") != "app" {
+                        t.Errorf("request %s with application key %q, want the audit search", r.URL.Path, r.Header.Get("DD-APPLICATION-KEY"))
+                }
+                queries = append(queries, request.Filter.Query+" from "+request.Filter.From+" cursor "+request.Page.Cursor)
+                w.WriteHeader(status)
+                if request.Page.Cursor == "" {
+                        // events are sorted newest first, the second change of monitor 1 is older
+                        _, _ = w.Write([]byte(`{"data": [
+                                {"attributes": {"timestamp": "2026-10-02T10:00:00Z", "attributes": {"action": "modified", "usr": {"email": "a@example.com"}, "asset": {"id": "1", "type": "monitor"}}}},
+                                {"attributes": {"timestamp": "2026-10-01T10:00:00Z", "attributes": {"action": "created", "usr": {"email": "b@example.com"}, "asset": {"id": "1", "type": "monitor"}}}}
+                        ], "meta": {"page": {"after": "next"}}}`))
+                        return
+                }
+                _, _ = w.Write([]byte(`{"data": [
+                        {"attributes": {"timestamp": "2026-09-30T10:00:00Z", "attributes": {"action": "deleted", "usr": {"name": "c"}, "asset": {"id": "2", "type": "monitor"}}}}
+                ]}`))
+        }))
+        defer server.Close()
+        ddClient := datadog.NewClient("api", "app")
+        ddClient.SetBaseUrl(server.URL)
+        service := &backupService{log: logrus.WithField("prefix", "test"), api: newApiClient(ddClient, "api", "app")}
+        since := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+
+        diffs := []ElementDiff{
+                {Type: "monitors", Id: 1, Kind: DiffChanged},
+                {Type: "monitors", Id: 2, Kind: DiffDeleted},
+                {Type: "monitors", Id: 3, Kind: DiffNotPushed},
+        }
+        service.annotateDrift(context.Background(), "monitors", diffs, since)
+        want := []string{
+                "@asset.type:monitor @asset.id:(1 OR 2) from " + since.Format(time.RFC3339) + " cursor ",
+                "@asset.type:monitor @asset.id:(1 OR 2) from " + since.Format(time.RFC3339) + " cursor next",
+        }
+        if !reflect.DeepEqual(queries, want) {
+                t.Errorf("queries = %q, want %q", queries, want)
+        }
+        if got, want := diffs[0].String(), "monitors 1 () changed, 0 field(s) changed, last modified by a@example.com at 2026-10-02T10:00:00Z"; got != want {
+                t.Errorf("diff = %s, want %s", got, want)
+        }
+        if diffs[1].Audit == nil || diffs[1].Audit.Author != "c" || diffs[2].Audit != nil {
+                t.Errorf("audit = %v, %v, want the deleted monitor only", diffs[1].Audit, diffs[2].Audit)
+        }
+
+        // without access to the audit trail the drift stays as it is
+        status = http.StatusForbidden
+        diffs = []ElementDiff{{Type: "monitors", Id: 1, Kind: DiffChanged}}
+        service.annotateDrift(context.Background(), "monitors", diffs, since)
+        if diffs[0].Audit != nil {
+                t.Errorf("audit = %v, want none without access", diffs[0].Audit)
+        }
+}
+
+func TestPruneLogsStructuredFields(t *testing.T) {
+        service := snapshotService(t, nil, map[string]string{"monitors.yaml": monitorsFile("{name: m0, id: 1, delegate: {name: m0, query: a}}")})
+        logger, hook := logtest.NewNullLogger()
+        service.log = logrus.NewEntry(logger).WithField("prefix", "test")
+        service.dryRun = true
+        service.filter = &Filter{}
+        service.idMap = NewIdMap()
+        service.report = NewReport()
+        service.stop = make(chan struct{})
+        client := &pullClient{monitorsClient: &monitorsClient{}, name: "monitors", remote: testMonitors(t, "{query: a}", "{query: b}")}
+        if _, err := service.prune(context.Background(), client); err != nil {
+                t.Fatal(err)
+        }
+        if len(hook.Entries) != 1 {
+                t.Fatalf("entries = %v, want the pruned element", hook.Entries)
+        }
+        want := logrus.Fields{"prefix": "test", "client": "monitors", "action": "prune", "element_id": 2, "element_name": "m1", "outcome": OutcomeDeleted}
+        if got := hook.LastEntry().Data; !reflect.DeepEqual(got, want) {
+                t.Errorf("fields = %v, want %v", got, want)
+        }
+}
@@ -0,0 +1,165 @@
+package internal
+
+import (
+        "fmt"
+        "github.com/zorkian/go-datadog-api"
+        "sort"
+        "strings"
+        "time"
+)
+
+type DowntimeState string
+
+const (
+        // DowntimeExpired is a downtime which ended or was canceled
+        DowntimeExpired DowntimeState = "expired"
+        // DowntimeActive is a one time downtime which started and has not ended yet
+        DowntimeActive DowntimeState = "active"
+        // DowntimeScheduled is a one time downtime which starts in the future
+        DowntimeScheduled DowntimeState = "scheduled"
+        // DowntimeRecurring is a downtime with a recurrence which has not ended yet
+        DowntimeRecurring DowntimeState = "recurring"
+)
+
+// maxOccurrences bounds the search for the next occurrence of a recurring downtime
+const maxOccurrences = 100000
+
+var weekDays = map[string]time.Weekday{
+        "Sun": time.Sunday, "Mon": time.Monday, "Tue": time.Tuesday, "Wed": time.Wednesday,
+        "Thu": time.Thursday, "Fri": time.Friday, "Sat": time.Saturday,
+}
+
+// ClassifyDowntime returns the state of the downtime at the given time
+func ClassifyDowntime(downtime *datadog.Downtime, now time.Time) DowntimeState {
+        if downtime.Canceled != nil {
+                return DowntimeExpired
+        }
+        if downtime.Recurrence != nil {
+                if downtime.Recurrence.UntilDate != nil && int64(downtime.Recurrence.GetUntilDate()) <= now.Unix() {
+                        return DowntimeExpired
+                }
+                return DowntimeRecurring
+        }
+        if downtime.End != nil && int64(downtime.GetEnd()) <= now.Unix() {
+                return DowntimeExpired
+        }
+        if downtime.Start == nil || int64(downtime.GetStart()) <= now.Unix() {
+                return DowntimeActive
+        }
+        return DowntimeScheduled
+}
+
+// nextOccurrence returns the start of the first occurrence of a recurring downtime which has not
+// ended at the given time, false if there is none. The start is returned unchanged if its first
+// occurrence has not ended yet.
+func nextOccurrence(downtime *datadog.Downtime, now time.Time) (time.Time, bool) {
+        if downtime.Recurrence == nil || downtime.Start == nil {
+                return time.Time{}, false
+        }
+        location := time.UTC
+        if timezone := downtime.GetTimezone(); timezone != "" {
+                if l, err := time.LoadLocation(timezone); err == nil {
+                        location = l
+                }
+        }
+        start := time.Unix(int64(downtime.GetStart()), 0).In(location)
+        var duration time.Duration
+        if downtime.End != nil {
+                duration = time.Unix(int64(downtime.GetEnd()), 0).Sub(start)
+        }
+        recurrence := downtime.Recurrence
+        period := recurrence.GetPeriod()
+        if period < 1 {
+                period = 1
+        }
+        var days map[time.Weekday]bool
+        if recurrence.GetType() == "weeks" && len(recurrence.WeekDays) > 0 {
+                days = map[time.Weekday]bool{}
+                for _, day := range recurrence.WeekDays {
+                        if weekDay, ok := weekDays[day]; ok {
+                                days[weekDay] = true
+                        }
+                }
+                if len(days) == 0 {
+                        // no known week day, recur weekly from the start
+                        days = nil
+                }
+        }
+        // weeks are counted from the monday of the week of the first occurrence
+        weekOffset := (int(start.Weekday()) + 6) % 7
+
+        for i := 0; i < maxOccurrences; i++ {
+                var candidate time.Time
+                switch {
+                case i == 0:
+                        candidate = start
+                case days != nil:
+                        candidate = start.AddDate(0, 0, i)
+                        if !days[candidate.Weekday()] || ((weekOffset+i)/7)%period != 0 {
+                                continue
+                        }
+                case recurrence.GetType() == "days":
+                        candidate = start.AddDate(0, 0, i*period)
+                case recurrence.GetType() == "weeks":
+                        candidate = start.AddDate(0, 0, 7*i*period)
+                case recurrence.GetType() == "months":
+                        candidate = start.AddDate(0, i*period, 0)
+                case recurrence.GetType() == "years":
+                        candidate = start.AddDate(i*period, 0, 0)
+                default:
+                        return time.Time{}, false
+                }
+                if recurrence.UntilDate != nil && candidate.Unix() > int64(recurrence.GetUntilDate()) {
+                        return time.Time{}, false
+                }
+                if candidate.Add(duration).After(now) {
+                        return candidate, true
+                }
+        }
+        return time.Time{}, false
+}
+
+// prepareDowntime re-anchors the downtime for a push at the given time: recurring downtimes
+// are moved to their next occurrence and active one time downtimes start now. It returns the
+// state of the downtime.
+func prepareDowntime(downtime *datadog.Downtime, now time.Time) DowntimeState {
+        state := ClassifyDowntime(downtime, now)
+        switch state {
+        case DowntimeRecurring:
+                next, ok := nextOccurrence(downtime, now)
+                if !ok {
+                        if downtime.Start != nil {
+                                return DowntimeExpired
+                        }
+                        return state
+                }
+                if downtime.End != nil {
+                        downtime.SetEnd(downtime.GetEnd() + int(next.Unix()) - downtime.GetStart())
+                }
+                downtime.SetStart(int(next.Unix()))
+        case DowntimeActive:
+                if downtime.Start != nil && int64(downtime.GetStart()) < now.Unix() {
+                        downtime.SetStart(int(now.Unix()))
+                }
+        }
+        return state
+}
+
+// downtimeName is a readable name of the downtime, it does not change with its message or
+// schedule, e.g. monitor 123 on env:prod
+func downtimeName(downtime *datadog.Downtime) string {
+        target := "all monitors"
+        if downtime.MonitorId != nil {
+                target = fmt.Sprintf("monitor %d", downtime.GetMonitorId())
+        } else if len(downtime.MonitorTags) > 0 && !(len(downtime.MonitorTags) == 1 && downtime.MonitorTags[0] == "*") {
+                tags := append([]string{}, downtime.MonitorTags...)
+                sort.Strings(tags)
+                target = "monitors tagged " + strings.Join(tags, ",")
+        }
+        scope := append([]string{}, downtime.Scope...)
+        sort.Strings(scope)
+        if len(scope) == 0 {
+                scope = []string{"*"}
+        }
+        return target + " on " + strings.Join(scope, ",")
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+        "github.com/zorkian/go-datadog-api"
+        "testing"
+        "time"
+)
+
+// 2024-01-01 is a monday
+var scheduleStart = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+func at(month time.Month, day, hour, min int) time.Time {
+        return time.Date(2024, month, day, hour, min, 0, 0, time.UTC)
+}
+
+// testDowntime returns a downtime of one hour starting at start, recurring if recurrence is not nil
+func testDowntime(start time.Time, recurrence *datadog.Recurrence) *datadog.Downtime {
+        downtime := &datadog.Downtime{}
+        downtime.SetStart(int(start.Unix()))
+        downtime.SetEnd(int(start.Add(time.Hour).Unix()))
+        downtime.Recurrence = recurrence
+        return downtime
+}
+
+func recurrence(recurrenceType string, period int, weekDays ...string) *datadog.Recurrence {
+        r := &datadog.Recurrence{}
+        r.SetType(recurrenceType)
+        r.SetPeriod(period)
+        r.WeekDays = weekDays
+        return r
+}
+
+func until(r *datadog.Recurrence, t time.Time) *datadog.Recurrence {
+        r.SetUntilDate(int(t.Unix()))
+        return r
+}
+
+func TestNextOccurrence(t *testing.T) {
+        berlin, err := time.LoadLocation("Europe/Berlin")
+        if err != nil {
+                t.Fatal(err)
+        }
+        // the day before the switch to summer time
+        berlinStart := time.Date(2024, 3, 30, 10, 0, 0, 0, berlin)
+        berlinDowntime := testDowntime(berlinStart, recurrence("days", 1))
+        berlinDowntime.SetTimezone("Europe/Berlin")
+        noEnd := testDowntime(scheduleStart, recurrence("days", 1))
+        noEnd.End = nil
+
+        tests := []struct {
+                name     string
+                downtime *datadog.Downtime
+                now      time.Time
+                want     time.Time
+                found    bool
+        }{
+                {"one time downtime", testDowntime(scheduleStart, nil), at(1, 1, 9, 0), time.Time{}, false},
+                {"first occurrence not started", testDowntime(scheduleStart, recurrence("days", 1)), at(1, 1, 9, 0), scheduleStart, true},
+                {"first occurrence not ended", testDowntime(scheduleStart, recurrence("days", 1)), at(1, 1, 10, 30), scheduleStart, true},
+                {"daily", testDowntime(scheduleStart, recurrence("days", 1)), at(1, 5, 12, 0), at(1, 6, 10, 0), true},
+                {"daily occurrence not ended", testDowntime(scheduleStart, recurrence("days", 1)), at(1, 5, 10, 30), at(1, 5, 10, 0), true},
+                {"without end", noEnd, at(1, 5, 10, 0), at(1, 6, 10, 0), true},
+                {"every other day", testDowntime(scheduleStart, recurrence("days", 2)), at(1, 4, 10, 30), at(1, 5, 10, 0), true},
+                {"weekly", testDowntime(scheduleStart, recurrence("weeks", 1)), at(1, 2, 0, 0), at(1, 8, 10, 0), true},
+                {"week days", testDowntime(scheduleStart, recurrence("weeks", 1, "Mon", "Wed")), at(1, 2, 0, 0), at(1, 3, 10, 0), true},
+                {"week days of the next week", testDowntime(scheduleStart, recurrence("weeks", 1, "Mon", "Wed")), at(1, 3, 12, 0), at(1, 8, 10, 0), true},
+                {"week days every other week", testDowntime(scheduleStart, recurrence("weeks", 2, "Fri")), at(1, 6, 0, 0), at(1, 19, 10, 0), true},
+                {"unknown week days", testDowntime(scheduleStart, recurrence("weeks", 1, "Funday")), at(1, 2, 0, 0), at(1, 8, 10, 0), true},
+                {"monthly", testDowntime(scheduleStart, recurrence("months", 1)), at(2, 15, 0, 0), at(3, 1, 10, 0), true},
+                {"yearly", testDowntime(scheduleStart, recurrence("years", 1)), at(6, 1, 0, 0), time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC), true},
+                {"period 0 is 1", testDowntime(scheduleStart, recurrence("days", 0)), at(1, 5, 12, 0), at(1, 6, 10, 0), true},
+                {"last occurrence at the until date", testDowntime(scheduleStart, until(recurrence("days", 1), at(1, 3, 10, 0))), at(1, 2, 12, 0), at(1, 3, 10, 0), true},
+                {"after the until date", testDowntime(scheduleStart, until(recurrence("days", 1), at(1, 3, 10, 0))), at(1, 3, 12, 0), time.Time{}, false},
+                {"unknown type", testDowntime(scheduleStart, recurrence("hours", 1)), at(1, 1, 12, 0), time.Time{}, false},
+                {"local time across summer time", berlinDowntime, berlinStart.Add(2 * time.Hour), time.Date(2024, 3, 31, 10, 0, 0, 0, berlin), true},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        got, found := nextOccurrence(test.downtime, test.now)
+                        if found != test.found || !got.Equal(test.want) {
+                                t.Errorf("nextOccurrence = %s, %t, want %s, %t", got, found, test.want, test.found)
+                        }
+                })
+        }
+}
+
+func TestClassifyDowntime(t *testing.T) {
+        canceled := testDowntime(scheduleStart, nil)
+        canceled.SetCanceled(int(scheduleStart.Unix()))
+        noStart := testDowntime(scheduleStart, nil)
+        noStart.Start = nil
+        noStart.End = nil
+        tests := []struct {
+                name     string
+                downtime *datadog.Downtime
+                now      time.Time
+                want     DowntimeState
+        }{
+                {"canceled", canceled, at(1, 1, 9, 0), DowntimeExpired},
+                {"scheduled", testDowntime(scheduleStart, nil), at(1, 1, 9, 0), DowntimeScheduled},
+                {"active", testDowntime(scheduleStart, nil), at(1, 1, 10, 30), DowntimeActive},
+                {"ended", testDowntime(scheduleStart, nil), at(1, 1, 11, 0), DowntimeExpired},
+                {"without start", noStart, at(1, 1, 9, 0), DowntimeActive},
+                {"recurring", testDowntime(scheduleStart, recurrence("days", 1)), at(6, 1, 0, 0), DowntimeRecurring},
+                {"recurring until a past date", testDowntime(scheduleStart, until(recurrence("days", 1), at(1, 3, 0, 0))), at(1, 3, 0, 0), DowntimeExpired},
+        }
+        for _, test := range tests {
+                if got := ClassifyDowntime(test.downtime, test.now); got != test.want {
+                        t.Errorf("%s: state = %s, want %s", test.name, got, test.want)
+                }
+        }
+}
+
+func TestPrepareDowntime(t *testing.T) {
+        tests := []struct {
+                name      string
+                downtime  *datadog.Downtime
+                now       time.Time
+                state     DowntimeState
+                wantStart time.Time
+        }{
+                {"recurring moves to the next occurrence", testDowntime(scheduleStart, recurrence("days", 1)), at(1, 5, 12, 0), DowntimeRecurring, at(1, 6, 10, 0)},
+                {"recurring without further occurrence", testDowntime(scheduleStart, until(recurrence("days", 1), at(1, 3, 13, 0))), at(1, 3, 12, 0), DowntimeExpired, scheduleStart},
+                {"active starts now", testDowntime(scheduleStart, nil), at(1, 1, 10, 30), DowntimeActive, at(1, 1, 10, 30)},
+                {"scheduled is unchanged", testDowntime(scheduleStart, nil), at(1, 1, 9, 0), DowntimeScheduled, scheduleStart},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        end := test.downtime.GetEnd()
+                        if state := prepareDowntime(test.downtime, test.now); state != test.state {
+                                t.Errorf("state = %s, want %s", state, test.state)
+                        }
+                        if start := time.Unix(int64(test.downtime.GetStart()), 0); !start.Equal(test.wantStart) {
+                                t.Errorf("start = %s, want %s", start.UTC(), test.wantStart)
+                        }
+                        if test.state == DowntimeRecurring && test.downtime.GetEnd()-test.downtime.GetStart() != int(time.Hour/time.Second) {
+                                t.Errorf("end = %d, want the duration of one hour to be kept", test.downtime.GetEnd())
+                        }
+                        if test.state == DowntimeActive && test.downtime.GetEnd() != end {
+                                t.Errorf("end = %d, want the end %d to be kept", test.downtime.GetEnd(), end)
+                        }
+                })
+        }
+}
+
+func TestDowntimeName(t *testing.T) {
+        monitor := &datadog.Downtime{Scope: []string{"env:prod", "az:a"}}
+        monitor.SetMonitorId(123)
+        tests := []struct {
+                downtime *datadog.Downtime
+                want     string
+        }{
+                {monitor, "monitor 123 on az:a,env:prod"},
+                {&datadog.Downtime{MonitorTags: []string{"team:b", "team:a"}, Scope: []string{"*"}}, "monitors tagged team:a,team:b on *"},
+                {&datadog.Downtime{MonitorTags: []string{"*"}}, "all monitors on *"},
+                {&datadog.Downtime{}, "all monitors on *"},
+        }
+        for _, test := range tests {
+                if got := downtimeName(test.downtime); got != test.want {
+                        t.Errorf("downtimeName = %q, want %q", got, test.want)
+                }
+        }
+}
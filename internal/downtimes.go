@@ -1,29 +1,42 @@
 package internal
 
 import (
+        "context"
         "github.com/pkg/errors"
         "github.com/sirupsen/logrus"
         "github.com/zorkian/go-datadog-api"
-        "gopkg.in/yaml.v3"
-        "os"
+        "io"
+        "sort"
+        "strconv"
+        "strings"
+        "sync"
+        "time"
 )
 
 type downtimesClient struct {
-        ddClient *datadog.Client
-        log      *logrus.Entry
+        ddClient    *datadog.Client
+        log         *logrus.Entry
+        pushExpired bool
+
+        // remote holds the downtimes which are not canceled, it is loaded once per run and kept up
+        // to date by create, update and delete
+        remoteMutex sync.Mutex
+        remote      map[int]*datadog.Downtime
 }
 
-func NewDowntimesClient(ddClient *datadog.Client) DatadogConfigClient {
+// NewDowntimesClient returns a client for downtimes, expired downtimes are only pushed if
+// pushExpired is set
+func NewDowntimesClient(ddClient *datadog.Client, pushExpired bool) DatadogConfigClient {
         return &downtimesClient{
-                ddClient: ddClient,
-                log:      logrus.WithField("prefix", "downtimes"),
+                ddClient:    ddClient,
+                log:         logrus.WithField("prefix", "downtimes"),
+                pushExpired: pushExpired,
         }
 }
 
-func (d *downtimesClient) DecodeFile(file *os.File) ([]ConfigElement, error) {
+func (d *downtimesClient) DecodeFile(reader io.Reader) ([]ConfigElement, error) {
         var configElements []downtimeConfigElement
-        decoder := yaml.NewDecoder(file)
-        if err := decoder.Decode(&configElements); err != nil {
+        if err := decodeStrict(reader, &configElements); err != nil {
                 return nil, errors.WithMessage(err, "push: cannot read downtimes file")
         }
         result := make([]ConfigElement, len(configElements))
@@ -33,14 +46,18 @@ func (d *downtimesClient) DecodeFile(file *os.File) ([]ConfigElement, error) {
         return result, nil
 }
 
-func (d *downtimesClient) GetAll() (*ConfigElements, error) {
+func (d *downtimesClient) GetAll(ctx context.Context) (*ConfigElements, error) {
+        if err := ctx.Err(); err != nil {
+                return nil, err
+        }
         downtimes, err := d.ddClient.GetDowntimes()
         if err != nil {
                 return nil, errors.WithMessage(err, "get all downtimes")
         }
         result := make([]ConfigElement, len(downtimes))
-        for e, downtime := range downtimes {
-                result[e] = d.newConfigElement(downtime.Message, downtime.Id, &downtime)
+        for e := range downtimes {
+                downtime := &downtimes[e]
+                result[e] = d.newConfigElement(downtime.Id, downtime)
         }
         return &ConfigElements{
                 Elements: result,
@@ -52,21 +69,146 @@ func (d *downtimesClient) ConfigClientName() string {
         return "downtimes"
 }
 
-func (d *downtimesClient) GetById(id int) (interface{}, error) {
-        return d.ddClient.GetDowntime(id)
+func (d *downtimesClient) GetById(ctx context.Context, id int) (ConfigElement, error) {
+        if err := ctx.Err(); err != nil {
+                return nil, err
+        }
+        downtime, err := d.ddClient.GetDowntime(id)
+        if err != nil {
+                return nil, err
+        }
+        return d.newConfigElement(downtime.Id, downtime), nil
+}
+
+// GetByName returns the downtimes with the given name, datadog has no lookup by name, so all
+// downtimes are listed once per run
+func (d *downtimesClient) GetByName(ctx context.Context, name string) ([]ConfigElement, error) {
+        return d.findRemote(ctx, func(downtime *datadog.Downtime) bool {
+                return d.newConfigElement(downtime.Id, downtime).GetName() == name
+        })
 }
 
-// there is no function to load a downtime by name
-func (d *downtimesClient) GetByName(name string) ([]interface{}, error) {
-        return []interface{}{}, nil
+// FindDuplicates returns the downtimes with the same scope, message and monitor as the element,
+// owner and external id markers in the message are ignored
+func (d *downtimesClient) FindDuplicates(ctx context.Context, e ConfigElement) ([]ConfigElement, error) {
+        key := downtimeKey((e.GetDelegate()).(*datadog.Downtime))
+        return d.findRemote(ctx, func(downtime *datadog.Downtime) bool {
+                return downtimeKey(downtime) == key
+        })
 }
 
-func (d *downtimesClient) Create(e ConfigElement) (interface{}, error) {
-        return d.ddClient.CreateDowntime((e.GetDelegate()).(*datadog.Downtime))
+func (d *downtimesClient) findRemote(ctx context.Context, matches func(downtime *datadog.Downtime) bool) ([]ConfigElement, error) {
+        d.remoteMutex.Lock()
+        defer d.remoteMutex.Unlock()
+        if d.remote == nil {
+                if err := ctx.Err(); err != nil {
+                        return nil, err
+                }
+                downtimes, err := d.ddClient.GetDowntimes()
+                if err != nil {
+                        return nil, errors.WithMessage(err, "get all downtimes")
+                }
+                d.remote = map[int]*datadog.Downtime{}
+                for e := range downtimes {
+                        if downtimes[e].Canceled == nil {
+                                d.remote[downtimes[e].GetId()] = &downtimes[e]
+                        }
+                }
+        }
+        var ids []int
+        for id, downtime := range d.remote {
+                if matches(downtime) {
+                        ids = append(ids, id)
+                }
+        }
+        sort.Ints(ids)
+        result := []ConfigElement{}
+        for _, id := range ids {
+                downtime := d.remote[id]
+                result = append(result, d.newConfigElement(downtime.Id, downtime))
+        }
+        return result, nil
 }
 
-func (d *downtimesClient) Delete(id int) error {
-        return d.ddClient.DeleteDowntime(id)
+// PreparePush skips expired downtimes, moves recurring downtimes to their next occurrence and
+// lets active downtimes start now, datadog rejects downtimes starting in the past
+func (d *downtimesClient) PreparePush(e ConfigElement) string {
+        downtime := (e.GetDelegate()).(*datadog.Downtime)
+        start := downtime.GetStart()
+        state := prepareDowntime(downtime, time.Now())
+        if state == DowntimeExpired && !d.pushExpired {
+                return "it is expired"
+        }
+        if downtime.GetStart() != start {
+                d.log.Infof("push: %s downtime %s starts at %s instead of %s now", state, e.GetName(),
+                        time.Unix(int64(downtime.GetStart()), 0).UTC().Format(time.RFC3339), time.Unix(int64(start), 0).UTC().Format(time.RFC3339))
+        }
+        return ""
+}
+
+// indexRemote replaces the downtime in the cache, nil removes it
+func (d *downtimesClient) indexRemote(id int, downtime *datadog.Downtime) {
+        d.remoteMutex.Lock()
+        defer d.remoteMutex.Unlock()
+        if d.remote == nil {
+                return
+        }
+        if downtime == nil {
+                delete(d.remote, id)
+                return
+        }
+        d.remote[id] = downtime
+}
+
+// downtimeKey identifies a downtime by its scope, message and monitor. Lines of the message
+// which consist of a single tag, like the owner and external id markers, are not part of it.
+func downtimeKey(downtime *datadog.Downtime) string {
+        scope := append([]string{}, downtime.Scope...)
+        sort.Strings(scope)
+        var message []string
+        for _, line := range strings.Split(downtime.GetMessage(), "\n") {
+                line = strings.TrimSpace(line)
+                if strings.Contains(line, ":") && !strings.ContainsAny(line, " \t") {
+                        continue
+                }
+                message = append(message, line)
+        }
+        return strings.Join(scope, ",") + "\x00" + strings.TrimSpace(strings.Join(message, "\n")) + "\x00" + strconv.Itoa(downtime.GetMonitorId())
+}
+
+func (d *downtimesClient) Create(ctx context.Context, e ConfigElement) (ConfigElement, error) {
+        if err := ctx.Err(); err != nil {
+                return nil, err
+        }
+        downtime, err := d.ddClient.CreateDowntime((e.GetDelegate()).(*datadog.Downtime))
+        if err != nil {
+                return nil, err
+        }
+        d.indexRemote(downtime.GetId(), downtime)
+        return d.newConfigElement(downtime.Id, downtime), nil
+}
+
+func (d *downtimesClient) Update(ctx context.Context, e ConfigElement) error {
+        if err := ctx.Err(); err != nil {
+                return err
+        }
+        downtime := (e.GetDelegate()).(*datadog.Downtime)
+        if err := d.ddClient.UpdateDowntime(downtime); err != nil {
+                return err
+        }
+        d.indexRemote(downtime.GetId(), downtime)
+        return nil
+}
+
+func (d *downtimesClient) Delete(ctx context.Context, id int) error {
+        if err := ctx.Err(); err != nil {
+                return err
+        }
+        if err := d.ddClient.DeleteDowntime(id); err != nil {
+                return err
+        }
+        d.indexRemote(id, nil)
+        return nil
 }
 
 func (d *downtimesClient) toInterfaceSlice(dashboards []datadog.Downtime) []interface{} {
@@ -91,15 +233,78 @@ func (d downtimeConfigElement) GetId() int {
         return d.Id
 }
 
+// GetTags returns the scope and the monitor tags of the downtime
+func (d downtimeConfigElement) GetTags() []string {
+        if d.Delegate == nil {
+                return nil
+        }
+        return append(append([]string{}, d.Delegate.Scope...), d.Delegate.MonitorTags...)
+}
+
+func (d downtimeConfigElement) GetReferences() []Reference {
+        if d.Delegate == nil || d.Delegate.MonitorId == nil {
+                return nil
+        }
+        return []Reference{{Type: "monitors", Id: d.Delegate.GetMonitorId(), Field: "monitor_id"}}
+}
+
+func (d downtimeConfigElement) RemapReferences(ids map[Reference]int) {
+        if d.Delegate == nil || d.Delegate.MonitorId == nil {
+                return
+        }
+        if newId, ok := ids[Reference{Type: "monitors", Id: d.Delegate.GetMonitorId(), Field: "monitor_id"}]; ok {
+                d.Delegate.SetMonitorId(newId)
+        }
+}
+
+func (d downtimeConfigElement) GetExternalId() string {
+        if d.Delegate == nil {
+                return ""
+        }
+        return textMarkerValue(d.Delegate.Message, externalIdPrefix)
+}
+
+func (d downtimeConfigElement) SetExternalId(externalId string) {
+        if d.Delegate != nil && d.GetExternalId() == "" {
+                d.Delegate.Message = textWithMarker(d.Delegate.Message, externalIdPrefix+externalId)
+        }
+}
+
+// Fingerprint is built from the scope, the monitor and the monitor tags of the downtime
+func (d downtimeConfigElement) Fingerprint() string {
+        if d.Delegate == nil || len(d.Delegate.Scope) == 0 {
+                return ""
+        }
+        scope := append([]string{}, d.Delegate.Scope...)
+        monitorTags := append([]string{}, d.Delegate.MonitorTags...)
+        sort.Strings(scope)
+        sort.Strings(monitorTags)
+        return fingerprint(strings.Join(scope, ","), strconv.Itoa(d.Delegate.GetMonitorId()), strings.Join(monitorTags, ","))
+}
+
+func (d downtimeConfigElement) SetRemoteId(id int) {
+        if d.Delegate != nil {
+                d.Delegate.SetId(id)
+        }
+}
+
+func (d downtimeConfigElement) IsOwnedBy(owner string) bool {
+        return d.Delegate != nil && textHasMarker(d.Delegate.Message, owner)
+}
+
+func (d downtimeConfigElement) SetOwner(owner string) {
+        if d.Delegate != nil {
+                d.Delegate.Message = textWithMarker(d.Delegate.Message, owner)
+        }
+}
+
 func (d downtimeConfigElement) GetDelegate() interface{} {
         return d.Delegate
 }
 
-func (d *downtimesClient) newConfigElement(name *string, id *int, value *datadog.Downtime) ConfigElement {
-        n := ""
-        if name != nil {
-                n = *name
-        }
+// newConfigElement names the downtime after its monitor and scope, the message is no name
+func (d *downtimesClient) newConfigElement(id *int, value *datadog.Downtime) ConfigElement {
+        n := downtimeName(value)
         i := -1
         if id != nil {
                 i = *id
@@ -0,0 +1,110 @@
+package internal
+
+import (
+        "github.com/pkg/errors"
+        "regexp"
+        "strings"
+)
+
+type FilterConfig struct {
+        // Types are the config client names to process, all if empty
+        Types []string
+        // Tags which all have to be present on an element
+        Tags       []string
+        NameRegex  string
+        IncludeIds []int
+        ExcludeIds []int
+}
+
+// Filter selects the config types and elements an action is applied to
+type Filter struct {
+        types      map[string]bool
+        tags       []string
+        nameRegex  *regexp.Regexp
+        includeIds map[int]bool
+        excludeIds map[int]bool
+}
+
+func NewFilter(config FilterConfig) (*Filter, error) {
+        filter := &Filter{
+                types:      map[string]bool{},
+                tags:       config.Tags,
+                includeIds: map[int]bool{},
+                excludeIds: map[int]bool{},
+        }
+        for _, types := range config.Types {
+                for _, t := range strings.Split(types, ",") {
+                        if t = strings.TrimSpace(t); t != "" {
+                                filter.types[t] = true
+                        }
+                }
+        }
+        if config.NameRegex != "" {
+                nameRegex, err := regexp.Compile(config.NameRegex)
+                if err != nil {
+                        return nil, errors.WithMessagef(err, "invalid name regex %s", config.NameRegex)
+                }
+                filter.nameRegex = nameRegex
+        }
+        for _, id := range config.IncludeIds {
+                filter.includeIds[id] = true
+        }
+        for _, id := range config.ExcludeIds {
+                filter.excludeIds[id] = true
+        }
+        return filter, nil
+}
+
+// SelectsElements returns true if the filter selects elements by id, name or tags, excluded ids
+// and types alone still select almost every element
+func (f *Filter) SelectsElements() bool {
+        return len(f.includeIds) > 0 || f.nameRegex != nil || len(f.tags) > 0
+}
+
+// SelectsIds returns true if the filter selects elements by id. Ids are only unique within a
+// config type, so they do not tell which element is meant without a single type.
+func (f *Filter) SelectsIds() bool {
+        return len(f.includeIds) > 0
+}
+
+// MatchesType returns true if elements of the given config client should be processed
+func (f *Filter) MatchesType(configType string) bool {
+        return len(f.types) == 0 || f.types[configType]
+}
+
+// Matches returns true if the element matches the name, id and tag criteria of the filter
+func (f *Filter) Matches(e ConfigElement) bool {
+        id := e.GetId()
+        if f.excludeIds[id] {
+                return false
+        }
+        if len(f.includeIds) > 0 && !f.includeIds[id] {
+                return false
+        }
+        if f.nameRegex != nil && !f.nameRegex.MatchString(e.GetName()) {
+                return false
+        }
+        if len(f.tags) > 0 {
+                elementTags := map[string]bool{}
+                for _, tag := range e.GetTags() {
+                        elementTags[tag] = true
+                }
+                for _, tag := range f.tags {
+                        if !elementTags[tag] {
+                                return false
+                        }
+                }
+        }
+        return true
+}
+
+// Apply returns only the matching elements
+func (f *Filter) Apply(elements []ConfigElement) []ConfigElement {
+        var result []ConfigElement
+        for _, e := range elements {
+                if f.Matches(e) {
+                        result = append(result, e)
+                }
+        }
+        return result
+}
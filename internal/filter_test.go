@@ -0,0 +1,73 @@
+package internal
+
+import (
+        "reflect"
+        "strings"
+        "testing"
+)
+
+func TestFilterMatches(t *testing.T) {
+        monitors, err := (&monitorsClient{}).DecodeFile(strings.NewReader(`
+- {name: cpu payments, id: 1, delegate: {tags: [team:payments, env:prod]}}
+- {name: cpu search, id: 2, delegate: {tags: [team:search, env:prod]}}
+- {name: disk payments, id: 3, delegate: {tags: [team:payments]}}
+`))
+        if err != nil {
+                t.Fatal(err)
+        }
+        tests := []struct {
+                name   string
+                config FilterConfig
+                want   []int
+        }{
+                {"everything", FilterConfig{}, []int{1, 2, 3}},
+                {"tag", FilterConfig{Tags: []string{"team:payments"}}, []int{1, 3}},
+                {"all tags", FilterConfig{Tags: []string{"team:payments", "env:prod"}}, []int{1}},
+                {"name regex", FilterConfig{NameRegex: "^cpu "}, []int{1, 2}},
+                {"included ids", FilterConfig{IncludeIds: []int{2, 3}}, []int{2, 3}},
+                {"excluded ids", FilterConfig{ExcludeIds: []int{2}}, []int{1, 3}},
+                {"excluded wins", FilterConfig{IncludeIds: []int{2, 3}, ExcludeIds: []int{3}}, []int{2}},
+                {"combined", FilterConfig{NameRegex: "payments", Tags: []string{"env:prod"}}, []int{1}},
+                {"none", FilterConfig{Tags: []string{"team:none"}}, nil},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        filter, err := NewFilter(test.config)
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        var ids []int
+                        for _, e := range filter.Apply(monitors) {
+                                ids = append(ids, e.GetId())
+                        }
+                        if !reflect.DeepEqual(ids, test.want) {
+                                t.Errorf("ids = %v, want %v", ids, test.want)
+                        }
+                })
+        }
+}
+
+func TestFilterTypes(t *testing.T) {
+        filter, err := NewFilter(FilterConfig{Types: []string{"monitors, dashboards", "", "downtimes"}})
+        if err != nil {
+                t.Fatal(err)
+        }
+        for configType, want := range map[string]bool{"monitors": true, "dashboards": true, "downtimes": true, "synthetics": false} {
+                if got := filter.MatchesType(configType); got != want {
+                        t.Errorf("MatchesType(%s) = %t, want %t", configType, got, want)
+                }
+        }
+        all, err := NewFilter(FilterConfig{})
+        if err != nil {
+                t.Fatal(err)
+        }
+        if !all.MatchesType("synthetics") {
+                t.Error("a filter without types does not match every type")
+        }
+}
+
+func TestNewFilterRejectsInvalidRegex(t *testing.T) {
+        if _, err := NewFilter(FilterConfig{NameRegex: "("}); err == nil {
+                t.Error("expected an error for an invalid name regex")
+        }
+}
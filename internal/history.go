@@ -0,0 +1,162 @@
+package internal
+
+import (
+        "fmt"
+        "github.com/pkg/errors"
+        "io"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+)
+
+// HistoryEntry is a change of an element between two snapshots. The change happened remotely
+// after the content of the previous snapshot was pulled and before the content of the snapshot
+// was pulled, After or Before are nil if that time is unknown.
+type HistoryEntry struct {
+        Snapshot Snapshot      `json:"snapshot" yaml:"snapshot"`
+        After    *time.Time    `json:"after,omitempty" yaml:"after,omitempty"`
+        Before   *time.Time    `json:"before,omitempty" yaml:"before,omitempty"`
+        Name     string        `json:"name" yaml:"name"`
+        Kind     DiffKind      `json:"kind" yaml:"kind"`
+        Changes  []FieldChange `json:"changes,omitempty" yaml:"changes,omitempty"`
+}
+
+// History walks the snapshots from the oldest to the current config file and returns the changes
+// of the element with the given id or name, followed by the interval in which every field of its
+// latest version got its value. Both are written to w as the output of the action. The element is
+// followed by id, so renames are part of its history. The config files are assumed to be written
+// by pulls with backups only, see Snapshot.
+func (b *backupService) History(w io.Writer, configType, idOrName string) ([]HistoryEntry, error) {
+        if b.client(configType) == nil {
+                return nil, errors.Errorf("history: unknown config type %s", configType)
+        }
+        snapshots, err := b.Snapshots()
+        if err != nil {
+                return nil, errors.WithMessage(err, "history")
+        }
+        snapshots = append(snapshots, currentSnapshot(configType, snapshots))
+
+        snapshotElements := make([][]ConfigElement, len(snapshots))
+        versions := make([]ConfigElement, len(snapshots))
+        for i := range snapshots {
+                if !containsString(snapshots[i].Types, configType) {
+                        continue
+                }
+                if snapshotElements[i], err = b.SnapshotElements(snapshots[i].Id, configType); err != nil {
+                        return nil, errors.WithMessage(err, "history")
+                }
+                versions[i] = findElement(snapshotElements[i], idOrName)
+        }
+        // a name may have belonged to other elements over time, the newest one with an id wins
+        for i := len(versions) - 1; i >= 0; i-- {
+                if versions[i] == nil || versions[i].GetId() <= 0 {
+                        continue
+                }
+                key := strconv.Itoa(versions[i].GetId())
+                for j := range versions {
+                        versions[j] = findElement(snapshotElements[j], key)
+                }
+                break
+        }
+
+        var output strings.Builder
+        var history []HistoryEntry
+        var previous ConfigElement
+        // pulled is when the content of the previous snapshot of the type was pulled
+        var pulled *time.Time
+        since := map[string]HistoryEntry{}
+        for i, version := range versions {
+                if !containsString(snapshots[i].Types, configType) {
+                        continue
+                }
+                entry := HistoryEntry{Snapshot: snapshots[i], After: pulled, Before: snapshots[i].Pulled}
+                pulled = snapshots[i].Pulled
+                switch {
+                case previous == nil && version == nil:
+                        continue
+                case previous == nil:
+                        entry.Name, entry.Kind = version.GetName(), DiffCreated
+                        since = map[string]HistoryEntry{}
+                        for path := range FlattenElement(version) {
+                                since[path] = entry
+                        }
+                case version == nil:
+                        entry.Name, entry.Kind = previous.GetName(), DiffDeleted
+                default:
+                        changes := DiffFields(configType, previous, version)
+                        if len(changes) == 0 {
+                                continue
+                        }
+                        entry.Name, entry.Kind, entry.Changes = version.GetName(), DiffChanged, changes
+                        for _, change := range changes {
+                                since[change.Path] = entry
+                        }
+                }
+                previous = version
+                history = append(history, entry)
+                fmt.Fprintf(&output, "%s %s %s (snapshot %s)\n", entry.Name, entry.Kind, entry.Interval(), entry.Snapshot.Id)
+                for _, change := range entry.Changes {
+                        fmt.Fprintf(&output, "  %s: %q -> %q\n", change.Path, change.Local, change.Remote)
+                }
+        }
+        if len(history) == 0 {
+                return nil, errors.Errorf("history: no %s %s in the config file or the backups", configType, idOrName)
+        }
+
+        if previous != nil {
+                fields := FlattenElement(previous)
+                var paths []string
+                for path := range fields {
+                        if !isVolatileField(configType, path) {
+                                paths = append(paths, path)
+                        }
+                }
+                sort.Strings(paths)
+                fmt.Fprintf(&output, "\nblame of %s:\n", previous.GetName())
+                for _, path := range paths {
+                        fmt.Fprintf(&output, "  %s: %q set %s\n", path, fields[path], since[path].Interval())
+                }
+        }
+        if _, err := io.WriteString(w, output.String()); err != nil {
+                return history, errors.WithMessage(err, "history")
+        }
+        return history, nil
+}
+
+// Interval tells when the change happened, e.g. between 2020-01-01T10:00:00Z and
+// 2020-01-02T10:00:00Z
+func (e HistoryEntry) Interval() string {
+        switch {
+        case e.After != nil && e.Before != nil:
+                return "between " + e.After.Format(time.RFC3339) + " and " + e.Before.Format(time.RFC3339)
+        case e.Before != nil:
+                return "before " + e.Before.Format(time.RFC3339)
+        case e.After != nil:
+                return "after " + e.After.Format(time.RFC3339)
+        }
+        return "at an unknown time"
+}
+
+// currentSnapshot is the config file of a type as a snapshot, it was written by the pull which
+// backed up the latest snapshot of the type
+func currentSnapshot(configType string, snapshots []Snapshot) Snapshot {
+        current := Snapshot{Id: CurrentSnapshot, Types: []string{configType}}
+        for i := len(snapshots) - 1; i >= 0; i-- {
+                if containsString(snapshots[i].Types, configType) {
+                        pulled := snapshots[i].Time
+                        current.Pulled = &pulled
+                        break
+                }
+        }
+        return current
+}
+
+func containsString(values []string, value string) bool {
+        for _, v := range values {
+                if v == value {
+                        return true
+                }
+        }
+        return false
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+        "io/ioutil"
+        "reflect"
+        "strings"
+        "testing"
+)
+
+func TestHistory(t *testing.T) {
+        monitor := func(name, query string) string {
+                return monitorsFile("{name: " + name + ", id: 1, delegate: {name: " + name + ", type: metric alert, query: " + query + "}}")
+        }
+        service := snapshotService(t, map[string]string{
+                "100_monitors.yaml":   "[]",
+                "200_monitors.yaml":   monitor("a", "q1"),
+                "300_monitors.yaml":   monitor("a", "q2"),
+                "400_monitors.yaml":   monitor("a", "q2"),
+                "400_dashboards.yaml": "[]",
+        }, map[string]string{
+                "monitors.yaml": monitor("b", "q2"),
+        })
+        var output strings.Builder
+        history, err := service.History(&output, "monitors", "a")
+        if err != nil {
+                t.Fatal(err)
+        }
+        var timeline []string
+        for _, entry := range history {
+                timeline = append(timeline, entry.Snapshot.Id+" "+entry.Name+" "+string(entry.Kind)+" "+entry.Interval())
+        }
+        want := []string{
+                // the content of the oldest snapshot has an unknown time
+                "200 a " + string(DiffCreated) + " before 1970-01-01T00:01:40Z",
+                "300 a " + string(DiffChanged) + " between 1970-01-01T00:01:40Z and 1970-01-01T00:03:20Z",
+                // the config file was written by the pull at 400
+                "current b " + string(DiffChanged) + " between 1970-01-01T00:05:00Z and 1970-01-01T00:06:40Z",
+        }
+        if !reflect.DeepEqual(timeline, want) {
+                t.Errorf("timeline =\n%s\nwant\n%s", strings.Join(timeline, "\n"), strings.Join(want, "\n"))
+        }
+
+        for _, want := range []string{
+                "a created before 1970-01-01T00:01:40Z (snapshot 200)\n",
+                "a changed between 1970-01-01T00:01:40Z and 1970-01-01T00:03:20Z (snapshot 300)\n  query: \"q1\" -> \"q2\"\n",
+                "\nblame of b:\n",
+                "  name: \"b\" set between 1970-01-01T00:05:00Z and 1970-01-01T00:06:40Z\n",
+                "  query: \"q2\" set between 1970-01-01T00:01:40Z and 1970-01-01T00:03:20Z\n",
+                "  type: \"metric alert\" set before 1970-01-01T00:01:40Z\n",
+        } {
+                if !strings.Contains(output.String(), want) {
+                        t.Errorf("output\n%s\ndoes not contain %q", output.String(), want)
+                }
+        }
+}
+
+func TestHistoryFollowsTheId(t *testing.T) {
+        service := snapshotService(t, map[string]string{
+                "100_monitors.yaml": monitorsFile(
+                        "{name: a, id: 1, delegate: {name: a, type: metric alert, query: q}}",
+                        "{name: b, id: 2, delegate: {name: b, type: metric alert, query: q}}",
+                ),
+                "200_monitors.yaml": monitorsFile("{name: b, id: 2, delegate: {name: b, type: metric alert, query: q}}"),
+        }, map[string]string{
+                "monitors.yaml": monitorsFile("{name: a, id: 3, delegate: {name: a, type: metric alert, query: q}}"),
+        })
+        tests := []struct {
+                idOrName string
+                want     []string
+        }{
+                // the newest monitor named a wins
+                {"a", []string{"current " + string(DiffCreated)}},
+                {"1", []string{"100 " + string(DiffCreated), "200 " + string(DiffDeleted)}},
+                {"b", []string{"100 " + string(DiffCreated), "current " + string(DiffDeleted)}},
+        }
+        for _, test := range tests {
+                history, err := service.History(ioutil.Discard, "monitors", test.idOrName)
+                if err != nil {
+                        t.Fatal(err)
+                }
+                var got []string
+                for _, entry := range history {
+                        got = append(got, entry.Snapshot.Id+" "+string(entry.Kind))
+                }
+                if !reflect.DeepEqual(got, test.want) {
+                        t.Errorf("history of %s = %v, want %v", test.idOrName, got, test.want)
+                }
+        }
+        for _, args := range [][2]string{{"monitors", "x"}, {"alerts", "1"}} {
+                if _, err := service.History(ioutil.Discard, args[0], args[1]); err == nil {
+                        t.Errorf("history of %s %s: expected an error", args[0], args[1])
+                }
+        }
+}
@@ -0,0 +1,195 @@
+package internal
+
+import (
+        "context"
+        "crypto/rand"
+        "encoding/hex"
+        "fmt"
+        "github.com/pkg/errors"
+        "github.com/sirupsen/logrus"
+        "strings"
+)
+
+// The external id is a tag like external-id:3f9a0c2d1b7e4f68 written on creation, it stays the
+// same when an element is renamed or re-created. Monitors carry it as a tag, dashboards and
+// downtimes as a separate line of their description or message, like the owner marker.
+const externalIdPrefix = "external-id:"
+
+// MatchBy is the identity an element was matched with
+type MatchBy string
+
+const (
+        MatchByExternalId  MatchBy = "external id"
+        MatchByFingerprint MatchBy = "fingerprint"
+)
+
+// AmbiguousMatchError is returned if more than one remote element matches a local element
+type AmbiguousMatchError struct {
+        Type       string
+        Name       string
+        By         MatchBy
+        Candidates []ConfigElement
+}
+
+func (e *AmbiguousMatchError) Error() string {
+        var candidates []string
+        for _, c := range e.Candidates {
+                candidates = append(candidates, fmt.Sprintf("%d (%s)", c.GetId(), c.GetName()))
+        }
+        return fmt.Sprintf("%s %s matches %d remote elements by %s: %s",
+                e.Type, e.Name, len(e.Candidates), e.By, strings.Join(candidates, ", "))
+}
+
+// IdentityMatcher finds the remote element of a local element whose id is unknown remotely, by
+// its external id or else by the fingerprint of its content. Every remote element is matched once.
+type IdentityMatcher struct {
+        configType    string
+        loaded        bool
+        byExternalId  map[string][]ConfigElement
+        byFingerprint map[string][]ConfigElement
+        claimed       map[int]bool
+}
+
+func NewIdentityMatcher(configType string) *IdentityMatcher {
+        return &IdentityMatcher{
+                configType:    configType,
+                byExternalId:  map[string][]ConfigElement{},
+                byFingerprint: map[string][]ConfigElement{},
+                claimed:       map[int]bool{},
+        }
+}
+
+// Load indexes the remote elements
+func (m *IdentityMatcher) Load(remote []ConfigElement) {
+        for _, e := range remote {
+                if externalId := e.GetExternalId(); externalId != "" {
+                        m.byExternalId[externalId] = append(m.byExternalId[externalId], e)
+                }
+                if fingerprint := e.Fingerprint(); fingerprint != "" {
+                        m.byFingerprint[fingerprint] = append(m.byFingerprint[fingerprint], e)
+                }
+        }
+        m.loaded = true
+}
+
+func (m *IdentityMatcher) Loaded() bool {
+        return m.loaded
+}
+
+// Claim marks a remote element as matched, e.g. because a local element has its id
+func (m *IdentityMatcher) Claim(id int) {
+        m.claimed[id] = true
+}
+
+// Match returns the remote element of the local element, or nil if there is none. A local
+// element with an external id is only matched by it. Fingerprint matches are narrowed down by
+// name, remote elements with another external id never match.
+func (m *IdentityMatcher) Match(local ConfigElement) (ConfigElement, MatchBy, error) {
+        if externalId := local.GetExternalId(); externalId != "" {
+                candidates := m.unclaimed(m.byExternalId[externalId])
+                if len(candidates) > 1 {
+                        return nil, MatchByExternalId, &AmbiguousMatchError{Type: m.configType, Name: local.GetName(), By: MatchByExternalId, Candidates: candidates}
+                }
+                if len(candidates) == 1 {
+                        m.Claim(candidates[0].GetId())
+                        return candidates[0], MatchByExternalId, nil
+                }
+        }
+
+        fingerprint := local.Fingerprint()
+        if fingerprint == "" {
+                return nil, "", nil
+        }
+        var candidates []ConfigElement
+        for _, e := range m.unclaimed(m.byFingerprint[fingerprint]) {
+                if remoteId := e.GetExternalId(); remoteId == "" || remoteId == local.GetExternalId() {
+                        candidates = append(candidates, e)
+                }
+        }
+        if len(candidates) > 1 {
+                var sameName []ConfigElement
+                for _, e := range candidates {
+                        if e.GetName() == local.GetName() {
+                                sameName = append(sameName, e)
+                        }
+                }
+                if len(sameName) != 1 {
+                        return nil, MatchByFingerprint, &AmbiguousMatchError{Type: m.configType, Name: local.GetName(), By: MatchByFingerprint, Candidates: candidates}
+                }
+                candidates = sameName
+        }
+        if len(candidates) == 1 {
+                m.Claim(candidates[0].GetId())
+                return candidates[0], MatchByFingerprint, nil
+        }
+        return nil, "", nil
+}
+
+// Release makes a claimed remote element available for matching again, e.g. because the match
+// was not used
+func (m *IdentityMatcher) Release(id int) {
+        delete(m.claimed, id)
+}
+
+func (m *IdentityMatcher) unclaimed(elements []ConfigElement) []ConfigElement {
+        var result []ConfigElement
+        for _, e := range elements {
+                if !m.claimed[e.GetId()] {
+                        result = append(result, e)
+                }
+        }
+        return result
+}
+
+// loadMatcher loads the remote elements of the owner into the matcher, elements which could
+// not be loaded are only logged, they cannot be matched
+func (b *backupService) loadMatcher(ctx context.Context, client DatadogConfigClient, matcher *IdentityMatcher) error {
+        remote, err := client.GetAll(ctx)
+        var elementErrors ElementErrors
+        if errors.As(err, &elementErrors) {
+                b.log.WithFields(logrus.Fields{"client": client.ConfigClientName(), "action": "push"}).Warnf("push: %d remote element(s) could not be loaded and cannot be matched", len(elementErrors))
+        } else if err != nil {
+                return err
+        }
+        var owned []ConfigElement
+        for _, e := range remote.Elements {
+                if b.owned(e) {
+                        owned = append(owned, e)
+                }
+        }
+        matcher.Load(owned)
+        return nil
+}
+
+func newExternalId() (string, error) {
+        id := make([]byte, 8)
+        if _, err := rand.Read(id); err != nil {
+                return "", errors.WithMessage(err, "cannot generate external id")
+        }
+        return hex.EncodeToString(id), nil
+}
+
+func tagValue(tags []string, prefix string) string {
+        for _, tag := range tags {
+                if strings.HasPrefix(tag, prefix) {
+                        return strings.TrimPrefix(tag, prefix)
+                }
+        }
+        return ""
+}
+
+func textMarkerValue(text *string, prefix string) string {
+        if text == nil {
+                return ""
+        }
+        for _, line := range strings.Split(*text, "\n") {
+                if line = strings.TrimSpace(line); strings.HasPrefix(line, prefix) {
+                        return strings.TrimPrefix(line, prefix)
+                }
+        }
+        return ""
+}
+
+func fingerprint(parts ...string) string {
+        return sha256Hex([]byte(strings.Join(parts, "\x00")))
+}
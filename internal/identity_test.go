@@ -0,0 +1,211 @@
+package internal
+
+import (
+        "context"
+        "github.com/sirupsen/logrus"
+        logtest "github.com/sirupsen/logrus/hooks/test"
+        "github.com/zorkian/go-datadog-api"
+        "net/http"
+        "net/http/httptest"
+        "reflect"
+        "strings"
+        "testing"
+)
+
+func TestIdentityMatcherMatch(t *testing.T) {
+        remote := namedMonitors(t,
+                "{name: cpu, type: metric alert, query: cpu, tags: [external-id:a]}",
+                "{name: disk, type: metric alert, query: disk}",
+                "{name: mem, type: metric alert, query: mem}",
+                "{name: mem copy, type: metric alert, query: mem}",
+                "{name: net, type: metric alert, query: net, tags: [external-id:b]}",
+                "{name: io, type: metric alert, query: io, tags: [external-id:c]}",
+                "{name: io copy, type: metric alert, query: io, tags: [external-id:c]}",
+        )
+        tests := []struct {
+                name      string
+                local     string
+                want      int
+                by        MatchBy
+                ambiguous bool
+        }{
+                {name: "external id wins over another content", local: "{name: renamed, type: metric alert, query: other, tags: [external-id:a]}", want: 1, by: MatchByExternalId},
+                {name: "fingerprint", local: "{name: disk, type: metric alert, query: disk}", want: 2, by: MatchByFingerprint},
+                {name: "fingerprint with another name", local: "{name: new disk, type: metric alert, query: disk}", want: 2, by: MatchByFingerprint},
+                {name: "same query narrowed by name", local: "{name: mem copy, type: metric alert, query: mem}", want: 4, by: MatchByFingerprint},
+                {name: "same query with another name", local: "{name: mem new, type: metric alert, query: mem}", by: MatchByFingerprint, ambiguous: true},
+                {name: "another type", local: "{name: disk, type: query alert, query: disk}"},
+                {name: "remote with another external id", local: "{name: net, type: metric alert, query: net, tags: [external-id:x]}"},
+                {name: "remote with external id by fingerprint", local: "{name: net, type: metric alert, query: net}"},
+                {name: "ambiguous external id", local: "{name: io, type: metric alert, query: io, tags: [external-id:c]}", by: MatchByExternalId, ambiguous: true},
+                {name: "no fingerprint", local: "{name: cpu, type: metric alert}"},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        matcher := NewIdentityMatcher("monitors")
+                        matcher.Load(remote)
+                        match, by, err := matcher.Match(namedMonitors(t, test.local)[0])
+                        if _, ok := err.(*AmbiguousMatchError); ok != test.ambiguous || (err != nil && !ok) {
+                                t.Fatalf("error = %v, want ambiguous: %t", err, test.ambiguous)
+                        }
+                        if by != test.by {
+                                t.Errorf("matched by %q, want %q", by, test.by)
+                        }
+                        id := 0
+                        if match != nil {
+                                id = match.GetId()
+                        }
+                        if id != test.want {
+                                t.Errorf("matched %d, want %d", id, test.want)
+                        }
+                })
+        }
+}
+
+func TestIdentityMatcherClaims(t *testing.T) {
+        remote := namedMonitors(t,
+                "{name: a, type: metric alert, query: q}",
+                "{name: b, type: metric alert, query: q}",
+        )
+        matcher := NewIdentityMatcher("monitors")
+        matcher.Load(remote)
+        matcher.Claim(1)
+        local := namedMonitors(t, "{name: c, type: metric alert, query: q}")[0]
+
+        match, _, err := matcher.Match(local)
+        if err != nil || match == nil || match.GetId() != 2 {
+                t.Fatalf("match = %v, %v, want the unclaimed 2", match, err)
+        }
+        if match, _, err := matcher.Match(local); err != nil || match != nil {
+                t.Errorf("match = %v, %v, want no second match of a claimed element", match, err)
+        }
+        matcher.Release(2)
+        if match, _, err := matcher.Match(local); err != nil || match == nil || match.GetId() != 2 {
+                t.Errorf("match = %v, %v, want the released 2", match, err)
+        }
+}
+
+// lookupServer answers the dashboard and downtime endpoints of the datadog client and counts the
+// requests per path
+func lookupServer(t *testing.T) (*datadog.Client, map[string]int) {
+        t.Helper()
+        requests := map[string]int{}
+        server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                requests[r.Method+" "+r.URL.Path]++
+                switch r.Method + " " + r.URL.Path {
+                case "GET /api/v1/dash":
+                        _, _ = w.Write([]byte(`{"dashes": [{"id": "1", "title": "overview"}, {"id": "2", "title": "cpu"}, {"id": "3", "title": "overview"}]}`))
+                case "GET /api/v1/dash/1", "GET /api/v1/dash/3":
+                        _, _ = w.Write([]byte(`{"dash": {"id": ` + strings.TrimPrefix(r.URL.Path, "/api/v1/dash/") + `, "title": "overview"}}`))
+                case "POST /api/v1/dash":
+                        _, _ = w.Write([]byte(`{"dash": {"id": 4, "title": "overview"}}`))
+                case "PUT /api/v1/dash/1", "DELETE /api/v1/dash/3":
+                case "GET /api/v1/downtime":
+                        _, _ = w.Write([]byte(`[
+                                {"id": 1, "scope": ["env:prod", "host:a"], "message": "deploy\nowner:team-a", "monitor_id": 7},
+                                {"id": 2, "scope": ["host:a", "env:prod"], "message": "deploy", "monitor_id": 7, "canceled": 1500000000},
+                                {"id": 3, "scope": ["env:prod", "host:a"], "message": "deploy", "monitor_id": 8}
+                        ]`))
+                default:
+                        t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+                        w.WriteHeader(http.StatusNotFound)
+                }
+        }))
+        t.Cleanup(server.Close)
+        ddClient := datadog.NewClient("api", "app")
+        ddClient.SetBaseUrl(server.URL)
+        return ddClient, requests
+}
+
+func TestDashboardsGetByName(t *testing.T) {
+        ddClient, requests := lookupServer(t)
+        dashboards := &dashboardsClient{ddClient: ddClient}
+        ids := func(title string) []int {
+                t.Helper()
+                elements, err := dashboards.GetByName(context.Background(), title)
+                if err != nil {
+                        t.Fatal(err)
+                }
+                ids := []int{}
+                for _, e := range elements {
+                        ids = append(ids, e.GetId())
+                }
+                return ids
+        }
+        if got := ids("overview"); !reflect.DeepEqual(got, []int{1, 3}) {
+                t.Errorf("overview = %v, want [1 3]", got)
+        }
+        if got := ids("memory"); len(got) != 0 {
+                t.Errorf("memory = %v, want none", got)
+        }
+
+        // created, renamed and deleted dashboards are kept in the index without listing them again
+        overview := &datadog.Dashboard{}
+        overview.SetTitle("overview")
+        if _, err := dashboards.Create(context.Background(), dashboardConfigElement{Name: "overview", Delegate: overview}); err != nil {
+                t.Fatal(err)
+        }
+        renamed := &datadog.Dashboard{}
+        renamed.SetId(1)
+        renamed.SetTitle("overview old")
+        if err := dashboards.Update(context.Background(), dashboardConfigElement{Name: "overview old", Id: 1, Delegate: renamed}); err != nil {
+                t.Fatal(err)
+        }
+        if err := dashboards.Delete(context.Background(), 3); err != nil {
+                t.Fatal(err)
+        }
+        if ids, err := dashboards.idsByTitle(context.Background(), "overview"); err != nil || !reflect.DeepEqual(ids, []int{4}) {
+                t.Errorf("overview = %v, %v, want the created dashboard 4", ids, err)
+        }
+        if ids, err := dashboards.idsByTitle(context.Background(), "overview old"); err != nil || !reflect.DeepEqual(ids, []int{1}) {
+                t.Errorf("overview old = %v, %v, want the renamed dashboard 1", ids, err)
+        }
+        if requests["GET /api/v1/dash"] != 1 {
+                t.Errorf("dashboards were listed %d times, want once per run", requests["GET /api/v1/dash"])
+        }
+}
+
+func TestDowntimesFindDuplicates(t *testing.T) {
+        ddClient, requests := lookupServer(t)
+        downtimes := &downtimesClient{ddClient: ddClient}
+        find := func(downtime *datadog.Downtime) []int {
+                t.Helper()
+                elements, err := downtimes.FindDuplicates(context.Background(), downtimeConfigElement{Delegate: downtime})
+                if err != nil {
+                        t.Fatal(err)
+                }
+                ids := []int{}
+                for _, e := range elements {
+                        ids = append(ids, e.GetId())
+                }
+                return ids
+        }
+        local := &datadog.Downtime{Scope: []string{"host:a", "env:prod"}}
+        local.SetMessage("deploy\nexternal-id:x")
+        local.SetMonitorId(7)
+        // the scope order and the markers in the message do not matter, the canceled downtime 2 is
+        // no duplicate
+        if got := find(local); !reflect.DeepEqual(got, []int{1}) {
+                t.Errorf("duplicates = %v, want [1]", got)
+        }
+        local.SetMonitorId(9)
+        if got := find(local); len(got) != 0 {
+                t.Errorf("duplicates of another monitor = %v, want none", got)
+        }
+        if requests["GET /api/v1/downtime"] != 1 {
+                t.Errorf("downtimes were listed %d times, want once per run", requests["GET /api/v1/downtime"])
+        }
+}
+
+func TestWarnDuplicateNames(t *testing.T) {
+        logger, hook := logtest.NewNullLogger()
+        warnDuplicateNames(logrus.NewEntry(logger), namedMonitors(t,
+                "{name: cpu, type: metric alert, query: a}",
+                "{name: disk, type: metric alert, query: b}",
+                "{name: cpu, type: metric alert, query: c}",
+                "{name: cpu, type: metric alert, query: d}",
+        ))
+        if len(hook.Entries) != 1 || hook.LastEntry().Message != `push: 3 elements in the config file are named "cpu"` {
+                t.Errorf("warnings = %v, want one of the name cpu", hook.Entries)
+        }
+}
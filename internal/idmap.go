@@ -0,0 +1,146 @@
+package internal
+
+import (
+        "github.com/pkg/errors"
+        "gopkg.in/yaml.v3"
+        "io/ioutil"
+        "sort"
+        "strconv"
+        "sync"
+)
+
+// IdMap maps the ids of elements in the config files to the ids datadog assigned when they were
+// re-created by push, by config type. References of later elements are rewritten with it.
+type IdMap struct {
+        mutex sync.Mutex
+        ids   map[string]map[int]int
+}
+
+func NewIdMap() *IdMap {
+        return &IdMap{ids: map[string]map[int]int{}}
+}
+
+// LoadIdMap reads an id map written by a previous push from the storage, a missing file is an
+// empty map
+func LoadIdMap(storage Storage, name string) (*IdMap, error) {
+        idMap := NewIdMap()
+        exists, err := storage.Exists(name)
+        if err != nil {
+                return nil, errors.WithMessage(err, "cannot read id map")
+        }
+        if !exists {
+                return idMap, nil
+        }
+        reader, err := storage.Read(name)
+        if err != nil {
+                return nil, errors.WithMessage(err, "cannot read id map")
+        }
+        defer closeQuietly(reader)
+        content, err := ioutil.ReadAll(reader)
+        if err != nil {
+                return nil, errors.WithMessagef(err, "cannot read id map %s", storage.Location(name))
+        }
+        if err := yaml.Unmarshal(content, &idMap.ids); err != nil {
+                return nil, errors.WithMessagef(err, "cannot decode id map %s", storage.Location(name))
+        }
+        if idMap.ids == nil {
+                idMap.ids = map[string]map[int]int{}
+        }
+        return idMap, nil
+}
+
+// Add records that the element with the old id was re-created with the new id. Entries pointing
+// to the old id from previous pushes are moved to the new id as well.
+func (m *IdMap) Add(configType string, oldId, newId int) {
+        m.mutex.Lock()
+        defer m.mutex.Unlock()
+        ids, ok := m.ids[configType]
+        if !ok {
+                ids = map[int]int{}
+                m.ids[configType] = ids
+        }
+        for from, to := range ids {
+                if to == oldId {
+                        ids[from] = newId
+                }
+        }
+        ids[oldId] = newId
+}
+
+// Lookup returns the new id of an element, or the id itself if it was not re-created
+func (m *IdMap) Lookup(configType string, id int) (int, bool) {
+        m.mutex.Lock()
+        defer m.mutex.Unlock()
+        newId, ok := m.ids[configType][id]
+        if !ok {
+                return id, false
+        }
+        return newId, true
+}
+
+func (m *IdMap) Len() int {
+        m.mutex.Lock()
+        defer m.mutex.Unlock()
+        count := 0
+        for _, ids := range m.ids {
+                count += len(ids)
+        }
+        return count
+}
+
+// Write writes the map as yaml to the storage, sorted by config type and old id
+func (m *IdMap) Write(storage Storage, name string) error {
+        m.mutex.Lock()
+        defer m.mutex.Unlock()
+        var document yaml.Node
+        document.Kind = yaml.MappingNode
+        var configTypes []string
+        for configType := range m.ids {
+                configTypes = append(configTypes, configType)
+        }
+        sort.Strings(configTypes)
+        for _, configType := range configTypes {
+                var oldIds []int
+                for oldId := range m.ids[configType] {
+                        oldIds = append(oldIds, oldId)
+                }
+                sort.Ints(oldIds)
+                ids := &yaml.Node{Kind: yaml.MappingNode}
+                for _, oldId := range oldIds {
+                        ids.Content = append(ids.Content,
+                                &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(oldId)},
+                                &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(m.ids[configType][oldId])})
+                }
+                document.Content = append(document.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: configType}, ids)
+        }
+        content, err := yaml.Marshal(&document)
+        if err != nil {
+                return errors.WithMessage(err, "cannot encode id map")
+        }
+        writer, err := storage.Write(name)
+        if err != nil {
+                return errors.WithMessage(err, "cannot write id map")
+        }
+        if _, err := writer.Write(content); err != nil {
+                closeQuietly(writer)
+                return errors.WithMessagef(err, "cannot write id map %s", storage.Location(name))
+        }
+        return errors.WithMessagef(writer.Close(), "cannot write id map %s", storage.Location(name))
+}
+
+// remapReferences rewrites the references of an element to re-created elements, it returns the
+// references which were changed
+func remapReferences(e ConfigElement, idMap *IdMap) []Reference {
+        var remapped []Reference
+        ids := map[Reference]int{}
+        for _, ref := range e.GetReferences() {
+                if newId, ok := idMap.Lookup(ref.Type, ref.Id); ok && newId != ref.Id {
+                        ids[ref] = newId
+                        remapped = append(remapped, ref)
+                }
+        }
+        if len(ids) > 0 {
+                e.RemapReferences(ids)
+        }
+        return remapped
+}
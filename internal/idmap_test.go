@@ -0,0 +1,177 @@
+package internal
+
+import (
+        "context"
+        "io/ioutil"
+        "os"
+        "path/filepath"
+        "reflect"
+        "strings"
+        "testing"
+)
+
+func TestIdMapRoundTrip(t *testing.T) {
+        dir, err := ioutil.TempDir("", "idmap")
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer os.RemoveAll(dir)
+        storage, err := newLocalStorage(dir)
+        if err != nil {
+                t.Fatal(err)
+        }
+
+        idMap, err := LoadIdMap(storage, "ids.yaml")
+        if err != nil {
+                t.Fatal(err)
+        }
+        if idMap.Len() != 0 {
+                t.Fatalf("missing file loaded %d ids, want none", idMap.Len())
+        }
+        idMap.Add("monitors", 10, 20)
+        idMap.Add("monitors", 1, 2)
+        idMap.Add("downtimes", 5, 6)
+        if err := idMap.Write(storage, "ids.yaml"); err != nil {
+                t.Fatal(err)
+        }
+        content, err := ioutil.ReadFile(filepath.Join(dir, "ids.yaml"))
+        if err != nil {
+                t.Fatal(err)
+        }
+        if want := "downtimes:\n    5: 6\nmonitors:\n    1: 2\n    10: 20\n"; string(content) != want {
+                t.Errorf("written id map = %q, want %q", content, want)
+        }
+
+        // a second push re-creates monitor 20 again, the first entry follows it
+        loaded, err := LoadIdMap(storage, "ids.yaml")
+        if err != nil {
+                t.Fatal(err)
+        }
+        loaded.Add("monitors", 20, 30)
+        tests := []struct {
+                configType string
+                id         int
+                want       int
+                found      bool
+        }{
+                {"monitors", 10, 30, true},
+                {"monitors", 20, 30, true},
+                {"monitors", 1, 2, true},
+                {"monitors", 5, 5, false},
+                {"downtimes", 5, 6, true},
+                {"dashboards", 1, 1, false},
+        }
+        for _, test := range tests {
+                if got, found := loaded.Lookup(test.configType, test.id); got != test.want || found != test.found {
+                        t.Errorf("Lookup(%s, %d) = %d, %t, want %d, %t", test.configType, test.id, got, found, test.want, test.found)
+                }
+        }
+        if loaded.Len() != 4 {
+                t.Errorf("len = %d, want 4", loaded.Len())
+        }
+}
+
+func TestLoadIdMapRejectsInvalidFiles(t *testing.T) {
+        dir, err := ioutil.TempDir("", "idmap")
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer os.RemoveAll(dir)
+        if err := ioutil.WriteFile(filepath.Join(dir, "ids.yaml"), []byte("monitors: [1, 2]\n"), 0644); err != nil {
+                t.Fatal(err)
+        }
+        storage, err := newLocalStorage(dir)
+        if err != nil {
+                t.Fatal(err)
+        }
+        if _, err := LoadIdMap(storage, "ids.yaml"); err == nil || !strings.Contains(err.Error(), "cannot decode id map") {
+                t.Errorf("error = %v, want a decode error", err)
+        }
+}
+
+func TestRemapReferences(t *testing.T) {
+        idMap := NewIdMap()
+        idMap.Add("monitors", 1, 11)
+        idMap.Add("monitors", 3, 3)
+        monitors := testMonitors(t,
+                "{type: composite, query: 1 && 2 || 3}",
+                "{type: metric alert, query: 'avg(last_5m):avg:cpu{host:1} > 1'}",
+        )
+        downtimes, err := (&downtimesClient{}).DecodeFile(strings.NewReader("- id: 4\n  delegate:\n    monitorid: 1\n- id: 5\n  delegate:\n    monitorid: 2\n"))
+        if err != nil {
+                t.Fatal(err)
+        }
+        dashboards, err := (&dashboardsClient{}).DecodeFile(strings.NewReader("- name: d\n  id: 1\n  delegate:\n    title: d\n"))
+        if err != nil {
+                t.Fatal(err)
+        }
+        composite := Reference{Type: "monitors", Id: 1, Field: "query"}
+        downtime := Reference{Type: "monitors", Id: 1, Field: "monitor_id"}
+        tests := []struct {
+                name     string
+                element  ConfigElement
+                remapped []Reference
+                want     []Reference
+        }{
+                {"composite query", monitors[0], []Reference{composite},
+                        []Reference{{Type: "monitors", Id: 11, Field: "query"}, {Type: "monitors", Id: 2, Field: "query"}, {Type: "monitors", Id: 3, Field: "query"}}},
+                {"no composite", monitors[1], nil, nil},
+                {"downtime monitor", downtimes[0], []Reference{downtime}, []Reference{{Type: "monitors", Id: 11, Field: "monitor_id"}}},
+                {"downtime of a kept monitor", downtimes[1], nil, []Reference{{Type: "monitors", Id: 2, Field: "monitor_id"}}},
+                {"dashboard", dashboards[0], nil, nil},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        if remapped := remapReferences(test.element, idMap); !reflect.DeepEqual(remapped, test.remapped) {
+                                t.Errorf("remapped = %v, want %v", remapped, test.remapped)
+                        }
+                        if got := test.element.GetReferences(); !reflect.DeepEqual(got, test.want) {
+                                t.Errorf("references = %v, want %v", got, test.want)
+                        }
+                })
+        }
+}
+
+// pruneClient records the deletes of its remote elements
+type pruneClient struct {
+        *pullClient
+        deleted []int
+}
+
+func (c *pruneClient) Delete(ctx context.Context, id int) error {
+        c.deleted = append(c.deleted, id)
+        return nil
+}
+
+func TestPruneKeepsReCreatedElements(t *testing.T) {
+        client := &pruneClient{pullClient: &pullClient{monitorsClient: &monitorsClient{}, name: "monitors", remote: testMonitors(t,
+                "{query: a, tags: [team:a]}",
+                "{query: b, tags: [team:a]}",
+                "{query: c, tags: [team:a]}",
+                "{query: d}",
+        )}}
+        service := snapshotService(t, nil, map[string]string{
+                "monitors.yaml": monitorsFile("{name: m0, id: 1, delegate: {query: a}}", "{name: m1, id: 7, delegate: {query: b}}"),
+        })
+        service.configClients = []DatadogConfigClient{client}
+        service.filter = &Filter{}
+        service.idMap = NewIdMap()
+        service.report = NewReport()
+        service.stop = make(chan struct{})
+        service.owner = "team:a"
+        service.idMapFile = "ids.yaml"
+        service.assumeYes = true
+        // monitor 7 of the config file was re-created as monitor 2
+        idMap := NewIdMap()
+        idMap.Add("monitors", 7, 2)
+        if err := idMap.Write(service.backupStorage, "ids.yaml"); err != nil {
+                t.Fatal(err)
+        }
+
+        if err := service.Prune(context.Background()); err != nil {
+                t.Fatal(err)
+        }
+        if !reflect.DeepEqual(client.deleted, []int{3}) {
+                t.Errorf("deleted = %v, want only the owned monitor 3 missing in the config file", client.deleted)
+        }
+}
@@ -0,0 +1,359 @@
+package internal
+
+import (
+        "bytes"
+        "context"
+        "fmt"
+        "github.com/pkg/errors"
+        "github.com/sirupsen/logrus"
+        "gopkg.in/yaml.v3"
+        "path"
+        "sync"
+        "time"
+)
+
+type JournalAction string
+
+const (
+        JournalCreated JournalAction = "created"
+        JournalUpdated JournalAction = "updated"
+        JournalDeleted JournalAction = "deleted"
+)
+
+// JournalChange is a change push applied to a remote element
+type JournalChange struct {
+        Type   string        `yaml:"type"`
+        Action JournalAction `yaml:"action"`
+        Id     int           `yaml:"id"`
+        Name   string        `yaml:"name"`
+        Time   time.Time     `yaml:"time"`
+        // Reverted is set once a rollback reverted the change, a later rollback skips it
+        Reverted time.Time `yaml:"reverted,omitempty"`
+}
+
+// Journal records the changes of a push together with the remote state of the changed elements
+// before the push, so the push can be rolled back. The snapshot is taken before push starts for
+// the elements with an id and when they are matched for the other ones.
+type Journal struct {
+        mutex      sync.Mutex
+        Started    time.Time `yaml:"started"`
+        RolledBack time.Time `yaml:"rolled_back,omitempty"`
+        // Snapshot are the remote elements before push changed them, by config type
+        Snapshot map[string][]interface{} `yaml:"snapshot"`
+        Changes  []JournalChange          `yaml:"changes"`
+
+        snapshotIds map[string]map[int]bool
+}
+
+func NewJournal() *Journal {
+        return &Journal{
+                Started:     time.Now().UTC(),
+                Snapshot:    map[string][]interface{}{},
+                snapshotIds: map[string]map[int]bool{},
+        }
+}
+
+// journalFileSuffix is not matched by backupFileRegex, so journals in the backup dir are no snapshots
+const journalFileSuffix = "_push-journal.yaml"
+
+// rollbackTimeout bounds the rollback of a failed push, which does not share the time of the run
+const rollbackTimeout = 5 * time.Minute
+
+// TakeSnapshot keeps the remote state of an element, only the first state of an element is kept
+func (j *Journal) TakeSnapshot(configType string, e ConfigElement) error {
+        j.mutex.Lock()
+        defer j.mutex.Unlock()
+        if j.snapshotIds[configType][e.GetId()] {
+                return nil
+        }
+        // the element is kept as plain yaml, it is decoded by its client on rollback
+        content, err := yaml.Marshal(e)
+        var value interface{}
+        if err == nil {
+                err = yaml.Unmarshal(content, &value)
+        }
+        if err != nil {
+                return errors.WithMessagef(err, "journal: cannot encode %s %d", configType, e.GetId())
+        }
+        if j.snapshotIds[configType] == nil {
+                j.snapshotIds[configType] = map[int]bool{}
+        }
+        j.snapshotIds[configType][e.GetId()] = true
+        j.Snapshot[configType] = append(j.Snapshot[configType], value)
+        return nil
+}
+
+// Record adds an applied change
+func (j *Journal) Record(configType string, action JournalAction, id int, name string) {
+        j.mutex.Lock()
+        defer j.mutex.Unlock()
+        j.Changes = append(j.Changes, JournalChange{Type: configType, Action: action, Id: id, Name: name, Time: time.Now().UTC()})
+}
+
+// MarkReverted records that the change with the given index was reverted
+func (j *Journal) MarkReverted(i int) {
+        j.mutex.Lock()
+        defer j.mutex.Unlock()
+        j.Changes[i].Reverted = time.Now().UTC()
+}
+
+// Len returns the number of applied changes
+func (j *Journal) Len() int {
+        j.mutex.Lock()
+        defer j.mutex.Unlock()
+        return len(j.Changes)
+}
+
+// snapshotElements decodes the snapshot of a config type with its client, by id
+func (j *Journal) snapshotElements(client DatadogConfigClient) (map[int]ConfigElement, error) {
+        j.mutex.Lock()
+        values := j.Snapshot[client.ConfigClientName()]
+        j.mutex.Unlock()
+        elements := map[int]ConfigElement{}
+        if len(values) == 0 {
+                return elements, nil
+        }
+        content, err := yaml.Marshal(values)
+        if err != nil {
+                return nil, errors.WithMessage(err, "journal: cannot encode snapshot")
+        }
+        decoded, err := client.DecodeFile(bytes.NewReader(content))
+        if err != nil {
+                return nil, errors.WithMessage(err, "journal: cannot decode snapshot")
+        }
+        for _, e := range decoded {
+                elements[e.GetId()] = e
+        }
+        return elements, nil
+}
+
+// startJournal starts the journal of a push with the remote state of the elements with an id,
+// changes are only journaled if backups are enabled
+func (b *backupService) startJournal(ctx context.Context, elements map[string][]ConfigElement) error {
+        if b.dryRun || b.backupStorage == nil {
+                return nil
+        }
+        b.journal = NewJournal()
+        b.journalName = fmt.Sprintf("%d%s", b.journal.Started.Unix(), journalFileSuffix)
+        for _, c := range b.clients() {
+                configType := c.ConfigClientName()
+                for _, e := range elements[configType] {
+                        id := e.GetId()
+                        if mappedId, ok := b.idMap.Lookup(configType, id); ok {
+                                id = mappedId
+                        }
+                        if id == -1 {
+                                continue
+                        }
+                        if err := b.interrupted(ctx); err != nil {
+                                return err
+                        }
+                        remoteElement, err := c.GetById(ctx, id)
+                        if isNotFound(err) || (err == nil && remoteElement == nil) {
+                                // push creates it, there is nothing to restore
+                                continue
+                        }
+                        if err != nil {
+                                return errors.WithMessagef(err, "journal: cannot get %s %d to journal it", configType, id)
+                        }
+                        if err := b.journal.TakeSnapshot(configType, remoteElement); err != nil {
+                                return err
+                        }
+                }
+        }
+        b.log.Infof("push: journaling changes to %s", b.backupStorage.Location(b.journalName))
+        return b.writeJournal()
+}
+
+// journalChange records an applied change of push, the element is the remote one before the
+// change, nil for created elements
+func (b *backupService) journalChange(configType string, action JournalAction, id int, name string, before ConfigElement) {
+        if b.journal == nil {
+                return
+        }
+        if before != nil {
+                if err := b.journal.TakeSnapshot(configType, before); err != nil {
+                        b.log.WithError(err).Error("push: cannot journal change")
+                }
+        }
+        b.journal.Record(configType, action, id, name)
+        if err := b.writeJournal(); err != nil {
+                b.log.WithError(err).Error("push: cannot write journal")
+        }
+}
+
+func (b *backupService) writeJournal() error {
+        b.journal.mutex.Lock()
+        content, err := yaml.Marshal(b.journal)
+        b.journal.mutex.Unlock()
+        if err != nil {
+                return errors.WithMessage(err, "journal: cannot encode")
+        }
+        file, err := b.backupStorage.Write(b.journalName)
+        if err != nil {
+                return errors.WithMessage(err, "journal")
+        }
+        if _, err := file.Write(content); err != nil {
+                closeQuietly(file)
+                return errors.WithMessage(err, "journal")
+        }
+        return errors.WithMessage(file.Close(), "journal")
+}
+
+// Rollback reverts the changes of the push journal with the given name in the backup dir, the
+// path of the journal works as well
+func (b *backupService) Rollback(ctx context.Context, name string) error {
+        if b.backupStorage == nil {
+                return errors.New("rollback: backups are disabled, there are no journals")
+        }
+        b.journalName = path.Base(name)
+        reader, err := b.backupStorage.Read(b.journalName)
+        if err != nil {
+                return errors.WithMessage(err, "rollback")
+        }
+        defer closeQuietly(reader)
+        journal := NewJournal()
+        if err := yaml.NewDecoder(reader).Decode(journal); err != nil {
+                return errors.WithMessagef(err, "rollback: cannot decode %s", b.backupStorage.Location(b.journalName))
+        }
+        if !journal.RolledBack.IsZero() {
+                return errors.Errorf("rollback: %s was rolled back at %s already", b.journalName, journal.RolledBack.Format(time.RFC3339))
+        }
+        b.journal = journal
+        return b.rollback(ctx, b.interrupted)
+}
+
+// rollbackFailedPush reverts the changes of a failed push. The push may have failed because the
+// run timed out or was stopped, so the rollback gets a time of its own and only ends early if it
+// runs out of it.
+func (b *backupService) rollbackFailedPush() error {
+        ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+        defer cancel()
+        return b.rollback(ctx, func(ctx context.Context) error {
+                return ctx.Err()
+        })
+}
+
+// rollback reverts the changes of the journal, the last change first. Created elements are
+// deleted, updated elements get their old version and deleted elements are created again. It
+// stops before the next change once interrupted returns an error.
+func (b *backupService) rollback(ctx context.Context, interrupted func(context.Context) error) error {
+        if !b.dryRun {
+                if err := b.loadIdMap(); err != nil {
+                        return errors.WithMessage(err, "rollback")
+                }
+                defer b.writeIdMap()
+        }
+        snapshots := map[string]map[int]ConfigElement{}
+        failed, skipped := 0, 0
+        for i := len(b.journal.Changes) - 1; i >= 0; i-- {
+                change := b.journal.Changes[i]
+                logger := b.log.WithFields(logrus.Fields{"client": change.Type, "action": "rollback", "element_id": change.Id, "element_name": change.Name})
+                if !change.Reverted.IsZero() {
+                        logger.Debugf("rollback: %s element %d (%s) was reverted at %s already", change.Action, change.Id, change.Name, change.Reverted.Format(time.RFC3339))
+                        continue
+                }
+                if err := interrupted(ctx); err != nil {
+                        return errors.WithMessage(err, "rollback")
+                }
+                client := b.client(change.Type)
+                if client == nil {
+                        return errors.Errorf("rollback: unknown config type %s", change.Type)
+                }
+                if _, ok := snapshots[change.Type]; !ok {
+                        elements, err := b.journal.snapshotElements(client)
+                        if err != nil {
+                                return errors.WithMessage(err, "rollback")
+                        }
+                        snapshots[change.Type] = elements
+                }
+                reverted, err := b.revert(ctx, client, change, snapshots[change.Type][change.Id], logger)
+                if err != nil {
+                        logger.WithField("outcome", OutcomeFailed).WithError(err).Errorf("rollback: cannot revert %s element %d (%s)", change.Action, change.Id, change.Name)
+                        b.report.Fail(change.Type, change.Id, change.Name, err)
+                        failed++
+                        continue
+                }
+                if !reverted {
+                        skipped++
+                        continue
+                }
+                if b.dryRun {
+                        continue
+                }
+                // a rollback which fails later on must not revert this change a second time
+                b.journal.MarkReverted(i)
+                if err := b.writeJournal(); err != nil {
+                        return errors.WithMessage(err, "rollback")
+                }
+        }
+        if failed > 0 {
+                return errors.Errorf("rollback: %d change(s) could not be reverted", failed)
+        }
+        if b.dryRun || skipped > 0 {
+                return nil
+        }
+        b.journal.RolledBack = time.Now().UTC()
+        return errors.WithMessage(b.writeJournal(), "rollback")
+}
+
+// revert reverts one change, before is the remote element before the change. It returns false if
+// the change was not confirmed.
+func (b *backupService) revert(ctx context.Context, client DatadogConfigClient, change JournalChange, before ConfigElement, logger *logrus.Entry) (bool, error) {
+        if change.Action != JournalCreated && before == nil {
+                return false, errors.New("the journal has no snapshot of it")
+        }
+        switch change.Action {
+        case JournalCreated:
+                if !b.confirm(fmt.Sprintf("delete created %s %d (%s)", change.Type, change.Id, change.Name)) {
+                        logger.WithField("outcome", OutcomeSkipped).Warn("rollback: not confirmed, skipping")
+                        b.report.Count(change.Type, OutcomeSkipped)
+                        return false, nil
+                }
+                if !b.dryRun {
+                        if err := client.Delete(ctx, change.Id); err != nil {
+                                return false, err
+                        }
+                }
+                logger.WithField("outcome", OutcomeDeleted).Infof("rollback: deleted created element %d (%s)", change.Id, change.Name)
+                b.report.Count(change.Type, OutcomeDeleted)
+        case JournalUpdated:
+                current, err := client.GetById(ctx, change.Id)
+                if err != nil {
+                        return false, err
+                }
+                if !b.confirm(describeChange("restore", change.Type, before, DiffFields(change.Type, current, before))) {
+                        logger.WithField("outcome", OutcomeSkipped).Warn("rollback: not confirmed, skipping")
+                        b.report.Count(change.Type, OutcomeSkipped)
+                        return false, nil
+                }
+                if !b.dryRun {
+                        if err := client.Update(ctx, before); err != nil {
+                                return false, err
+                        }
+                }
+                logger.WithField("outcome", OutcomeUpdated).Infof("rollback: restored element %d (%s)", change.Id, before.GetName())
+                b.report.Count(change.Type, OutcomeUpdated)
+        case JournalDeleted:
+                if !b.confirm(describeChange("re-create deleted", change.Type, before, nil)) {
+                        logger.WithField("outcome", OutcomeSkipped).Warn("rollback: not confirmed, skipping")
+                        b.report.Count(change.Type, OutcomeSkipped)
+                        return false, nil
+                }
+                created := before
+                if !b.dryRun {
+                        var err error
+                        if created, err = client.Create(ctx, before); err != nil {
+                                return false, err
+                        }
+                        if created.GetId() != change.Id {
+                                b.idMap.Add(change.Type, change.Id, created.GetId())
+                        }
+                }
+                logger.WithField("outcome", OutcomeCreated).Infof("rollback: re-created deleted element %d (%s) as %d", change.Id, before.GetName(), created.GetId())
+                b.report.Count(change.Type, OutcomeCreated)
+        default:
+                return false, errors.Errorf("unknown action %s", change.Action)
+        }
+        return true, nil
+}
@@ -0,0 +1,186 @@
+package internal
+
+import (
+        "context"
+        "errors"
+        "reflect"
+        "testing"
+)
+
+// journalClient is a monitors client whose remote elements are m0, m1, ... with ids 1, 2, ...
+type journalClient struct {
+        *monitorsClient
+        remote  []ConfigElement
+        errs    map[int]error
+        lookups []int
+        // failDelete fails the deletes of these ids
+        failDelete map[int]bool
+        deleted    []int
+        updated    []int
+}
+
+func (c *journalClient) GetById(ctx context.Context, id int) (ConfigElement, error) {
+        c.lookups = append(c.lookups, id)
+        if err := c.errs[id]; err != nil {
+                return nil, err
+        }
+        for _, e := range c.remote {
+                if e.GetId() == id {
+                        return e, nil
+                }
+        }
+        return nil, &APIError{StatusCode: 404}
+}
+
+func (c *journalClient) Delete(ctx context.Context, id int) error {
+        if c.failDelete[id] {
+                return &APIError{StatusCode: 502}
+        }
+        c.deleted = append(c.deleted, id)
+        return nil
+}
+
+func (c *journalClient) Update(ctx context.Context, e ConfigElement) error {
+        c.updated = append(c.updated, e.GetId())
+        return nil
+}
+
+func journalService(t *testing.T, client *journalClient) *backupService {
+        service := snapshotService(t, nil, nil)
+        service.configClients = []DatadogConfigClient{client}
+        service.filter = &Filter{}
+        service.idMap = NewIdMap()
+        service.report = NewReport()
+        service.stop = make(chan struct{})
+        service.assumeYes = true
+        return service
+}
+
+func TestStartJournal(t *testing.T) {
+        tests := []struct {
+                name      string
+                ids       []int
+                errs      map[int]error
+                lookups   []int
+                snapshots int
+                wantErr   bool
+        }{
+                {name: "snapshots elements with an id", ids: []int{1, -1, 2}, lookups: []int{1, 2}, snapshots: 2},
+                {name: "skips elements missing remotely", ids: []int{1, 3}, lookups: []int{1, 3}, snapshots: 1},
+                {name: "skips elements datadog does not find", ids: []int{1}, errs: map[int]error{1: errors.New("API error 404 Not Found: {}")}, lookups: []int{1}},
+                {name: "looks up re-created elements by their new id", ids: []int{7}, lookups: []int{2}, snapshots: 1},
+                {name: "fails on server errors", ids: []int{1, 2}, errs: map[int]error{1: errors.New("Received HTTP status code 502")}, lookups: []int{1}, wantErr: true},
+                {name: "fails on rate limits", ids: []int{1}, errs: map[int]error{1: &APIError{StatusCode: 429}}, lookups: []int{1}, wantErr: true},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        client := &journalClient{monitorsClient: &monitorsClient{}, remote: testMonitors(t, "{query: a}", "{query: b}"), errs: test.errs}
+                        service := journalService(t, client)
+                        service.idMap.Add("monitors", 7, 2)
+                        var elements []ConfigElement
+                        for _, id := range test.ids {
+                                elements = append(elements, monitorConfigElement{Id: id})
+                        }
+                        err := service.startJournal(context.Background(), map[string][]ConfigElement{"monitors": elements})
+                        if (err != nil) != test.wantErr {
+                                t.Fatalf("error = %v, want an error: %t", err, test.wantErr)
+                        }
+                        if !reflect.DeepEqual(client.lookups, test.lookups) {
+                                t.Errorf("lookups = %v, want %v", client.lookups, test.lookups)
+                        }
+                        if test.wantErr {
+                                return
+                        }
+                        if snapshots := len(service.journal.Snapshot["monitors"]); snapshots != test.snapshots {
+                                t.Errorf("snapshots = %d, want %d", snapshots, test.snapshots)
+                        }
+                        if exists, err := service.backupStorage.Exists(service.journalName); err != nil || !exists {
+                                t.Errorf("journal %s was not written: %v", service.journalName, err)
+                        }
+                })
+        }
+}
+
+func TestRollbackFailedPushAfterTheRunWasStopped(t *testing.T) {
+        client := &journalClient{monitorsClient: &monitorsClient{}, remote: testMonitors(t, "{query: a}")}
+        service := journalService(t, client)
+        if err := service.startJournal(context.Background(), map[string][]ConfigElement{"monitors": client.remote}); err != nil {
+                t.Fatal(err)
+        }
+        service.journalChange("monitors", JournalUpdated, 1, "m0", client.remote[0])
+        service.journalChange("monitors", JournalCreated, 5, "new", nil)
+        service.Stop()
+
+        if err := service.rollbackFailedPush(); err != nil {
+                t.Fatal(err)
+        }
+        if !reflect.DeepEqual(client.deleted, []int{5}) || !reflect.DeepEqual(client.updated, []int{1}) {
+                t.Errorf("deleted %v and updated %v, want 5 deleted and 1 restored", client.deleted, client.updated)
+        }
+        if service.journal.RolledBack.IsZero() {
+                t.Error("journal was not marked as rolled back")
+        }
+}
+
+func TestRollbackStopsWhenTheServiceIsStopped(t *testing.T) {
+        client := &journalClient{monitorsClient: &monitorsClient{}}
+        service := journalService(t, client)
+        service.journal = NewJournal()
+        service.journal.Record("monitors", JournalCreated, 5, "new")
+        service.Stop()
+        if err := service.rollback(context.Background(), service.interrupted); !errors.Is(err, ErrStopped) {
+                t.Errorf("error = %v, want the stop", err)
+        }
+        if len(client.deleted) > 0 {
+                t.Errorf("deleted %v after the stop", client.deleted)
+        }
+}
+
+func TestRollbackResumesAfterAFailedRevert(t *testing.T) {
+        client := &journalClient{monitorsClient: &monitorsClient{}, failDelete: map[int]bool{5: true}}
+        service := journalService(t, client)
+        if err := service.startJournal(context.Background(), nil); err != nil {
+                t.Fatal(err)
+        }
+        service.journalChange("monitors", JournalCreated, 5, "first", nil)
+        service.journalChange("monitors", JournalCreated, 6, "second", nil)
+
+        if err := service.rollback(context.Background(), service.interrupted); err == nil {
+                t.Fatal("expected the failed delete of 5 to fail the rollback")
+        }
+        if !reflect.DeepEqual(client.deleted, []int{6}) {
+                t.Fatalf("deleted = %v, want 6", client.deleted)
+        }
+
+        // the second rollback reads the journal written by the first one
+        client.failDelete = nil
+        client.deleted = nil
+        if err := service.Rollback(context.Background(), service.journalName); err != nil {
+                t.Fatal(err)
+        }
+        if !reflect.DeepEqual(client.deleted, []int{5}) {
+                t.Errorf("deleted = %v, want only 5 which was not reverted before", client.deleted)
+        }
+        if service.journal.RolledBack.IsZero() {
+                t.Error("journal was not marked as rolled back")
+        }
+        if err := service.Rollback(context.Background(), service.journalName); err == nil {
+                t.Error("expected a rolled back journal to be refused")
+        }
+}
+
+func TestRollbackKeepsUnconfirmedChanges(t *testing.T) {
+        client := &journalClient{monitorsClient: &monitorsClient{}}
+        service := journalService(t, client)
+        service.assumeYes = false
+        if err := service.startJournal(context.Background(), nil); err != nil {
+                t.Fatal(err)
+        }
+        service.journalChange("monitors", JournalCreated, 5, "new", nil)
+        if err := service.rollback(context.Background(), service.interrupted); err != nil {
+                t.Fatal(err)
+        }
+        if len(client.deleted) > 0 || !service.journal.RolledBack.IsZero() || !service.journal.Changes[0].Reverted.IsZero() {
+                t.Errorf("deleted %v, rolled back at %s, want the unconfirmed change to stay", client.deleted, service.journal.RolledBack)
+        }
+}
@@ -0,0 +1,280 @@
+package internal
+
+import (
+        "fmt"
+        "github.com/pkg/errors"
+        "io"
+        "io/ioutil"
+        "net/http"
+        "os"
+        "path/filepath"
+        "sort"
+        "strconv"
+        "strings"
+        "sync"
+        "time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the api request duration histogram
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics collects the health of backups in the prometheus text format. A nil *Metrics
+// collects nothing, so it can be used unconditionally.
+type Metrics struct {
+        mutex      sync.Mutex
+        lastPull   map[string]time.Time
+        elements   map[string]int
+        requests   map[requestKey]*histogram
+        drift      map[driftKey]int
+        rateLimits func() RateLimitStats
+}
+
+type requestKey struct {
+        method, endpoint, status string
+}
+
+type driftKey struct {
+        configType string
+        kind       DiffKind
+}
+
+type histogram struct {
+        buckets []int
+        count   int
+        sum     float64
+}
+
+func NewMetrics() *Metrics {
+        return &Metrics{
+                lastPull: map[string]time.Time{},
+                elements: map[string]int{},
+                requests: map[requestKey]*histogram{},
+                drift:    map[driftKey]int{},
+        }
+}
+
+// PullSucceeded records a successful pull of the elements of a config type
+func (m *Metrics) PullSucceeded(configType string, elements int) {
+        if m == nil {
+                return
+        }
+        m.mutex.Lock()
+        defer m.mutex.Unlock()
+        m.lastPull[configType] = time.Now()
+        m.elements[configType] = elements
+}
+
+// DriftDetected counts drift found by serve
+func (m *Metrics) DriftDetected(diffs []ElementDiff) {
+        if m == nil {
+                return
+        }
+        m.mutex.Lock()
+        defer m.mutex.Unlock()
+        for _, diff := range diffs {
+                m.drift[driftKey{configType: diff.Type, kind: diff.Kind}]++
+        }
+}
+
+// RequestDone records a datadog api request, status is the status code or error
+func (m *Metrics) RequestDone(method, path, status string, duration time.Duration) {
+        if m == nil {
+                return
+        }
+        key := requestKey{method: method, endpoint: endpointPath(path), status: status}
+        m.mutex.Lock()
+        defer m.mutex.Unlock()
+        h, ok := m.requests[key]
+        if !ok {
+                h = &histogram{buckets: make([]int, len(latencyBuckets))}
+                m.requests[key] = h
+        }
+        seconds := duration.Seconds()
+        for i, bound := range latencyBuckets {
+                if seconds <= bound {
+                        h.buckets[i]++
+                }
+        }
+        h.count++
+        h.sum += seconds
+}
+
+// CollectRateLimits lets the metrics read the rate limit stats of the transport when written
+func (m *Metrics) CollectRateLimits(stats func() RateLimitStats) {
+        if m == nil {
+                return
+        }
+        m.mutex.Lock()
+        defer m.mutex.Unlock()
+        m.rateLimits = stats
+}
+
+// ServeHTTP serves the metrics for prometheus
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+        if err := m.WriteText(w); err != nil {
+                http.Error(w, err.Error(), http.StatusInternalServerError)
+        }
+}
+
+// WriteTextfile writes the metrics for the textfile collector of the node exporter, the file is
+// replaced atomically so the collector never reads a partial file
+func (m *Metrics) WriteTextfile(file string) error {
+        tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+        if err != nil {
+                return errors.WithMessagef(err, "cannot write metrics to %s", file)
+        }
+        if err = m.WriteText(tmp); err == nil {
+                err = tmp.Close()
+        } else {
+                closeQuietly(tmp)
+        }
+        if err == nil {
+                err = os.Chmod(tmp.Name(), 0644)
+        }
+        if err == nil {
+                err = os.Rename(tmp.Name(), file)
+        }
+        if err != nil {
+                _ = os.Remove(tmp.Name())
+                return errors.WithMessagef(err, "cannot write metrics to %s", file)
+        }
+        return nil
+}
+
+// WriteText writes the metrics in the prometheus text format, sorted by labels
+func (m *Metrics) WriteText(w io.Writer) error {
+        if m == nil {
+                return nil
+        }
+        m.mutex.Lock()
+        defer m.mutex.Unlock()
+        p := &metricsPrinter{w: w}
+
+        p.header("datadog_backup_last_successful_pull_timestamp_seconds", "gauge", "unix time of the last successful pull of a config type")
+        var configTypes []string
+        for configType := range m.lastPull {
+                configTypes = append(configTypes, configType)
+        }
+        sort.Strings(configTypes)
+        for _, configType := range configTypes {
+                p.sample("datadog_backup_last_successful_pull_timestamp_seconds", labels("type", configType), float64(m.lastPull[configType].Unix()))
+        }
+
+        p.header("datadog_backup_elements", "gauge", "number of elements of a config type at the last successful pull")
+        for _, configType := range configTypes {
+                p.sample("datadog_backup_elements", labels("type", configType), float64(m.elements[configType]))
+        }
+
+        var requestKeys []requestKey
+        for key := range m.requests {
+                requestKeys = append(requestKeys, key)
+        }
+        sort.Slice(requestKeys, func(i, j int) bool {
+                a, b := requestKeys[i], requestKeys[j]
+                if a.endpoint != b.endpoint {
+                        return a.endpoint < b.endpoint
+                }
+                if a.method != b.method {
+                        return a.method < b.method
+                }
+                return a.status < b.status
+        })
+        p.header("datadog_backup_api_requests_total", "counter", "datadog api requests by endpoint and status")
+        for _, key := range requestKeys {
+                p.sample("datadog_backup_api_requests_total", key.labels(), float64(m.requests[key].count))
+        }
+        p.header("datadog_backup_api_request_duration_seconds", "histogram", "duration of datadog api requests by endpoint and status")
+        for _, key := range requestKeys {
+                h := m.requests[key]
+                for i, bound := range latencyBuckets {
+                        p.sample("datadog_backup_api_request_duration_seconds_bucket", key.labels()+","+labels("le", strconv.FormatFloat(bound, 'g', -1, 64)), float64(h.buckets[i]))
+                }
+                p.sample("datadog_backup_api_request_duration_seconds_bucket", key.labels()+","+labels("le", "+Inf"), float64(h.count))
+                p.sample("datadog_backup_api_request_duration_seconds_sum", key.labels(), h.sum)
+                p.sample("datadog_backup_api_request_duration_seconds_count", key.labels(), float64(h.count))
+        }
+
+        if m.rateLimits != nil {
+                stats := m.rateLimits()
+                p.header("datadog_backup_rate_limit_waits_total", "counter", "requests which waited for a datadog rate limit")
+                p.sample("datadog_backup_rate_limit_waits_total", "", float64(stats.Throttled))
+                p.header("datadog_backup_rate_limit_wait_seconds_total", "counter", "time spent waiting for datadog rate limits and retries")
+                p.sample("datadog_backup_rate_limit_wait_seconds_total", "", stats.Waited.Seconds())
+                p.header("datadog_backup_api_retries_total", "counter", "retried datadog api requests")
+                p.sample("datadog_backup_api_retries_total", "", float64(stats.Retries))
+        }
+
+        var driftKeys []driftKey
+        for key := range m.drift {
+                driftKeys = append(driftKeys, key)
+        }
+        sort.Slice(driftKeys, func(i, j int) bool {
+                if driftKeys[i].configType != driftKeys[j].configType {
+                        return driftKeys[i].configType < driftKeys[j].configType
+                }
+                return driftKeys[i].kind < driftKeys[j].kind
+        })
+        p.header("datadog_backup_drift_total", "counter", "elements which changed, appeared or were deleted remotely, detected by serve")
+        for _, key := range driftKeys {
+                p.sample("datadog_backup_drift_total", labels("type", key.configType)+","+labels("kind", string(key.kind)), float64(m.drift[key]))
+        }
+        return p.err
+}
+
+func (k requestKey) labels() string {
+        return labels("endpoint", k.endpoint) + "," + labels("method", k.method) + "," + labels("status", k.status)
+}
+
+// metricsPrinter writes metric lines and keeps the first error
+type metricsPrinter struct {
+        w   io.Writer
+        err error
+}
+
+func (p *metricsPrinter) header(name, metricType, help string) {
+        p.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func (p *metricsPrinter) sample(name, labels string, value float64) {
+        if labels != "" {
+                labels = "{" + labels + "}"
+        }
+        p.printf("%s%s %s\n", name, labels, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+func (p *metricsPrinter) printf(format string, args ...interface{}) {
+        if p.err == nil {
+                _, p.err = fmt.Fprintf(p.w, format, args...)
+        }
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labels(name, value string) string {
+        return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+// MetricsTransport is a http.RoundTripper recording the count and duration of every request
+type MetricsTransport struct {
+        next    http.RoundTripper
+        metrics *Metrics
+}
+
+func NewMetricsTransport(next http.RoundTripper, metrics *Metrics) *MetricsTransport {
+        if next == nil {
+                next = http.DefaultTransport
+        }
+        return &MetricsTransport{next: next, metrics: metrics}
+}
+
+func (t *MetricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+        start := time.Now()
+        resp, err := t.next.RoundTrip(req)
+        status := "error"
+        if err == nil {
+                status = strconv.Itoa(resp.StatusCode)
+        }
+        t.metrics.RequestDone(req.Method, req.URL.Path, status, time.Since(start))
+        return resp, err
+}
@@ -0,0 +1,144 @@
+package internal
+
+import (
+        "io/ioutil"
+        "net/http"
+        "net/http/httptest"
+        "os"
+        "path/filepath"
+        "strings"
+        "testing"
+        "time"
+)
+
+func TestMetricsHistogramBuckets(t *testing.T) {
+        metrics := NewMetrics()
+        for _, duration := range []time.Duration{
+                10 * time.Millisecond,
+                100 * time.Millisecond,
+                time.Second,
+                90 * time.Second,
+        } {
+                metrics.RequestDone("GET", "/api/v1/monitor/123", "200", duration)
+        }
+        metrics.RequestDone("GET", "/api/v1/monitor/456", "error", time.Millisecond)
+        var text strings.Builder
+        if err := metrics.WriteText(&text); err != nil {
+                t.Fatal(err)
+        }
+        labels := `endpoint="/api/v1/monitor/:id",method="GET",status="200"`
+        for _, want := range []string{
+                // the buckets are cumulative, a request counts in every bucket at or above its duration
+                `datadog_backup_api_request_duration_seconds_bucket{` + labels + `,le="0.05"} 1`,
+                `datadog_backup_api_request_duration_seconds_bucket{` + labels + `,le="0.1"} 2`,
+                `datadog_backup_api_request_duration_seconds_bucket{` + labels + `,le="0.5"} 2`,
+                `datadog_backup_api_request_duration_seconds_bucket{` + labels + `,le="1"} 3`,
+                `datadog_backup_api_request_duration_seconds_bucket{` + labels + `,le="60"} 3`,
+                `datadog_backup_api_request_duration_seconds_bucket{` + labels + `,le="+Inf"} 4`,
+                `datadog_backup_api_request_duration_seconds_sum{` + labels + `} 91.11`,
+                `datadog_backup_api_request_duration_seconds_count{` + labels + `} 4`,
+                `datadog_backup_api_requests_total{` + labels + `} 4`,
+                `datadog_backup_api_requests_total{endpoint="/api/v1/monitor/:id",method="GET",status="error"} 1`,
+        } {
+                if !strings.Contains(text.String(), want+"\n") {
+                        t.Errorf("metrics\n%s\ndo not contain %s", text.String(), want)
+                }
+        }
+}
+
+func TestMetricsWriteText(t *testing.T) {
+        metrics := NewMetrics()
+        metrics.PullSucceeded("monitors", 3)
+        metrics.DriftDetected([]ElementDiff{
+                {Type: "monitors", Kind: DiffChanged},
+                {Type: "monitors", Kind: DiffChanged},
+                {Type: "dashboards", Kind: DiffDeleted},
+        })
+        metrics.CollectRateLimits(func() RateLimitStats {
+                return RateLimitStats{Throttled: 2, Retries: 1, Waited: 1500 * time.Millisecond}
+        })
+        var text strings.Builder
+        if err := metrics.WriteText(&text); err != nil {
+                t.Fatal(err)
+        }
+        for _, want := range []string{
+                "# HELP datadog_backup_elements number of elements of a config type at the last successful pull\n# TYPE datadog_backup_elements gauge\n",
+                `datadog_backup_elements{type="monitors"} 3` + "\n",
+                `datadog_backup_last_successful_pull_timestamp_seconds{type="monitors"} `,
+                "datadog_backup_rate_limit_waits_total 2\n",
+                "datadog_backup_rate_limit_wait_seconds_total 1.5\n",
+                "datadog_backup_api_retries_total 1\n",
+                `datadog_backup_drift_total{type="dashboards",kind="deleted"} 1` + "\n" + `datadog_backup_drift_total{type="monitors",kind="changed"} 2` + "\n",
+        } {
+                if !strings.Contains(text.String(), want) {
+                        t.Errorf("metrics\n%s\ndo not contain %q", text.String(), want)
+                }
+        }
+
+        var nilMetrics *Metrics
+        nilMetrics.PullSucceeded("monitors", 1)
+        text.Reset()
+        if err := nilMetrics.WriteText(&text); err != nil || text.Len() != 0 {
+                t.Errorf("nil metrics wrote %q, %v, want nothing", text.String(), err)
+        }
+}
+
+func TestLabelsAreEscaped(t *testing.T) {
+        if got, want := labels("endpoint", "a\"b\\c\nd"), `endpoint="a\"b\\c\nd"`; got != want {
+                t.Errorf("labels = %s, want %s", got, want)
+        }
+}
+
+func TestMetricsWriteTextfile(t *testing.T) {
+        dir, err := ioutil.TempDir("", "metrics")
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer os.RemoveAll(dir)
+        metrics := NewMetrics()
+        metrics.PullSucceeded("monitors", 3)
+        file := filepath.Join(dir, "datadog-backup.prom")
+        if err := metrics.WriteTextfile(file); err != nil {
+                t.Fatal(err)
+        }
+        content, err := ioutil.ReadFile(file)
+        if err != nil {
+                t.Fatal(err)
+        }
+        if !strings.Contains(string(content), `datadog_backup_elements{type="monitors"} 3`) {
+                t.Errorf("textfile = %s, want the elements of the pull", content)
+        }
+        files, err := ioutil.ReadDir(dir)
+        if err != nil || len(files) != 1 {
+                t.Errorf("files = %v, %v, want no temporary file left", files, err)
+        }
+}
+
+func TestMetricsTransport(t *testing.T) {
+        server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                w.WriteHeader(http.StatusNotFound)
+        }))
+        defer server.Close()
+        metrics := NewMetrics()
+        client := &http.Client{Transport: NewMetricsTransport(nil, metrics)}
+        resp, err := client.Get(server.URL + "/api/v1/dash/12")
+        if err != nil {
+                t.Fatal(err)
+        }
+        resp.Body.Close()
+        if _, err := client.Get("http://127.0.0.1:1/api/v1/dash/12"); err == nil {
+                t.Fatal("expected an error of the closed port")
+        }
+        var text strings.Builder
+        if err := metrics.WriteText(&text); err != nil {
+                t.Fatal(err)
+        }
+        for _, want := range []string{
+                `datadog_backup_api_requests_total{endpoint="/api/v1/dash/:id",method="GET",status="404"} 1`,
+                `datadog_backup_api_requests_total{endpoint="/api/v1/dash/:id",method="GET",status="error"} 1`,
+        } {
+                if !strings.Contains(text.String(), want) {
+                        t.Errorf("metrics\n%s\ndo not contain %s", text.String(), want)
+                }
+        }
+}
@@ -1,22 +1,23 @@
 package internal
 
 import (
+        "context"
         "github.com/pkg/errors"
         "github.com/sirupsen/logrus"
         "github.com/zorkian/go-datadog-api"
-        "gopkg.in/yaml.v3"
-        "os"
+        "io"
+        "strconv"
 )
 
 type monitorsClient struct {
         ddClient *datadog.Client
+        api      *apiClient
         log      *logrus.Entry
 }
 
-func (m *monitorsClient) DecodeFile(file *os.File) ([]ConfigElement, error) {
+func (m *monitorsClient) DecodeFile(reader io.Reader) ([]ConfigElement, error) {
         var configElements []monitorConfigElement
-        decoder := yaml.NewDecoder(file)
-        if err := decoder.Decode(&configElements); err != nil {
+        if err := decodeStrict(reader, &configElements); err != nil {
                 return nil, errors.WithMessage(err, "push: cannot read monitors file")
         }
         result := make([]ConfigElement, len(configElements))
@@ -26,21 +27,26 @@ func (m *monitorsClient) DecodeFile(file *os.File) ([]ConfigElement, error) {
         return result, nil
 }
 
-func NewMonitorsClient(ddClient *datadog.Client) DatadogConfigClient {
+func NewMonitorsClient(ddClient *datadog.Client, api *apiClient) DatadogConfigClient {
         return &monitorsClient{
                 ddClient: ddClient,
+                api:      api,
                 log:      logrus.WithField("prefix", "monitors"),
         }
 }
 
-func (m *monitorsClient) GetAll() (*ConfigElements, error) {
+func (m *monitorsClient) GetAll(ctx context.Context) (*ConfigElements, error) {
+        if err := ctx.Err(); err != nil {
+                return nil, err
+        }
         monitors, err := m.ddClient.GetMonitors()
         if err != nil {
                 return nil, errors.WithMessage(err, "get all monitors")
         }
         result := make([]ConfigElement, len(monitors))
-        for e, monitor := range monitors {
-                result[e] = m.newConfigElement(monitor.Name, monitor.Id, &monitor)
+        for e := range monitors {
+                monitor := &monitors[e]
+                result[e] = m.newConfigElement(monitor.Name, monitor.Id, monitor)
         }
         return &ConfigElements{
                 Elements: result,
@@ -52,20 +58,66 @@ func (m *monitorsClient) ConfigClientName() string {
         return "monitors"
 }
 
-func (m *monitorsClient) GetById(id int) (interface{}, error) {
-        return m.ddClient.GetMonitor(id)
+func (m *monitorsClient) GetById(ctx context.Context, id int) (ConfigElement, error) {
+        if err := ctx.Err(); err != nil {
+                return nil, err
+        }
+        monitor, err := m.ddClient.GetMonitor(id)
+        if err != nil {
+                return nil, err
+        }
+        return m.newConfigElement(monitor.Name, monitor.Id, monitor), nil
 }
 
-func (m *monitorsClient) GetByName(name string) ([]interface{}, error) {
+func (m *monitorsClient) GetByName(ctx context.Context, name string) ([]ConfigElement, error) {
+        if err := ctx.Err(); err != nil {
+                return nil, err
+        }
         monitors, err := m.ddClient.GetMonitorsByName(name)
-        return m.toInterfaceSlice(monitors), errors.WithMessage(err, "get monitors by name")
+        if err != nil {
+                return nil, errors.WithMessage(err, "get monitors by name")
+        }
+        result := make([]ConfigElement, len(monitors))
+        for e := range monitors {
+                result[e] = m.newConfigElement(monitors[e].Name, monitors[e].Id, &monitors[e])
+        }
+        return result, nil
 }
 
-func (m *monitorsClient) Create(e ConfigElement) (interface{}, error) {
-        return m.ddClient.CreateMonitor((e.GetDelegate()).(*datadog.Monitor))
+func (m *monitorsClient) Create(ctx context.Context, e ConfigElement) (ConfigElement, error) {
+        if err := ctx.Err(); err != nil {
+                return nil, err
+        }
+        monitor, err := m.ddClient.CreateMonitor((e.GetDelegate()).(*datadog.Monitor))
+        if err != nil {
+                return nil, err
+        }
+        return m.newConfigElement(monitor.Name, monitor.Id, monitor), nil
+}
+
+func (m *monitorsClient) Update(ctx context.Context, e ConfigElement) error {
+        if err := ctx.Err(); err != nil {
+                return err
+        }
+        return m.ddClient.UpdateMonitor((e.GetDelegate()).(*datadog.Monitor))
+}
+
+// ValidateRemote checks the monitor with the validate endpoint of datadog without creating it
+func (m *monitorsClient) ValidateRemote(ctx context.Context, e ConfigElement) error {
+        monitor := *(e.GetDelegate()).(*datadog.Monitor)
+        // remove the read only fields of pulled monitors
+        monitor.Id = nil
+        monitor.Creator = nil
+        monitor.OverallState = nil
+        monitor.OverallStateModified = nil
+        monitor.State = datadog.State{}
+        return m.api.doJson(ctx, "POST", "/api/v1/monitor/validate", &monitor, nil)
 }
 
-func (m *monitorsClient) Delete(id int) error {
+func (m *monitorsClient) Delete(ctx context.Context, id int) error {
+        if err := ctx.Err(); err != nil {
+                return err
+        }
         return m.ddClient.DeleteMonitor(id)
 }
 
@@ -91,6 +143,84 @@ func (m monitorConfigElement) GetId() int {
         return m.Id
 }
 
+func (m monitorConfigElement) GetTags() []string {
+        if m.Delegate == nil {
+                return nil
+        }
+        return m.Delegate.Tags
+}
+
+// GetReferences returns the monitors a composite monitor is built from, they are referenced by
+// id in its query, e.g. 123 && !456
+func (m monitorConfigElement) GetReferences() []Reference {
+        if m.Delegate == nil || m.Delegate.GetType() != "composite" {
+                return nil
+        }
+        var references []Reference
+        for _, match := range compositeMonitorIdRegex.FindAllString(m.Delegate.GetQuery(), -1) {
+                id, err := strconv.Atoi(match)
+                if err != nil {
+                        continue
+                }
+                references = append(references, Reference{Type: "monitors", Id: id, Field: "query"})
+        }
+        return references
+}
+
+func (m monitorConfigElement) RemapReferences(ids map[Reference]int) {
+        if m.Delegate == nil || m.Delegate.GetType() != "composite" {
+                return
+        }
+        query := compositeMonitorIdRegex.ReplaceAllStringFunc(m.Delegate.GetQuery(), func(match string) string {
+                id, err := strconv.Atoi(match)
+                if err != nil {
+                        return match
+                }
+                if newId, ok := ids[Reference{Type: "monitors", Id: id, Field: "query"}]; ok {
+                        return strconv.Itoa(newId)
+                }
+                return match
+        })
+        m.Delegate.SetQuery(query)
+}
+
+func (m monitorConfigElement) GetExternalId() string {
+        if m.Delegate == nil {
+                return ""
+        }
+        return tagValue(m.Delegate.Tags, externalIdPrefix)
+}
+
+func (m monitorConfigElement) SetExternalId(externalId string) {
+        if m.Delegate != nil && m.GetExternalId() == "" {
+                m.Delegate.Tags = append(m.Delegate.Tags, externalIdPrefix+externalId)
+        }
+}
+
+// Fingerprint is built from the type and the query of the monitor
+func (m monitorConfigElement) Fingerprint() string {
+        if m.Delegate == nil || m.Delegate.GetQuery() == "" {
+                return ""
+        }
+        return fingerprint(m.Delegate.GetType(), m.Delegate.GetQuery())
+}
+
+func (m monitorConfigElement) SetRemoteId(id int) {
+        if m.Delegate != nil {
+                m.Delegate.SetId(id)
+        }
+}
+
+func (m monitorConfigElement) IsOwnedBy(owner string) bool {
+        return m.Delegate != nil && containsTag(m.Delegate.Tags, owner)
+}
+
+func (m monitorConfigElement) SetOwner(owner string) {
+        if m.Delegate != nil && !containsTag(m.Delegate.Tags, owner) {
+                m.Delegate.Tags = append(m.Delegate.Tags, owner)
+        }
+}
+
 func (m monitorConfigElement) GetDelegate() interface{} {
         return m.Delegate
 }
@@ -0,0 +1,289 @@
+package internal
+
+import (
+        "bytes"
+        "context"
+        "encoding/json"
+        "fmt"
+        "github.com/pkg/errors"
+        "gopkg.in/yaml.v3"
+        "io/ioutil"
+        "net"
+        "net/http"
+        "net/smtp"
+        "os"
+        "strings"
+        "text/template"
+        "time"
+)
+
+type NotificationEvent string
+
+const (
+        // EventDrift is sent by diff and serve if elements changed, appeared or were deleted remotely
+        EventDrift NotificationEvent = "drift"
+        // EventPushFailure is sent if push failed or elements could not be pushed
+        EventPushFailure NotificationEvent = "push-failure"
+        // EventPrunePreview is sent by a dry run of prune with the elements it would delete
+        EventPrunePreview NotificationEvent = "prune-preview"
+)
+
+// notifyTimeout limits sending one notification, it does not depend on the run, so failures
+// are notified even if the run timed out
+const notifyTimeout = 30 * time.Second
+
+// defaultNotificationTemplate renders the title and one line per element
+const defaultNotificationTemplate = `{{.Title}}
+{{range .Diffs}}- {{.}}
+{{range .Changes}}    {{.Path}}: {{printf "%q" .Local}} -> {{printf "%q" .Remote}}
+{{end}}{{end}}{{range .Failures}}- {{.}}
+{{end}}{{if .Error}}error: {{.Error}}
+{{end}}`
+
+// Notification is rendered by the template of a notifier, webhooks get it as json as well
+type Notification struct {
+        Event    NotificationEvent `json:"event"`
+        Title    string            `json:"title"`
+        Diffs    []ElementDiff     `json:"diffs,omitempty"`
+        Failures []ElementFailure  `json:"failures,omitempty"`
+        Error    string            `json:"error,omitempty"`
+}
+
+// Notifier sends notifications about a run to the team
+type Notifier interface {
+        // Wants tells if the notifier sends notifications of the event
+        Wants(event NotificationEvent) bool
+        Notify(ctx context.Context, n Notification) error
+}
+
+// NotifierConfig is a notifier in the notifiers file. Type is webhook, slack or email. Values
+// of url, headers, username and password can refer to environment variables like ${SLACK_URL}.
+type NotifierConfig struct {
+        Type string `yaml:"type"`
+        // Events the notifier sends, all if empty
+        Events []NotificationEvent `yaml:"events"`
+        // Template is a text/template of the message, rendered with the Notification
+        Template string `yaml:"template"`
+        // Url of the webhook and slack notifiers
+        Url     string            `yaml:"url"`
+        Headers map[string]string `yaml:"headers"`
+        // SmtpAddress is the host:port of the mail server of the email notifier
+        SmtpAddress string   `yaml:"smtp_address"`
+        Username    string   `yaml:"username"`
+        Password    string   `yaml:"password"`
+        From        string   `yaml:"from"`
+        To          []string `yaml:"to"`
+}
+
+// LoadNotifiers reads the notifiers file
+func LoadNotifiers(file string) ([]Notifier, error) {
+        content, err := ioutil.ReadFile(file)
+        if err != nil {
+                return nil, errors.WithMessagef(err, "cannot read notifiers file %s", file)
+        }
+        var notifiersFile struct {
+                Notifiers []NotifierConfig `yaml:"notifiers"`
+        }
+        if err := yaml.Unmarshal(content, &notifiersFile); err != nil {
+                return nil, errors.WithMessagef(err, "cannot decode notifiers file %s", file)
+        }
+        var notifiers []Notifier
+        for i, config := range notifiersFile.Notifiers {
+                notifier, err := NewNotifier(config)
+                if err != nil {
+                        return nil, errors.WithMessagef(err, "notifier %d in %s", i+1, file)
+                }
+                notifiers = append(notifiers, notifier)
+        }
+        return notifiers, nil
+}
+
+func NewNotifier(config NotifierConfig) (Notifier, error) {
+        base := notifierBase{events: map[NotificationEvent]bool{}}
+        for _, event := range config.Events {
+                if event != EventDrift && event != EventPushFailure && event != EventPrunePreview {
+                        return nil, errors.Errorf("unknown event %s", event)
+                }
+                base.events[event] = true
+        }
+        text := config.Template
+        if text == "" {
+                text = defaultNotificationTemplate
+        }
+        var err error
+        if base.template, err = template.New(config.Type).Parse(text); err != nil {
+                return nil, errors.WithMessage(err, "invalid template")
+        }
+        headers := map[string]string{}
+        for name, value := range config.Headers {
+                headers[name] = os.ExpandEnv(value)
+        }
+        url := os.ExpandEnv(config.Url)
+
+        switch config.Type {
+        case "webhook", "slack":
+                if url == "" {
+                        return nil, errors.Errorf("%s notifier needs a url", config.Type)
+                }
+                return &webhookNotifier{notifierBase: base, url: url, headers: headers, slack: config.Type == "slack"}, nil
+        case "email":
+                if config.SmtpAddress == "" || config.From == "" || len(config.To) == 0 {
+                        return nil, errors.New("email notifier needs smtp_address, from and to")
+                }
+                host, _, err := net.SplitHostPort(config.SmtpAddress)
+                if err != nil {
+                        return nil, errors.WithMessagef(err, "invalid smtp_address %s", config.SmtpAddress)
+                }
+                notifier := &emailNotifier{notifierBase: base, address: config.SmtpAddress, from: config.From, to: config.To}
+                if username := os.ExpandEnv(config.Username); username != "" {
+                        notifier.auth = smtp.PlainAuth("", username, os.ExpandEnv(config.Password), host)
+                }
+                return notifier, nil
+        default:
+                return nil, errors.Errorf("unknown notifier type %q, use webhook, slack or email", config.Type)
+        }
+}
+
+type notifierBase struct {
+        events   map[NotificationEvent]bool
+        template *template.Template
+}
+
+func (n *notifierBase) Wants(event NotificationEvent) bool {
+        return len(n.events) == 0 || n.events[event]
+}
+
+func (n *notifierBase) render(notification Notification) (string, error) {
+        var text bytes.Buffer
+        if err := n.template.Execute(&text, notification); err != nil {
+                return "", errors.WithMessage(err, "cannot render notification")
+        }
+        return text.String(), nil
+}
+
+// webhookNotifier posts the notification as json with the rendered message as text, slack
+// incoming webhooks only get the text
+type webhookNotifier struct {
+        notifierBase
+        url     string
+        headers map[string]string
+        slack   bool
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, n Notification) error {
+        text, err := w.render(n)
+        if err != nil {
+                return err
+        }
+        var payload interface{} = struct {
+                Notification
+                Text string `json:"text"`
+        }{n, text}
+        if w.slack {
+                payload = map[string]string{"text": text}
+        }
+        body, err := json.Marshal(payload)
+        if err != nil {
+                return errors.WithMessage(err, "cannot encode notification")
+        }
+        req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+        if err != nil {
+                return err
+        }
+        req = req.WithContext(ctx)
+        req.Header.Set("Content-Type", "application/json")
+        for name, value := range w.headers {
+                req.Header.Set(name, value)
+        }
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+                return errors.WithMessage(err, "cannot post notification")
+        }
+        defer closeQuietly(resp.Body)
+        if resp.StatusCode < 200 || resp.StatusCode > 299 {
+                return errors.Errorf("webhook responded with %s", resp.Status)
+        }
+        return nil
+}
+
+// String leaves out the url, it often contains a secret
+func (w *webhookNotifier) String() string {
+        if w.slack {
+                return "slack"
+        }
+        return "webhook"
+}
+
+// emailNotifier sends the notification as plain text mail, the title is the subject
+type emailNotifier struct {
+        notifierBase
+        address string
+        auth    smtp.Auth
+        from    string
+        to      []string
+}
+
+func (e *emailNotifier) Notify(ctx context.Context, n Notification) error {
+        text, err := e.render(n)
+        if err != nil {
+                return err
+        }
+        var message bytes.Buffer
+        fmt.Fprintf(&message, "From: %s\r\n", e.from)
+        fmt.Fprintf(&message, "To: %s\r\n", strings.Join(e.to, ", "))
+        fmt.Fprintf(&message, "Subject: %s\r\n", strings.ReplaceAll(n.Title, "\n", " "))
+        fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+        message.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+        message.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+
+        // net/smtp has no context, the mail is sent in the background and abandoned on timeout
+        done := make(chan error, 1)
+        go func() {
+                done <- smtp.SendMail(e.address, e.auth, e.from, e.to, message.Bytes())
+        }()
+        select {
+        case err := <-done:
+                return errors.WithMessagef(err, "cannot send mail via %s", e.address)
+        case <-ctx.Done():
+                return errors.WithMessagef(ctx.Err(), "cannot send mail via %s", e.address)
+        }
+}
+
+func (e *emailNotifier) String() string {
+        return "email to " + strings.Join(e.to, ", ")
+}
+
+// notify sends the notification to every notifier which wants it, failures are only logged
+func (b *backupService) notify(n Notification) {
+        for _, notifier := range b.notifiers {
+                if !notifier.Wants(n.Event) {
+                        continue
+                }
+                ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+                err := notifier.Notify(ctx, n)
+                cancel()
+                if err != nil {
+                        b.log.WithError(err).Errorf("cannot send %s notification to %s", n.Event, notifier)
+                        continue
+                }
+                b.log.Debugf("sent %s notification to %s", n.Event, notifier)
+        }
+}
+
+// notifyDrift notifies about elements which changed, appeared or were deleted remotely
+func (b *backupService) notifyDrift(diffs []ElementDiff) {
+        var drift []ElementDiff
+        for _, diff := range diffs {
+                if diff.Kind != DiffNotPushed {
+                        drift = append(drift, diff)
+                }
+        }
+        if len(drift) == 0 {
+                return
+        }
+        b.notify(Notification{
+                Event: EventDrift,
+                Title: fmt.Sprintf("datadog-backup detected drift in %d element(s)", len(drift)),
+                Diffs: drift,
+        })
+}
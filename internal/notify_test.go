@@ -0,0 +1,274 @@
+package internal
+
+import (
+        "bufio"
+        "context"
+        "encoding/json"
+        "io/ioutil"
+        "net"
+        "net/http"
+        "net/http/httptest"
+        "os"
+        "path/filepath"
+        "reflect"
+        "strings"
+        "testing"
+        "time"
+)
+
+var testNotification = Notification{
+        Event: EventDrift,
+        Title: "drift in 1 element(s)",
+        Diffs: []ElementDiff{{Type: "monitors", Id: 1, Name: "cpu", Kind: DiffChanged, Changes: []FieldChange{{Path: "query", Local: "a", Remote: "b"}}}},
+}
+
+// recordingNotifier keeps the notifications of the events it wants
+type recordingNotifier struct {
+        notifierBase
+        sent []Notification
+}
+
+func (r *recordingNotifier) Notify(ctx context.Context, n Notification) error {
+        r.sent = append(r.sent, n)
+        return nil
+}
+
+func TestNewNotifier(t *testing.T) {
+        tests := []struct {
+                name    string
+                config  NotifierConfig
+                wantErr string
+        }{
+                {"webhook", NotifierConfig{Type: "webhook", Url: "http://localhost"}, ""},
+                {"slack without url", NotifierConfig{Type: "slack"}, "needs a url"},
+                {"email without recipients", NotifierConfig{Type: "email", SmtpAddress: "localhost:25", From: "a@b"}, "needs smtp_address, from and to"},
+                {"email without port", NotifierConfig{Type: "email", SmtpAddress: "localhost", From: "a@b", To: []string{"c@d"}}, "invalid smtp_address"},
+                {"unknown event", NotifierConfig{Type: "webhook", Url: "http://localhost", Events: []NotificationEvent{"push"}}, "unknown event push"},
+                {"invalid template", NotifierConfig{Type: "webhook", Url: "http://localhost", Template: "{{.Title"}, "invalid template"},
+                {"unknown type", NotifierConfig{Type: "pager"}, "unknown notifier type"},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        _, err := NewNotifier(test.config)
+                        if test.wantErr == "" {
+                                if err != nil {
+                                        t.Errorf("error = %v, want none", err)
+                                }
+                                return
+                        }
+                        if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+                                t.Errorf("error = %v, want %q", err, test.wantErr)
+                        }
+                })
+        }
+}
+
+func TestNotificationTemplates(t *testing.T) {
+        tests := []struct {
+                name         string
+                template     string
+                notification Notification
+                want         string
+        }{
+                {"default drift", "", testNotification, "drift in 1 element(s)\n- monitors 1 (cpu) changed, 1 field(s) changed\n    query: \"a\" -> \"b\"\n"},
+                {"default failure", "", Notification{
+                        Event:    EventPushFailure,
+                        Title:    "push failed",
+                        Failures: []ElementFailure{{Type: "monitors", Id: 2, Name: "disk", Error: "bad"}},
+                        Error:    "1 element(s) failed",
+                }, "push failed\n- monitors 2 (disk): bad\nerror: 1 element(s) failed\n"},
+                {"custom", "{{.Event}}: {{len .Diffs}} element(s)", testNotification, "drift: 1 element(s)"},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        notifier, err := NewNotifier(NotifierConfig{Type: "webhook", Url: "http://localhost", Template: test.template})
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        text, err := notifier.(*webhookNotifier).render(test.notification)
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        if text != test.want {
+                                t.Errorf("text = %q, want %q", text, test.want)
+                        }
+                })
+        }
+}
+
+func TestWebhookNotifier(t *testing.T) {
+        setenv(t, "NOTIFY_TOKEN", "secret")
+        var body map[string]interface{}
+        var authorization string
+        status := http.StatusOK
+        server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                authorization = r.Header.Get("Authorization")
+                body = nil
+                if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+                        t.Error(err)
+                }
+                w.WriteHeader(status)
+        }))
+        defer server.Close()
+
+        webhook, err := NewNotifier(NotifierConfig{Type: "webhook", Url: server.URL, Headers: map[string]string{"Authorization": "Bearer ${NOTIFY_TOKEN}"}, Template: "{{.Title}}"})
+        if err != nil {
+                t.Fatal(err)
+        }
+        if err := webhook.Notify(context.Background(), testNotification); err != nil {
+                t.Fatal(err)
+        }
+        if authorization != "Bearer secret" {
+                t.Errorf("authorization = %q, want the expanded header", authorization)
+        }
+        if body["event"] != "drift" || body["title"] != testNotification.Title || body["text"] != testNotification.Title || len(body["diffs"].([]interface{})) != 1 {
+                t.Errorf("webhook body = %v, want the notification with its text", body)
+        }
+
+        slack, err := NewNotifier(NotifierConfig{Type: "slack", Url: server.URL, Template: "{{.Title}}"})
+        if err != nil {
+                t.Fatal(err)
+        }
+        if err := slack.Notify(context.Background(), testNotification); err != nil {
+                t.Fatal(err)
+        }
+        if !reflect.DeepEqual(body, map[string]interface{}{"text": testNotification.Title}) {
+                t.Errorf("slack body = %v, want the text only", body)
+        }
+
+        status = http.StatusInternalServerError
+        if err := slack.Notify(context.Background(), testNotification); err == nil || !strings.Contains(err.Error(), "500") {
+                t.Errorf("error = %v, want the status of the webhook", err)
+        }
+}
+
+// smtpStub accepts one mail without authentication and sends its data to the channel
+func smtpStub(t *testing.T) (string, chan string) {
+        t.Helper()
+        listener, err := net.Listen("tcp", "127.0.0.1:0")
+        if err != nil {
+                t.Fatal(err)
+        }
+        t.Cleanup(func() { listener.Close() })
+        mails := make(chan string, 1)
+        go func() {
+                conn, err := listener.Accept()
+                if err != nil {
+                        return
+                }
+                defer conn.Close()
+                reader := bufio.NewReader(conn)
+                reply := func(line string) {
+                        _, _ = conn.Write([]byte(line + "\r\n"))
+                }
+                reply("220 stub")
+                var data strings.Builder
+                inData := false
+                for {
+                        line, err := reader.ReadString('\n')
+                        if err != nil {
+                                return
+                        }
+                        if inData {
+                                if line == ".\r\n" {
+                                        inData = false
+                                        mails <- data.String()
+                                        reply("250 queued")
+                                        continue
+                                }
+                                data.WriteString(line)
+                                continue
+                        }
+                        switch command := strings.ToUpper(strings.Fields(line)[0]); command {
+                        case "DATA":
+                                inData = true
+                                reply("354 go ahead")
+                        case "QUIT":
+                                reply("221 bye")
+                                return
+                        default:
+                                reply("250 ok")
+                        }
+                }
+        }()
+        return listener.Addr().String(), mails
+}
+
+func TestEmailNotifier(t *testing.T) {
+        address, mails := smtpStub(t)
+        email, err := NewNotifier(NotifierConfig{Type: "email", SmtpAddress: address, From: "backup@example.com", To: []string{"a@example.com", "b@example.com"}})
+        if err != nil {
+                t.Fatal(err)
+        }
+        if err := email.Notify(context.Background(), testNotification); err != nil {
+                t.Fatal(err)
+        }
+        select {
+        case mail := <-mails:
+                for _, want := range []string{
+                        "From: backup@example.com\r\n",
+                        "To: a@example.com, b@example.com\r\n",
+                        "Subject: drift in 1 element(s)\r\n",
+                        "\r\n\r\ndrift in 1 element(s)\r\n- monitors 1 (cpu) changed, 1 field(s) changed\r\n",
+                } {
+                        if !strings.Contains(mail, want) {
+                                t.Errorf("mail\n%s\ndoes not contain %q", mail, want)
+                        }
+                }
+        case <-time.After(5 * time.Second):
+                t.Fatal("no mail was sent")
+        }
+}
+
+func TestNotifyEventSelection(t *testing.T) {
+        all := &recordingNotifier{notifierBase: notifierBase{}}
+        failures := &recordingNotifier{notifierBase: notifierBase{events: map[NotificationEvent]bool{EventPushFailure: true}}}
+        service := snapshotService(t, nil, nil)
+        service.notifiers = []Notifier{all, failures}
+
+        service.notify(Notification{Event: EventPushFailure, Title: "push failed"})
+        service.notifyDrift([]ElementDiff{{Type: "monitors", Id: 1, Kind: DiffNotPushed}})
+        service.notifyDrift([]ElementDiff{{Type: "monitors", Id: 1, Kind: DiffNotPushed}, {Type: "monitors", Id: 2, Kind: DiffDeleted}})
+
+        events := func(sent []Notification) []string {
+                var events []string
+                for _, n := range sent {
+                        events = append(events, string(n.Event)+": "+n.Title)
+                }
+                return events
+        }
+        // local elements which were never pushed are no drift
+        want := []string{"push-failure: push failed", "drift: datadog-backup detected drift in 1 element(s)"}
+        if got := events(all.sent); !reflect.DeepEqual(got, want) {
+                t.Errorf("notifier of all events got %q, want %q", got, want)
+        }
+        if got := events(failures.sent); !reflect.DeepEqual(got, want[:1]) {
+                t.Errorf("notifier of push failures got %q, want %q", got, want[:1])
+        }
+}
+
+func TestLoadNotifiers(t *testing.T) {
+        dir, err := ioutil.TempDir("", "notifiers")
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer os.RemoveAll(dir)
+        file := filepath.Join(dir, "notifiers.yaml")
+        content := "notifiers:\n  - type: slack\n    url: http://localhost\n    events: [drift]\n  - type: email\n    smtp_address: localhost:25\n    from: a@b\n    to: [c@d]\n"
+        if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+                t.Fatal(err)
+        }
+        notifiers, err := LoadNotifiers(file)
+        if err != nil {
+                t.Fatal(err)
+        }
+        if len(notifiers) != 2 || !notifiers[0].Wants(EventDrift) || notifiers[0].Wants(EventPushFailure) || !notifiers[1].Wants(EventPrunePreview) {
+                t.Errorf("notifiers = %v, want slack for drift and email for every event", notifiers)
+        }
+        if err := ioutil.WriteFile(file, []byte("notifiers:\n  - type: slack\n"), 0644); err != nil {
+                t.Fatal(err)
+        }
+        if _, err := LoadNotifiers(file); err == nil || !strings.Contains(err.Error(), "notifier 1") {
+                t.Errorf("error = %v, want the invalid notifier 1", err)
+        }
+}
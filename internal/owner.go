@@ -0,0 +1,39 @@
+package internal
+
+import "strings"
+
+// The owner marker is a tag like managed-by:datadog-backup/team-x which scopes pull, diff,
+// prune and delete to the elements of one team. Monitors carry it as a tag, dashboards and
+// downtimes have no tags, so they carry it as a separate line of their description or message.
+
+func containsTag(tags []string, tag string) bool {
+        for _, t := range tags {
+                if t == tag {
+                        return true
+                }
+        }
+        return false
+}
+
+func textHasMarker(text *string, marker string) bool {
+        if text == nil {
+                return false
+        }
+        for _, line := range strings.Split(*text, "\n") {
+                if strings.TrimSpace(line) == marker {
+                        return true
+                }
+        }
+        return false
+}
+
+func textWithMarker(text *string, marker string) *string {
+        if textHasMarker(text, marker) {
+                return text
+        }
+        result := marker
+        if text != nil && *text != "" {
+                result = *text + "\n\n" + marker
+        }
+        return &result
+}
@@ -0,0 +1,215 @@
+package internal
+
+import (
+        "github.com/pkg/errors"
+        "github.com/sirupsen/logrus"
+        "io/ioutil"
+        "math/rand"
+        "net/http"
+        "strconv"
+        "strings"
+        "sync"
+        "time"
+)
+
+// RateLimitTransport is a http.RoundTripper for the datadog api which honours the rate limit
+// headers returned by datadog. Before a request is sent it waits until the rate limit of the
+// endpoint is reset if no requests are remaining, 429 and 5xx responses are retried with a
+// jittered exponential backoff. Requests which are not idempotent, like creating an element, are
+// only retried on 429, as a 5xx or a failed connection may come after the element was created.
+type RateLimitTransport struct {
+        next       http.RoundTripper
+        log        *logrus.Entry
+        maxRetries int
+        baseDelay  time.Duration
+        maxDelay   time.Duration
+
+        mutex     sync.Mutex
+        limits    map[string]rateLimit
+        throttled int
+        retries   int
+        waited    time.Duration
+}
+
+type rateLimit struct {
+        remaining int
+        reset     time.Time
+}
+
+// RateLimitStats are the numbers of requests which had to wait for a rate limit or which were
+// retried, and the time spent waiting for them
+type RateLimitStats struct {
+        Throttled int
+        Retries   int
+        Waited    time.Duration
+}
+
+func NewRateLimitTransport(next http.RoundTripper, maxRetries int) *RateLimitTransport {
+        if next == nil {
+                next = http.DefaultTransport
+        }
+        return &RateLimitTransport{
+                next:       next,
+                log:        logrus.WithField("prefix", "rate-limit"),
+                maxRetries: maxRetries,
+                baseDelay:  500 * time.Millisecond,
+                maxDelay:   30 * time.Second,
+                limits:     map[string]rateLimit{},
+        }
+}
+
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+        endpoint := req.Method + " " + endpointPath(req.URL.Path)
+        for attempt := 0; ; attempt++ {
+                if err := t.waitForLimit(req, endpoint); err != nil {
+                        return nil, err
+                }
+
+                attemptReq := req
+                if attempt > 0 && req.Body != nil {
+                        if req.GetBody == nil {
+                                return nil, errors.Errorf("cannot retry request %s, body cannot be replayed", endpoint)
+                        }
+                        body, err := req.GetBody()
+                        if err != nil {
+                                return nil, errors.WithMessagef(err, "cannot retry request %s", endpoint)
+                        }
+                        attemptReq = req.Clone(req.Context())
+                        attemptReq.Body = body
+                }
+
+                resp, err := t.next.RoundTrip(attemptReq)
+                if err == nil {
+                        t.updateLimit(endpoint, resp)
+                }
+                retryable := err == nil && resp.StatusCode == http.StatusTooManyRequests
+                if idempotent(req.Method) {
+                        retryable = retryable || err != nil || resp.StatusCode >= 500
+                }
+                if !retryable || attempt >= t.maxRetries {
+                        return resp, err
+                }
+
+                delay := t.backoff(attempt)
+                logger := t.log.WithField("endpoint", endpoint).WithField("attempt", attempt+1)
+                if err != nil {
+                        logger.WithError(err).Warnf("request failed, retrying in %s", delay)
+                } else {
+                        if resp.StatusCode == http.StatusTooManyRequests {
+                                t.count(&t.throttled)
+                                if reset := t.resetDelay(resp); reset > delay {
+                                        delay = reset
+                                }
+                        }
+                        logger.Warnf("request returned %s, retrying in %s", resp.Status, delay)
+                        // drain the body so the connection can be reused
+                        _, _ = ioutil.ReadAll(resp.Body)
+                        closeQuietly(resp.Body)
+                }
+                t.count(&t.retries)
+                t.addWaited(delay)
+                if err := sleep(req, delay); err != nil {
+                        return nil, err
+                }
+        }
+}
+
+// idempotent returns true if sending a request with the method twice has the same effect as once
+func idempotent(method string) bool {
+        switch method {
+        case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+                return true
+        }
+        return false
+}
+
+// endpointPath replaces the ids in an api path, so requests for different elements share the
+// rate limit and metrics of their endpoint
+func endpointPath(path string) string {
+        segments := strings.Split(path, "/")
+        for i, segment := range segments {
+                if _, err := strconv.Atoi(segment); err == nil {
+                        segments[i] = ":id"
+                }
+        }
+        return strings.Join(segments, "/")
+}
+
+// Stats returns the number of throttled and retried requests so far
+func (t *RateLimitTransport) Stats() RateLimitStats {
+        t.mutex.Lock()
+        defer t.mutex.Unlock()
+        return RateLimitStats{Throttled: t.throttled, Retries: t.retries, Waited: t.waited}
+}
+
+func (t *RateLimitTransport) waitForLimit(req *http.Request, endpoint string) error {
+        t.mutex.Lock()
+        limit, ok := t.limits[endpoint]
+        t.mutex.Unlock()
+        if !ok || limit.remaining > 0 {
+                return nil
+        }
+        wait := time.Until(limit.reset)
+        if wait <= 0 {
+                return nil
+        }
+        t.count(&t.throttled)
+        t.addWaited(wait)
+        t.log.WithField("endpoint", endpoint).Infof("rate limit reached, waiting %s", wait)
+        return sleep(req, wait)
+}
+
+func (t *RateLimitTransport) updateLimit(endpoint string, resp *http.Response) {
+        remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+        if err != nil {
+                // the endpoint is not rate limited
+                return
+        }
+        t.mutex.Lock()
+        defer t.mutex.Unlock()
+        t.limits[endpoint] = rateLimit{
+                remaining: remaining,
+                reset:     time.Now().Add(t.resetDelay(resp)),
+        }
+}
+
+// resetDelay returns the time until the rate limit is reset, datadog sends it in seconds
+func (t *RateLimitTransport) resetDelay(resp *http.Response) time.Duration {
+        reset, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Reset"))
+        if err != nil || reset < 0 {
+                return 0
+        }
+        return time.Duration(reset) * time.Second
+}
+
+// backoff returns an exponential delay for the given attempt, randomized between half and the full delay
+func (t *RateLimitTransport) backoff(attempt int) time.Duration {
+        delay := t.baseDelay << uint(attempt)
+        if delay > t.maxDelay || delay <= 0 {
+                delay = t.maxDelay
+        }
+        return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (t *RateLimitTransport) count(counter *int) {
+        t.mutex.Lock()
+        defer t.mutex.Unlock()
+        *counter++
+}
+
+func (t *RateLimitTransport) addWaited(d time.Duration) {
+        t.mutex.Lock()
+        defer t.mutex.Unlock()
+        t.waited += d
+}
+
+func sleep(req *http.Request, d time.Duration) error {
+        timer := time.NewTimer(d)
+        defer timer.Stop()
+        select {
+        case <-timer.C:
+                return nil
+        case <-req.Context().Done():
+                return req.Context().Err()
+        }
+}
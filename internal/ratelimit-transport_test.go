@@ -0,0 +1,97 @@
+package internal
+
+import (
+        "net/http"
+        "net/http/httptest"
+        "strings"
+        "sync/atomic"
+        "testing"
+        "time"
+)
+
+func TestEndpointPath(t *testing.T) {
+        tests := map[string]string{
+                "/api/v1/monitor":             "/api/v1/monitor",
+                "/api/v1/monitor/123":         "/api/v1/monitor/:id",
+                "/api/v1/dash/1/":             "/api/v1/dash/:id/",
+                "/api/v1/downtime/1/2":        "/api/v1/downtime/:id/:id",
+                "/api/v1/monitor/validate":    "/api/v1/monitor/validate",
+                "/api/v2/audit/events/search": "/api/v2/audit/events/search",
+        }
+        for path, want := range tests {
+                if got := endpointPath(path); got != want {
+                        t.Errorf("endpointPath(%q) = %q, want %q", path, got, want)
+                }
+        }
+}
+
+func TestRateLimitTransportRetries(t *testing.T) {
+        tests := []struct {
+                name     string
+                method   string
+                status   int
+                attempts int32
+        }{
+                {"get is retried on 5xx", http.MethodGet, http.StatusBadGateway, 3},
+                {"get is retried on 429", http.MethodGet, http.StatusTooManyRequests, 3},
+                {"put is retried on 5xx", http.MethodPut, http.StatusInternalServerError, 3},
+                {"post is retried on 429", http.MethodPost, http.StatusTooManyRequests, 3},
+                {"post is not retried on 5xx", http.MethodPost, http.StatusBadGateway, 1},
+                {"4xx are not retried", http.MethodGet, http.StatusNotFound, 1},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        var attempts int32
+                        server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                                atomic.AddInt32(&attempts, 1)
+                                w.WriteHeader(test.status)
+                        }))
+                        defer server.Close()
+                        transport := NewRateLimitTransport(http.DefaultTransport, 2)
+                        transport.baseDelay = time.Millisecond
+
+                        req, err := http.NewRequest(test.method, server.URL+"/api/v1/monitor", strings.NewReader("{}"))
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        resp, err := transport.RoundTrip(req)
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        closeQuietly(resp.Body)
+                        if resp.StatusCode != test.status {
+                                t.Errorf("status = %d, want %d", resp.StatusCode, test.status)
+                        }
+                        if attempts := atomic.LoadInt32(&attempts); attempts != test.attempts {
+                                t.Errorf("attempts = %d, want %d", attempts, test.attempts)
+                        }
+                })
+        }
+}
+
+func TestRateLimitTransportSharesLimitsOfElements(t *testing.T) {
+        server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                w.Header().Set("X-RateLimit-Remaining", "0")
+                w.Header().Set("X-RateLimit-Reset", "1")
+        }))
+        defer server.Close()
+        transport := NewRateLimitTransport(http.DefaultTransport, 0)
+
+        for _, id := range []string{"1", "2"} {
+                req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/dash/"+id, nil)
+                if err != nil {
+                        t.Fatal(err)
+                }
+                resp, err := transport.RoundTrip(req)
+                if err != nil {
+                        t.Fatal(err)
+                }
+                closeQuietly(resp.Body)
+        }
+        if _, ok := transport.limits["GET /api/v1/dash/:id"]; !ok {
+                t.Errorf("limits %v have no entry of the dashboard endpoint", transport.limits)
+        }
+        if stats := transport.Stats(); stats.Throttled != 1 {
+                t.Errorf("throttled = %d, want the second dashboard to wait for the limit of the first", stats.Throttled)
+        }
+}
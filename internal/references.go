@@ -0,0 +1,199 @@
+package internal
+
+import (
+        "context"
+        "fmt"
+        "github.com/pkg/errors"
+        "github.com/sirupsen/logrus"
+        "regexp"
+)
+
+// Reference points from one element to another element by id, e.g. from a composite monitor
+// or a downtime to a monitor
+type Reference struct {
+        Type  string `json:"type"`
+        Id    int    `json:"id"`
+        Field string `json:"field"`
+}
+
+func (r Reference) key() string {
+        return elementKey(r.Type, r.Id)
+}
+
+// BrokenReference is a reference to an element which exists neither locally nor, if checked, remotely
+type BrokenReference struct {
+        Type      string    `json:"type"`
+        Id        int       `json:"id"`
+        Name      string    `json:"name"`
+        Reference Reference `json:"reference"`
+        // Remote is true if the referenced element was looked up remotely as well
+        Remote bool `json:"remote"`
+}
+
+func (b BrokenReference) String() string {
+        where := "locally"
+        if b.Remote {
+                where = "locally or remotely"
+        }
+        return fmt.Sprintf("%s %d (%s) refers to %s %d in %s, which does not exist %s",
+                b.Type, b.Id, b.Name, b.Reference.Type, b.Reference.Id, b.Reference.Field, where)
+}
+
+var compositeMonitorIdRegex = regexp.MustCompile(`\b\d+\b`)
+
+// ReferenceGraph holds the references between all loaded elements
+type ReferenceGraph struct {
+        elements map[string]ConfigElement
+        types    map[string]string
+        order    []string
+}
+
+func NewReferenceGraph() *ReferenceGraph {
+        return &ReferenceGraph{
+                elements: map[string]ConfigElement{},
+                types:    map[string]string{},
+        }
+}
+
+// Add adds the elements of a config type to the graph
+func (g *ReferenceGraph) Add(configType string, elements []ConfigElement) {
+        for _, e := range elements {
+                key := elementKey(configType, e.GetId())
+                if e.GetId() == -1 {
+                        // elements without id cannot be referenced, keep them apart by their position
+                        key = fmt.Sprintf("%s/new-%d", configType, len(g.order))
+                }
+                g.elements[key] = e
+                g.types[key] = configType
+                g.order = append(g.order, key)
+        }
+}
+
+// Broken returns the references to elements which are not in the graph
+func (g *ReferenceGraph) Broken() []BrokenReference {
+        var broken []BrokenReference
+        for _, key := range g.order {
+                e := g.elements[key]
+                for _, ref := range e.GetReferences() {
+                        if _, ok := g.elements[ref.key()]; !ok {
+                                broken = append(broken, BrokenReference{Type: g.types[key], Id: e.GetId(), Name: e.GetName(), Reference: ref})
+                        }
+                }
+        }
+        return broken
+}
+
+// BrokenRemote returns the references to elements which are neither in the graph nor in datadog
+func (g *ReferenceGraph) BrokenRemote(ctx context.Context, clients []DatadogConfigClient) ([]BrokenReference, error) {
+        clientsByType := map[string]DatadogConfigClient{}
+        for _, c := range clients {
+                clientsByType[c.ConfigClientName()] = c
+        }
+        exists := map[string]bool{}
+        var broken []BrokenReference
+        for _, b := range g.Broken() {
+                client, ok := clientsByType[b.Reference.Type]
+                if !ok {
+                        continue
+                }
+                key := b.Reference.key()
+                if _, checked := exists[key]; !checked {
+                        if err := ctx.Err(); err != nil {
+                                return broken, err
+                        }
+                        remote, err := client.GetById(ctx, b.Reference.Id)
+                        if err != nil && !isNotFound(err) {
+                                return broken, errors.WithMessagef(err, "cannot look up %s %d", b.Reference.Type, b.Reference.Id)
+                        }
+                        exists[key] = err == nil && remote != nil
+                }
+                if !exists[key] {
+                        b.Remote = true
+                        broken = append(broken, b)
+                }
+        }
+        return broken, nil
+}
+
+// Order returns the elements of a config type so that referenced elements come before the
+// elements referring to them. Elements in a reference cycle keep their original order.
+func (g *ReferenceGraph) Order(configType string, elements []ConfigElement) []ConfigElement {
+        keys := map[ConfigElement]string{}
+        for _, key := range g.order {
+                keys[g.elements[key]] = key
+        }
+        done := map[string]bool{}
+        visiting := map[string]bool{}
+        var result []ConfigElement
+        var visit func(e ConfigElement)
+        visit = func(e ConfigElement) {
+                key := elementKey(configType, e.GetId())
+                if k, ok := keys[e]; ok {
+                        key = k
+                }
+                if done[key] || visiting[key] {
+                        return
+                }
+                visiting[key] = true
+                for _, ref := range e.GetReferences() {
+                        if target, ok := g.elements[ref.key()]; ok && ref.Type == configType {
+                                visit(target)
+                        }
+                }
+                visiting[key] = false
+                done[key] = true
+                result = append(result, e)
+        }
+        inElements := map[string]bool{}
+        for _, e := range elements {
+                inElements[elementKey(configType, e.GetId())] = true
+        }
+        for _, e := range elements {
+                visit(e)
+        }
+        // referenced elements of the graph which are not part of the given elements are left out
+        ordered := result[:0]
+        for _, e := range result {
+                if e.GetId() == -1 || inElements[elementKey(configType, e.GetId())] {
+                        ordered = append(ordered, e)
+                }
+        }
+        return ordered
+}
+
+// References loads all config files and reports broken references, locally and against datadog
+func (b *backupService) References(ctx context.Context) ([]BrokenReference, error) {
+        graph, _, err := b.loadReferenceGraph(b.readConfigFileIfExists)
+        if err != nil {
+                return nil, errors.WithMessage(err, "references")
+        }
+        broken, err := graph.BrokenRemote(ctx, b.configClients)
+        if err != nil {
+                return broken, errors.WithMessage(err, "references")
+        }
+        for _, ref := range broken {
+                b.log.WithFields(logrus.Fields{"client": ref.Type, "action": "references", "element_id": ref.Id, "element_name": ref.Name}).Error(ref.String())
+        }
+        return broken, nil
+}
+
+// loadReferenceGraph reads the config files of all selected clients into a reference graph,
+// the filtered elements are returned by config type as well
+func (b *backupService) loadReferenceGraph(read func(DatadogConfigClient) ([]ConfigElement, error)) (*ReferenceGraph, map[string][]ConfigElement, error) {
+        graph := NewReferenceGraph()
+        elements := map[string][]ConfigElement{}
+        for _, c := range b.clients() {
+                configElements, err := read(c)
+                if err != nil {
+                        return nil, nil, errors.WithMessagef(err, "client %s", c.ConfigClientName())
+                }
+                configElements = b.filter.Apply(configElements)
+                graph.Add(c.ConfigClientName(), configElements)
+                elements[c.ConfigClientName()] = configElements
+        }
+        return graph, elements, nil
+}
+
+func elementKey(configType string, id int) string {
+        return fmt.Sprintf("%s/%d", configType, id)
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+        "context"
+        "errors"
+        "reflect"
+        "strings"
+        "testing"
+)
+
+// lookupClient answers GetById with the error of the id, ids without error exist
+type lookupClient struct {
+        DatadogConfigClient
+        errs    map[int]error
+        lookups int
+}
+
+func (c *lookupClient) ConfigClientName() string {
+        return "monitors"
+}
+
+func (c *lookupClient) GetById(ctx context.Context, id int) (ConfigElement, error) {
+        c.lookups++
+        if err := c.errs[id]; err != nil {
+                return nil, err
+        }
+        return monitorConfigElement{Name: "remote", Id: id}, nil
+}
+
+func brokenIds(broken []BrokenReference) []int {
+        var ids []int
+        for _, b := range broken {
+                ids = append(ids, b.Reference.Id)
+        }
+        return ids
+}
+
+func elementNames(elements []ConfigElement) []string {
+        var names []string
+        for _, e := range elements {
+                names = append(names, e.GetName())
+        }
+        return names
+}
+
+func TestReferenceGraphBroken(t *testing.T) {
+        graph := NewReferenceGraph()
+        graph.Add("monitors", testMonitors(t,
+                "{name: a, type: metric alert, query: q}",
+                "{name: b, type: composite, query: 1 && 7}",
+                "{name: c, type: metric alert, query: 7 > 1}",
+        ))
+        broken := graph.Broken()
+        if len(broken) != 1 || broken[0].Name != "m1" || broken[0].Reference != (Reference{Type: "monitors", Id: 7, Field: "query"}) {
+                t.Errorf("broken = %+v, want the reference of m1 to monitor 7", broken)
+        }
+}
+
+func TestReferenceGraphBrokenRemote(t *testing.T) {
+        tests := []struct {
+                name    string
+                errs    map[int]error
+                want    []int
+                wantErr bool
+        }{
+                {"all exist remotely", nil, nil, false},
+                {"api 404", map[int]error{7: &APIError{StatusCode: 404}}, []int{7, 7}, false},
+                {"go-datadog-api 404", map[int]error{8: errors.New("API error 404 Not Found: {\"errors\":[\"Monitor not found\"]}")}, []int{8}, false},
+                {"server error", map[int]error{7: &APIError{StatusCode: 502}}, nil, true},
+                {"rate limited", map[int]error{7: errors.New("API error 429 Too Many Requests: ")}, nil, true},
+                {"timeout", map[int]error{7: context.DeadlineExceeded}, nil, true},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        graph := NewReferenceGraph()
+                        graph.Add("monitors", testMonitors(t,
+                                "{name: a, type: composite, query: 7 && 8}",
+                                "{name: b, type: composite, query: 7 || 1}",
+                        ))
+                        client := &lookupClient{errs: test.errs}
+                        broken, err := graph.BrokenRemote(context.Background(), []DatadogConfigClient{client})
+                        if (err != nil) != test.wantErr {
+                                t.Fatalf("error = %v, want an error: %t", err, test.wantErr)
+                        }
+                        if test.wantErr {
+                                return
+                        }
+                        if got := brokenIds(broken); !reflect.DeepEqual(got, test.want) {
+                                t.Errorf("broken = %v, want %v", got, test.want)
+                        }
+                        for _, b := range broken {
+                                if !b.Remote {
+                                        t.Errorf("broken reference %+v was not marked as checked remotely", b)
+                                }
+                        }
+                        if client.lookups != 2 {
+                                t.Errorf("lookups = %d, want each missing element to be looked up once", client.lookups)
+                        }
+                })
+        }
+}
+
+func TestReferenceGraphOrder(t *testing.T) {
+        tests := []struct {
+                name     string
+                monitors []string
+                want     []string
+        }{
+                {
+                        name:     "referenced monitors first",
+                        monitors: []string{"{type: composite, query: 2 && 3}", "{type: metric alert, query: q}", "{type: composite, query: 2 || 2}"},
+                        want:     []string{"m1", "m2", "m0"},
+                },
+                {
+                        name:     "cycle keeps the order",
+                        monitors: []string{"{type: composite, query: 2 && 2}", "{type: composite, query: 1 && 1}"},
+                        want:     []string{"m1", "m0"},
+                },
+                {
+                        name:     "no references",
+                        monitors: []string{"{type: metric alert, query: 2}", "{type: metric alert, query: 1}"},
+                        want:     []string{"m0", "m1"},
+                },
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        monitors := testMonitors(t, test.monitors...)
+                        graph := NewReferenceGraph()
+                        graph.Add("monitors", monitors)
+                        if got := elementNames(graph.Order("monitors", monitors)); !reflect.DeepEqual(got, test.want) {
+                                t.Errorf("order = %v, want %v", got, test.want)
+                        }
+                })
+        }
+}
+
+func TestReferenceGraphOrderLeavesOutUnselectedElements(t *testing.T) {
+        monitors := testMonitors(t, "{type: metric alert, query: q}", "{type: composite, query: 1 && 1}")
+        graph := NewReferenceGraph()
+        graph.Add("monitors", monitors)
+        if got := elementNames(graph.Order("monitors", monitors[1:])); !reflect.DeepEqual(got, []string{"m1"}) {
+                t.Errorf("order = %v, want only the selected m1", got)
+        }
+}
+
+// timeboards cannot refer to monitors, see dashboardConfigElement.GetReferences
+func TestDashboardsHaveNoReferences(t *testing.T) {
+        dashboards, err := (&dashboardsClient{}).DecodeFile(strings.NewReader("- name: d\n  id: 1\n  delegate:\n    title: d\n    graphs:\n      - title: cpu\n        definition:\n          requests:\n            - query: avg:cpu{*}\n"))
+        if err != nil {
+                t.Fatal(err)
+        }
+        if refs := dashboards[0].GetReferences(); refs != nil {
+                t.Errorf("references = %v, want none", refs)
+        }
+}
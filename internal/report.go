@@ -0,0 +1,238 @@
+package internal
+
+import (
+        "encoding/json"
+        "fmt"
+        "github.com/pkg/errors"
+        "io"
+        "sort"
+        "sync"
+        "text/tabwriter"
+        "time"
+)
+
+type Outcome string
+
+const (
+        OutcomeCreated Outcome = "created"
+        OutcomeUpdated Outcome = "updated"
+        OutcomeSkipped Outcome = "skipped"
+        OutcomeFailed  Outcome = "failed"
+        OutcomeDeleted Outcome = "deleted"
+        OutcomePulled  Outcome = "pulled"
+)
+
+const (
+        StatusSuccess        = "success"
+        StatusPartialFailure = "partial-failure"
+        StatusFailure        = "failure"
+)
+
+// Counters are the outcomes of the elements of one config type
+type Counters struct {
+        Created int `json:"created"`
+        Updated int `json:"updated"`
+        Skipped int `json:"skipped"`
+        Failed  int `json:"failed"`
+        Deleted int `json:"deleted"`
+        Pulled  int `json:"pulled"`
+}
+
+func (c *Counters) succeeded() int {
+        return c.Created + c.Updated + c.Deleted + c.Pulled
+}
+
+// Report collects the outcome of every element processed during a run
+type Report struct {
+        mutex       sync.Mutex
+        Start       time.Time `json:"start"`
+        End         time.Time `json:"end"`
+        Status      string    `json:"status"`
+        Error       string    `json:"error,omitempty"`
+        Interrupted bool      `json:"interrupted"`
+        // Unconfirmed counts the changes skipped because nobody could confirm them
+        Unconfirmed int                  `json:"unconfirmed,omitempty"`
+        Types       map[string]*Counters `json:"types"`
+        Drift       []ElementDiff        `json:"drift,omitempty"`
+        Failures    []ElementFailure     `json:"failures,omitempty"`
+
+        // refreshing is set while the config files are pulled after the action of the run, the
+        // refreshed elements do not make the action a success
+        refreshing bool
+        refreshed  int
+}
+
+// ElementFailure is an element which could not be processed
+type ElementFailure struct {
+        Type  string `json:"type"`
+        Id    int    `json:"id"`
+        Name  string `json:"name"`
+        Error string `json:"error"`
+}
+
+func (f ElementFailure) String() string {
+        return fmt.Sprintf("%s %d (%s): %s", f.Type, f.Id, f.Name, f.Error)
+}
+
+func NewReport() *Report {
+        return &Report{
+                Start: time.Now(),
+                Types: map[string]*Counters{},
+        }
+}
+
+// Count adds the outcome of one element of the given type
+func (r *Report) Count(configType string, outcome Outcome) {
+        r.mutex.Lock()
+        defer r.mutex.Unlock()
+        counters, ok := r.Types[configType]
+        if !ok {
+                counters = &Counters{}
+                r.Types[configType] = counters
+        }
+        switch outcome {
+        case OutcomeCreated:
+                counters.Created++
+        case OutcomeUpdated:
+                counters.Updated++
+        case OutcomeSkipped:
+                counters.Skipped++
+        case OutcomeFailed:
+                counters.Failed++
+        case OutcomeDeleted:
+                counters.Deleted++
+        case OutcomePulled:
+                counters.Pulled++
+                if r.refreshing {
+                        r.refreshed++
+                }
+        }
+}
+
+// Refresh marks the following pulls as a refresh of the config files after the action of the
+// run, like the pull after push. They are counted, but the status depends on the action only.
+func (r *Report) Refresh() {
+        r.mutex.Lock()
+        defer r.mutex.Unlock()
+        r.refreshing = true
+}
+
+// Unconfirm counts a change which was skipped because there was no terminal to confirm it, the
+// run did part of its work only
+func (r *Report) Unconfirm() {
+        r.mutex.Lock()
+        defer r.mutex.Unlock()
+        r.Unconfirmed++
+}
+
+// Fail counts a failed element and keeps why it failed
+func (r *Report) Fail(configType string, id int, name string, err error) {
+        r.Count(configType, OutcomeFailed)
+        message := "unknown error"
+        if err != nil {
+                message = err.Error()
+        }
+        r.mutex.Lock()
+        defer r.mutex.Unlock()
+        r.Failures = append(r.Failures, ElementFailure{Type: configType, Id: id, Name: name, Error: message})
+}
+
+// failures returns a copy of the failures so far
+func (r *Report) failures() []ElementFailure {
+        r.mutex.Lock()
+        defer r.mutex.Unlock()
+        return append([]ElementFailure(nil), r.Failures...)
+}
+
+// AddDrift adds differences between the config files and datadog found during the run
+func (r *Report) AddDrift(diffs []ElementDiff) {
+        r.mutex.Lock()
+        defer r.mutex.Unlock()
+        r.Drift = append(r.Drift, diffs...)
+}
+
+// ResetDrift drops the drift found so far, serve keeps the drift of its latest check only
+func (r *Report) ResetDrift() {
+        r.mutex.Lock()
+        defer r.mutex.Unlock()
+        r.Drift = nil
+}
+
+// Finish marks the end of the run and determines its status. A run is a failure if it ended
+// with an error or if elements failed and none succeeded, and a partial failure if some failed or
+// changes were left unconfirmed. Elements pulled by a refresh do not count as succeeded.
+func (r *Report) Finish(err error) string {
+        r.mutex.Lock()
+        defer r.mutex.Unlock()
+        r.End = time.Now()
+        if errors.Is(err, ErrStopped) {
+                r.Interrupted = true
+        }
+        failed, succeeded := 0, 0
+        for _, counters := range r.Types {
+                failed += counters.Failed
+                succeeded += counters.succeeded()
+        }
+        succeeded -= r.refreshed
+        switch {
+        case err != nil && !r.Interrupted:
+                r.Error = err.Error()
+                r.Status = StatusFailure
+        case failed > 0 && succeeded == 0:
+                r.Status = StatusFailure
+        case failed > 0 || r.Interrupted || r.Unconfirmed > 0:
+                r.Status = StatusPartialFailure
+        default:
+                r.Status = StatusSuccess
+        }
+        return r.Status
+}
+
+// WriteTable writes the counters as a human readable table
+func (r *Report) WriteTable(w io.Writer) error {
+        r.mutex.Lock()
+        defer r.mutex.Unlock()
+        table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+        _, _ = fmt.Fprintln(table, "TYPE\tCREATED\tUPDATED\tSKIPPED\tFAILED\tDELETED\tPULLED")
+        total := Counters{}
+        for _, configType := range r.sortedTypes() {
+                c := r.Types[configType]
+                _, _ = fmt.Fprintf(table, "%s\t%d\t%d\t%d\t%d\t%d\t%d\n", configType, c.Created, c.Updated, c.Skipped, c.Failed, c.Deleted, c.Pulled)
+                total.Created += c.Created
+                total.Updated += c.Updated
+                total.Skipped += c.Skipped
+                total.Failed += c.Failed
+                total.Deleted += c.Deleted
+                total.Pulled += c.Pulled
+        }
+        _, _ = fmt.Fprintf(table, "total\t%d\t%d\t%d\t%d\t%d\t%d\n", total.Created, total.Updated, total.Skipped, total.Failed, total.Deleted, total.Pulled)
+        for _, diff := range r.Drift {
+                _, _ = fmt.Fprintf(table, "drift: %s\n", diff)
+        }
+        for _, failure := range r.Failures {
+                _, _ = fmt.Fprintf(table, "failed: %s\n", failure)
+        }
+        if r.Unconfirmed > 0 {
+                _, _ = fmt.Fprintf(table, "unconfirmed: %d change(s) were skipped, confirm them on a terminal or pass --yes\n", r.Unconfirmed)
+        }
+        _, _ = fmt.Fprintf(table, "status: %s\n", r.Status)
+        return errors.WithMessage(table.Flush(), "write report table")
+}
+
+// WriteJSON writes the whole report as json
+func (r *Report) WriteJSON(w io.Writer) error {
+        r.mutex.Lock()
+        defer r.mutex.Unlock()
+        encoder := json.NewEncoder(w)
+        encoder.SetIndent("", "  ")
+        return errors.WithMessage(encoder.Encode(r), "write report json")
+}
+
+func (r *Report) sortedTypes() []string {
+        types := make([]string, 0, len(r.Types))
+        for configType := range r.Types {
+                types = append(types, configType)
+        }
+        sort.Strings(types)
+        return types
+}
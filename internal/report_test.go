@@ -0,0 +1,149 @@
+package internal
+
+import (
+        "context"
+        "github.com/pkg/errors"
+        "github.com/sirupsen/logrus"
+        "io/ioutil"
+        "os"
+        "path/filepath"
+        "reflect"
+        "strings"
+        "testing"
+)
+
+func TestReportFinish(t *testing.T) {
+        tests := []struct {
+                name   string
+                count  func(r *Report)
+                err    error
+                status string
+        }{
+                {"nothing to do", func(r *Report) {}, nil, StatusSuccess},
+                {"created", func(r *Report) {
+                        r.Count("monitors", OutcomeCreated)
+                }, nil, StatusSuccess},
+                {"some failed", func(r *Report) {
+                        r.Count("monitors", OutcomeCreated)
+                        r.Fail("monitors", 1, "m", errors.New("bad"))
+                }, nil, StatusPartialFailure},
+                {"all failed", func(r *Report) {
+                        r.Fail("monitors", 1, "m", errors.New("bad"))
+                        r.Count("monitors", OutcomeSkipped)
+                }, nil, StatusFailure},
+                {"error", func(r *Report) {
+                        r.Count("monitors", OutcomeCreated)
+                }, errors.New("broken"), StatusFailure},
+                {"interrupted", func(r *Report) {
+                        r.Count("monitors", OutcomeCreated)
+                }, errors.WithMessage(ErrStopped, "push"), StatusPartialFailure},
+                {"pull failed partially", func(r *Report) {
+                        r.Count("dashboards", OutcomePulled)
+                        r.Fail("dashboards", 1, "d", errors.New("bad"))
+                }, nil, StatusPartialFailure},
+                {"all pushed failed before the refresh", func(r *Report) {
+                        r.Fail("monitors", 1, "m", errors.New("bad"))
+                        r.Refresh()
+                        r.Count("monitors", OutcomePulled)
+                        r.Count("monitors", OutcomePulled)
+                }, nil, StatusFailure},
+                {"some pushed failed before the refresh", func(r *Report) {
+                        r.Count("monitors", OutcomeCreated)
+                        r.Fail("monitors", 1, "m", errors.New("bad"))
+                        r.Refresh()
+                        r.Count("monitors", OutcomePulled)
+                }, nil, StatusPartialFailure},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        report := NewReport()
+                        test.count(report)
+                        if status := report.Finish(test.err); status != test.status {
+                                t.Errorf("status = %s, want %s", status, test.status)
+                        }
+                })
+        }
+}
+
+// pullClient returns its remote elements together with the errors of the elements it could not load
+type pullClient struct {
+        *monitorsClient
+        name   string
+        remote []ConfigElement
+        errs   ElementErrors
+}
+
+func (c *pullClient) ConfigClientName() string {
+        return c.name
+}
+
+func (c *pullClient) GetAll(ctx context.Context) (*ConfigElements, error) {
+        elements := &ConfigElements{Elements: c.remote, Delegate: make([]interface{}, len(c.remote))}
+        if len(c.errs) > 0 {
+                return elements, c.errs
+        }
+        return elements, nil
+}
+
+func TestPullKeepsElementsWhichFailedToLoad(t *testing.T) {
+        dir, err := ioutil.TempDir("", "pull")
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer os.RemoveAll(dir)
+        local := "- {name: a, id: 1, delegate: {name: a, query: old-a}}\n- {name: b, id: 2, delegate: {name: b, query: old-b}}\n"
+        if err := ioutil.WriteFile(filepath.Join(dir, "dashboards.yaml"), []byte(local), 0644); err != nil {
+                t.Fatal(err)
+        }
+        storage, err := newLocalStorage(dir)
+        if err != nil {
+                t.Fatal(err)
+        }
+        monitors := func(content string) []ConfigElement {
+                t.Helper()
+                elements, err := (&monitorsClient{}).DecodeFile(strings.NewReader(content))
+                if err != nil {
+                        t.Fatal(err)
+                }
+                return elements
+        }
+        failing := &pullClient{
+                monitorsClient: &monitorsClient{},
+                name:           "dashboards",
+                remote:         monitors("- {name: a, id: 1, delegate: {name: a, query: new-a}}\n"),
+                errs:           ElementErrors{{Id: 2, Name: "b", Err: errors.New("timeout")}},
+        }
+        other := &pullClient{monitorsClient: &monitorsClient{}, name: "downtimes", remote: monitors("- {name: c, id: 3, delegate: {name: c, query: c}}\n")}
+        service := &backupService{
+                log:           logrus.WithField("prefix", "test"),
+                configClients: []DatadogConfigClient{failing, other},
+                filter:        &Filter{},
+                report:        NewReport(),
+                configStorage: storage,
+                stop:          make(chan struct{}),
+        }
+
+        if err := service.Pull(context.Background()); err != nil {
+                t.Fatalf("pull failed: %s, want the failures in the report only", err)
+        }
+        pulled, err := service.readConfigFile(failing)
+        if err != nil {
+                t.Fatal(err)
+        }
+        var queries []string
+        for _, e := range pulled {
+                queries = append(queries, e.(monitorConfigElement).Delegate.GetQuery())
+        }
+        if !reflect.DeepEqual(queries, []string{"new-a", "old-b"}) {
+                t.Errorf("queries = %v, want the new a and the last local version of b", queries)
+        }
+        if exists, err := storage.Exists("downtimes.yaml"); err != nil || !exists {
+                t.Errorf("the types after the failed one were not pulled: %v", err)
+        }
+        if counters := service.report.Types["dashboards"]; counters.Pulled != 1 || counters.Failed != 1 {
+                t.Errorf("counters = %+v, want 1 pulled and 1 failed", counters)
+        }
+        if status := service.report.Finish(nil); status != StatusPartialFailure {
+                t.Errorf("status = %s, want %s", status, StatusPartialFailure)
+        }
+}
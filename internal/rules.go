@@ -0,0 +1,307 @@
+package internal
+
+import (
+        "bytes"
+        "fmt"
+        "github.com/pkg/errors"
+        "github.com/sirupsen/logrus"
+        "io"
+        "io/ioutil"
+        "regexp"
+        "strings"
+)
+
+type Severity string
+
+const (
+        SeverityError   Severity = "error"
+        SeverityWarning Severity = "warning"
+        SeverityInfo    Severity = "info"
+)
+
+// Rule is a policy every element of a config type has to follow. Path is the yaml path of a
+// field below delegate in the config file, e.g. message or options.renotifyinterval, list
+// fields like tags match if any of their entries matches.
+type Rule struct {
+        Name      string    `yaml:"name"`
+        Type      string    `yaml:"type"`
+        Path      string    `yaml:"path"`
+        Condition Condition `yaml:"condition"`
+        // When restricts the rule to the elements matching it
+        When     *RuleWhen `yaml:"when"`
+        Severity Severity  `yaml:"severity"`
+        Message  string    `yaml:"message"`
+}
+
+type RuleWhen struct {
+        Path      string    `yaml:"path"`
+        Condition Condition `yaml:"condition"`
+}
+
+// Condition holds if all of its set checks hold
+type Condition struct {
+        Required    bool   `yaml:"required"`
+        Matches     string `yaml:"matches"`
+        NotMatches  string `yaml:"not_matches"`
+        Contains    string `yaml:"contains"`
+        NotContains string `yaml:"not_contains"`
+
+        matches    *regexp.Regexp
+        notMatches *regexp.Regexp
+}
+
+// LintFinding is a rule violated by an element
+type LintFinding struct {
+        Rule     string   `json:"rule"`
+        Severity Severity `json:"severity"`
+        Type     string   `json:"type"`
+        Id       int      `json:"id"`
+        Name     string   `json:"name"`
+        Message  string   `json:"message"`
+}
+
+func (f LintFinding) String() string {
+        return fmt.Sprintf("%s: %s %d (%s) violates %s: %s", f.Severity, f.Type, f.Id, f.Name, f.Rule, f.Message)
+}
+
+// BuiltinRules are common team conventions for monitors, they are opinionated and only checked
+// if they are enabled
+func BuiltinRules() []Rule {
+        return []Rule{
+                {
+                        Name:      "team-tag",
+                        Type:      "monitors",
+                        Path:      "tags",
+                        Condition: Condition{Matches: "^team:.+"},
+                        Severity:  SeverityError,
+                        Message:   "monitor needs a team: tag",
+                },
+                {
+                        Name:      "notification-handle",
+                        Type:      "monitors",
+                        Path:      "message",
+                        Condition: Condition{Matches: `@[\w.+-]+`},
+                        Severity:  SeverityError,
+                        Message:   "monitor message needs a notification handle",
+                },
+                {
+                        Name:      "critical-renotify",
+                        Type:      "monitors",
+                        Path:      "options.renotifyinterval",
+                        Condition: Condition{Required: true, Matches: "^[1-9]"},
+                        When:      &RuleWhen{Path: "options.thresholds.critical", Condition: Condition{Required: true}},
+                        Severity:  SeverityError,
+                        Message:   "monitor with a critical threshold needs a renotify interval",
+                },
+                {
+                        Name:      "no-here-outside-prod",
+                        Type:      "monitors",
+                        Path:      "message",
+                        Condition: Condition{NotContains: "@here"},
+                        When:      &RuleWhen{Path: "tags", Condition: Condition{NotMatches: "^env:prod(uction)?$"}},
+                        Severity:  SeverityError,
+                        Message:   "@here is only allowed for production monitors",
+                },
+        }
+}
+
+// LoadRules reads custom rules from a yaml file with a list of rules below the key rules
+func LoadRules(file string) ([]Rule, error) {
+        content, err := ioutil.ReadFile(file)
+        if err != nil {
+                return nil, errors.WithMessagef(err, "cannot read rules file %s", file)
+        }
+        var rulesFile struct {
+                Rules []Rule `yaml:"rules"`
+        }
+        // a misspelled field would leave a condition which always holds
+        if err := decodeStrict(bytes.NewReader(content), &rulesFile); err != nil && err != io.EOF {
+                return nil, errors.WithMessagef(err, "cannot decode rules file %s", file)
+        }
+        return rulesFile.Rules, nil
+}
+
+// Linter checks config elements against rules
+type Linter struct {
+        rules []Rule
+}
+
+func NewLinter(rules []Rule) (*Linter, error) {
+        linter := &Linter{}
+        for _, rule := range rules {
+                if rule.Name == "" || rule.Path == "" {
+                        return nil, errors.Errorf("rule %+v needs a name and a path", rule)
+                }
+                if rule.Type == "" {
+                        rule.Type = "monitors"
+                }
+                if rule.Severity == "" {
+                        rule.Severity = SeverityError
+                }
+                if rule.Severity != SeverityError && rule.Severity != SeverityWarning && rule.Severity != SeverityInfo {
+                        return nil, errors.Errorf("rule %s has unknown severity %s", rule.Name, rule.Severity)
+                }
+                if err := rule.Condition.compile(); err != nil {
+                        return nil, errors.WithMessagef(err, "rule %s", rule.Name)
+                }
+                if rule.When != nil {
+                        if err := rule.When.Condition.compile(); err != nil {
+                                return nil, errors.WithMessagef(err, "rule %s", rule.Name)
+                        }
+                }
+                linter.rules = append(linter.rules, rule)
+        }
+        return linter, nil
+}
+
+// Lint returns the rule violations of the elements of the given config type
+func (l *Linter) Lint(configType string, elements []ConfigElement) []LintFinding {
+        var findings []LintFinding
+        for _, e := range elements {
+                fields := FlattenElement(e)
+                for _, rule := range l.rules {
+                        if rule.Type != configType {
+                                continue
+                        }
+                        if rule.When != nil && !rule.When.Condition.holds(fieldValues(fields, rule.When.Path)) {
+                                continue
+                        }
+                        if rule.Condition.holds(fieldValues(fields, rule.Path)) {
+                                continue
+                        }
+                        message := rule.Message
+                        if message == "" {
+                                message = fmt.Sprintf("%s does not match %s", rule.Path, rule.Condition)
+                        }
+                        findings = append(findings, LintFinding{
+                                Rule:     rule.Name,
+                                Severity: rule.Severity,
+                                Type:     configType,
+                                Id:       e.GetId(),
+                                Name:     e.GetName(),
+                                Message:  message,
+                        })
+                }
+        }
+        return findings
+}
+
+// fieldValues returns the value of a path, or all entries if the path is a list
+func fieldValues(fields map[string]string, path string) []string {
+        var values []string
+        if value, ok := fields[path]; ok {
+                values = append(values, value)
+        }
+        for i := 0; ; i++ {
+                value, ok := fields[fmt.Sprintf("%s[%d]", path, i)]
+                if !ok {
+                        break
+                }
+                values = append(values, value)
+        }
+        return values
+}
+
+func (c *Condition) compile() error {
+        var err error
+        if c.Matches != "" {
+                if c.matches, err = regexp.Compile(c.Matches); err != nil {
+                        return errors.WithMessagef(err, "invalid regex %s", c.Matches)
+                }
+        }
+        if c.NotMatches != "" {
+                if c.notMatches, err = regexp.Compile(c.NotMatches); err != nil {
+                        return errors.WithMessagef(err, "invalid regex %s", c.NotMatches)
+                }
+        }
+        return nil
+}
+
+func (c Condition) holds(values []string) bool {
+        if c.Required && len(values) == 0 {
+                return false
+        }
+        if c.matches != nil && !anyValue(values, c.matches.MatchString) {
+                return false
+        }
+        if c.notMatches != nil && anyValue(values, c.notMatches.MatchString) {
+                return false
+        }
+        if c.Contains != "" && !anyValue(values, func(v string) bool { return strings.Contains(v, c.Contains) }) {
+                return false
+        }
+        if c.NotContains != "" && anyValue(values, func(v string) bool { return strings.Contains(v, c.NotContains) }) {
+                return false
+        }
+        return true
+}
+
+func (c Condition) String() string {
+        var parts []string
+        if c.Required {
+                parts = append(parts, "required")
+        }
+        if c.Matches != "" {
+                parts = append(parts, "matches "+c.Matches)
+        }
+        if c.NotMatches != "" {
+                parts = append(parts, "not_matches "+c.NotMatches)
+        }
+        if c.Contains != "" {
+                parts = append(parts, "contains "+c.Contains)
+        }
+        if c.NotContains != "" {
+                parts = append(parts, "not_contains "+c.NotContains)
+        }
+        return strings.Join(parts, ", ")
+}
+
+func anyValue(values []string, predicate func(string) bool) bool {
+        for _, v := range values {
+                if predicate(v) {
+                        return true
+                }
+        }
+        return false
+}
+
+// Lint checks the elements of the config files against the rules
+func (b *backupService) Lint() ([]LintFinding, error) {
+        var findings []LintFinding
+        for _, c := range b.clients() {
+                configElements, err := b.readConfigFileIfExists(c)
+                if err != nil {
+                        return findings, errors.WithMessagef(err, "lint client %s", c.ConfigClientName())
+                }
+                findings = append(findings, b.lint(c.ConfigClientName(), b.filter.Apply(configElements))...)
+        }
+        return findings, nil
+}
+
+func (b *backupService) lint(configType string, configElements []ConfigElement) []LintFinding {
+        logger := b.log.WithFields(logrus.Fields{"client": configType, "action": "lint"})
+        findings := b.linter.Lint(configType, configElements)
+        for _, finding := range findings {
+                logger := logger.WithFields(logrus.Fields{"element_id": finding.Id, "element_name": finding.Name})
+                switch finding.Severity {
+                case SeverityError:
+                        logger.Error(finding.String())
+                case SeverityWarning:
+                        logger.Warn(finding.String())
+                default:
+                        logger.Info(finding.String())
+                }
+        }
+        return findings
+}
+
+// CountErrors returns the number of findings with severity error
+func CountErrors(findings []LintFinding) int {
+        count := 0
+        for _, finding := range findings {
+                if finding.Severity == SeverityError {
+                        count++
+                }
+        }
+        return count
+}
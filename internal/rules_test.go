@@ -0,0 +1,223 @@
+package internal
+
+import (
+        "io/ioutil"
+        "os"
+        "path/filepath"
+        "reflect"
+        "strings"
+        "testing"
+)
+
+// testMonitors decodes one monitor per yaml delegate, named m0, m1, ...
+func testMonitors(t *testing.T, delegates ...string) []ConfigElement {
+        t.Helper()
+        var content strings.Builder
+        for i, delegate := range delegates {
+                content.WriteString("- name: m" + string(rune('0'+i)) + "\n  id: " + string(rune('1'+i)) + "\n  delegate:\n")
+                for _, line := range strings.Split(strings.TrimSpace(delegate), "\n") {
+                        content.WriteString("    " + line + "\n")
+                }
+        }
+        elements, err := (&monitorsClient{}).DecodeFile(strings.NewReader(content.String()))
+        if err != nil {
+                t.Fatalf("cannot decode monitors %s: %s", content.String(), err)
+        }
+        return elements
+}
+
+func lintedRules(findings []LintFinding) []string {
+        var rules []string
+        for _, finding := range findings {
+                rules = append(rules, finding.Name+":"+finding.Rule)
+        }
+        return rules
+}
+
+func TestLinterConditions(t *testing.T) {
+        tests := []struct {
+                name     string
+                rule     Rule
+                monitors []string
+                want     []string
+        }{
+                {
+                        name:     "required field",
+                        rule:     Rule{Name: "r", Path: "options.renotifyinterval", Condition: Condition{Required: true}},
+                        monitors: []string{"options: {renotifyinterval: 10}", "options: {}", "name: x"},
+                        want:     []string{"m1:r", "m2:r"},
+                },
+                {
+                        name:     "matches a scalar",
+                        rule:     Rule{Name: "r", Path: "message", Condition: Condition{Matches: "@team-"}},
+                        monitors: []string{"message: cpu @team-a", "message: cpu", "name: no message"},
+                        want:     []string{"m1:r", "m2:r"},
+                },
+                {
+                        name:     "any entry of a list matches",
+                        rule:     Rule{Name: "r", Path: "tags", Condition: Condition{Matches: "^team:"}},
+                        monitors: []string{"tags: [env:prod, team:a]", "tags: [env:prod]", "tags: []"},
+                        want:     []string{"m1:r", "m2:r"},
+                },
+                {
+                        name:     "no entry of a list matches",
+                        rule:     Rule{Name: "r", Path: "tags", Condition: Condition{NotMatches: "^deprecated"}},
+                        monitors: []string{"tags: [env:prod]", "tags: [env:prod, deprecated]", "tags: []"},
+                        want:     []string{"m1:r"},
+                },
+                {
+                        name:     "contains and not contains",
+                        rule:     Rule{Name: "r", Path: "message", Condition: Condition{Contains: "runbook", NotContains: "TODO"}},
+                        monitors: []string{"message: see runbook", "message: see runbook TODO", "message: nothing"},
+                        want:     []string{"m1:r", "m2:r"},
+                },
+                {
+                        name: "when restricts the rule",
+                        rule: Rule{Name: "r", Path: "message", Condition: Condition{NotContains: "@here"},
+                                When: &RuleWhen{Path: "tags", Condition: Condition{Matches: "^env:dev$"}}},
+                        monitors: []string{"{message: '@here', tags: [env:dev]}", "{message: '@here', tags: [env:prod]}", "{message: '@here'}"},
+                        want:     []string{"m0:r"},
+                },
+                {
+                        name:     "other config types are not linted",
+                        rule:     Rule{Name: "r", Type: "dashboards", Path: "title", Condition: Condition{Required: true}},
+                        monitors: []string{"name: x"},
+                },
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        linter, err := NewLinter([]Rule{test.rule})
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        findings := linter.Lint("monitors", testMonitors(t, test.monitors...))
+                        if got := lintedRules(findings); !reflect.DeepEqual(got, test.want) {
+                                t.Errorf("findings = %v, want %v", got, test.want)
+                        }
+                        for _, finding := range findings {
+                                if finding.Severity != SeverityError || finding.Message == "" {
+                                        t.Errorf("finding %+v has no default severity or message", finding)
+                                }
+                        }
+                })
+        }
+}
+
+func TestBuiltinRules(t *testing.T) {
+        linter, err := NewLinter(BuiltinRules())
+        if err != nil {
+                t.Fatal(err)
+        }
+        valid := `
+message: cpu is high @team-a
+tags: [team:a, env:prod]
+options: {renotifyinterval: 30, thresholds: {critical: 1}}`
+        tests := []struct {
+                name    string
+                monitor string
+                want    []string
+        }{
+                {"valid", valid, nil},
+                {"no team tag", "{message: '@team-a', tags: [env:prod]}", []string{"m0:team-tag"}},
+                {"no notification handle", "{message: cpu is high, tags: [team:a]}", []string{"m0:notification-handle"}},
+                {"critical without renotify", "{message: '@a', tags: [team:a], options: {thresholds: {critical: 1}}}", []string{"m0:critical-renotify"}},
+                {"critical with disabled renotify", "{message: '@a', tags: [team:a], options: {renotifyinterval: 0, thresholds: {critical: 1}}}", []string{"m0:critical-renotify"}},
+                {"no critical threshold", "{message: '@a', tags: [team:a], options: {renotifyinterval: 0}}", nil},
+                {"here outside prod", "{message: '@here', tags: [team:a, env:dev]}", []string{"m0:no-here-outside-prod"}},
+                {"here in production", "{message: '@here', tags: [team:a, env:production]}", nil},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        findings := linter.Lint("monitors", testMonitors(t, test.monitor))
+                        if got := lintedRules(findings); !reflect.DeepEqual(got, test.want) {
+                                t.Errorf("findings = %v, want %v", got, test.want)
+                        }
+                })
+        }
+}
+
+func TestNewLinterRejectsInvalidRules(t *testing.T) {
+        tests := map[string]Rule{
+                "no name":          {Path: "message"},
+                "no path":          {Name: "r"},
+                "unknown severity": {Name: "r", Path: "message", Severity: "fatal"},
+                "invalid regex":    {Name: "r", Path: "message", Condition: Condition{Matches: "("}},
+                "invalid when":     {Name: "r", Path: "message", When: &RuleWhen{Path: "tags", Condition: Condition{NotMatches: "["}}},
+        }
+        for name, rule := range tests {
+                if _, err := NewLinter([]Rule{rule}); err == nil {
+                        t.Errorf("%s: expected an error", name)
+                }
+        }
+}
+
+func TestLoadRules(t *testing.T) {
+        dir, err := ioutil.TempDir("", "rules")
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer os.RemoveAll(dir)
+        file := filepath.Join(dir, "rules.yaml")
+        content := `
+rules:
+  - name: runbook
+    path: message
+    severity: warning
+    condition:
+      contains: runbook
+    when:
+      path: tags
+      condition:
+        matches: ^env:prod$
+`
+        if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+                t.Fatal(err)
+        }
+        rules, err := LoadRules(file)
+        if err != nil {
+                t.Fatal(err)
+        }
+        linter, err := NewLinter(rules)
+        if err != nil {
+                t.Fatal(err)
+        }
+        findings := linter.Lint("monitors", testMonitors(t, "{message: x, tags: [env:prod]}", "{message: x}"))
+        if len(findings) != 1 || findings[0].Name != "m0" || findings[0].Severity != SeverityWarning {
+                t.Errorf("findings = %+v, want a warning for m0", findings)
+        }
+}
+
+func TestLoadRulesRejectsUnknownFields(t *testing.T) {
+        dir, err := ioutil.TempDir("", "rules")
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer os.RemoveAll(dir)
+        tests := []struct {
+                name    string
+                content string
+                wantErr string
+        }{
+                {"misspelled condition", "rules:\n  - name: r\n    path: name\n    condition:\n      not_match: x\n", "field not_match not found"},
+                {"misspelled rule field", "rules:\n  - name: r\n    paths: name\n", "field paths not found"},
+                {"empty file", "", ""},
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        file := filepath.Join(dir, "rules.yaml")
+                        if err := ioutil.WriteFile(file, []byte(test.content), 0644); err != nil {
+                                t.Fatal(err)
+                        }
+                        _, err := LoadRules(file)
+                        if test.wantErr == "" {
+                                if err != nil {
+                                        t.Errorf("error = %v, want none", err)
+                                }
+                                return
+                        }
+                        if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+                                t.Errorf("error = %v, want %q", err, test.wantErr)
+                        }
+                })
+        }
+}
@@ -0,0 +1,318 @@
+package internal
+
+import (
+        "bytes"
+        "context"
+        "crypto/hmac"
+        "crypto/sha256"
+        "encoding/hex"
+        "encoding/xml"
+        "fmt"
+        "github.com/pkg/errors"
+        "io"
+        "io/ioutil"
+        "net/http"
+        "net/url"
+        "os"
+        "sort"
+        "strings"
+        "time"
+)
+
+// s3Storage talks to any S3 compatible object storage (AWS S3, MinIO, ...) using path style
+// requests signed with AWS signature version 4. Credentials are taken from the standard
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.
+type s3Storage struct {
+        ctx          context.Context
+        httpClient   *http.Client
+        endpoint     *url.URL
+        region       string
+        bucket       string
+        prefix       string
+        accessKey    string
+        secretKey    string
+        sessionToken string
+}
+
+func newS3Storage(location string, config StorageConfig) (Storage, error) {
+        u, err := url.Parse(location)
+        if err != nil {
+                return nil, errors.WithMessagef(err, "cannot parse s3 location %s", location)
+        }
+        if u.Host == "" {
+                return nil, errors.Errorf("s3 location %s has no bucket", location)
+        }
+        region := config.S3Region
+        if region == "" {
+                region = "us-east-1"
+        }
+        endpoint := config.S3Endpoint
+        if endpoint == "" {
+                endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+        }
+        endpointUrl, err := url.Parse(endpoint)
+        if err != nil {
+                return nil, errors.WithMessagef(err, "cannot parse s3 endpoint %s", endpoint)
+        }
+        ctx := config.Context
+        if ctx == nil {
+                ctx = context.Background()
+        }
+        s := &s3Storage{
+                ctx:          ctx,
+                httpClient:   &http.Client{Timeout: config.RequestTimeout},
+                endpoint:     endpointUrl,
+                region:       region,
+                bucket:       u.Host,
+                prefix:       strings.Trim(u.Path, "/"),
+                accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+                secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+                sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+        }
+        if s.accessKey == "" || s.secretKey == "" {
+                return nil, errors.Errorf("s3 storage %s: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set", location)
+        }
+        return s, nil
+}
+
+func (s *s3Storage) Location(name string) string {
+        return "s3://" + s.bucket + "/" + s.key(name)
+}
+
+func (s *s3Storage) Exists(name string) (bool, error) {
+        resp, err := s.do("HEAD", s.key(name), nil, nil)
+        if err != nil {
+                return false, errors.WithMessagef(err, "cannot stat object %s", s.Location(name))
+        }
+        defer closeQuietly(resp.Body)
+        switch {
+        case resp.StatusCode == http.StatusNotFound:
+                return false, nil
+        case resp.StatusCode >= 300:
+                return false, errors.Errorf("cannot stat object %s: %s", s.Location(name), resp.Status)
+        }
+        return true, nil
+}
+
+func (s *s3Storage) Read(name string) (io.ReadCloser, error) {
+        resp, err := s.do("GET", s.key(name), nil, nil)
+        if err != nil {
+                return nil, errors.WithMessagef(err, "cannot read object %s", s.Location(name))
+        }
+        if resp.StatusCode >= 300 {
+                defer closeQuietly(resp.Body)
+                return nil, errors.Errorf("cannot read object %s: %s", s.Location(name), responseMessage(resp))
+        }
+        return resp.Body, nil
+}
+
+func (s *s3Storage) Write(name string) (io.WriteCloser, error) {
+        return &s3Writer{storage: s, name: name}, nil
+}
+
+func (s *s3Storage) List() ([]string, error) {
+        var names []string
+        prefix := s.key("")
+        continuationToken := ""
+        for {
+                query := url.Values{}
+                query.Set("list-type", "2")
+                query.Set("prefix", prefix)
+                if continuationToken != "" {
+                        query.Set("continuation-token", continuationToken)
+                }
+                resp, err := s.do("GET", "", query, nil)
+                if err != nil {
+                        return nil, errors.WithMessagef(err, "cannot list objects in %s", s.Location(""))
+                }
+                if resp.StatusCode >= 300 {
+                        msg := responseMessage(resp)
+                        closeQuietly(resp.Body)
+                        return nil, errors.Errorf("cannot list objects in %s: %s", s.Location(""), msg)
+                }
+                var result s3ListBucketResult
+                err = xml.NewDecoder(resp.Body).Decode(&result)
+                closeQuietly(resp.Body)
+                if err != nil {
+                        return nil, errors.WithMessagef(err, "cannot decode object list of %s", s.Location(""))
+                }
+                for _, content := range result.Contents {
+                        name := strings.TrimPrefix(content.Key, prefix)
+                        if name != "" && !strings.Contains(name, "/") {
+                                names = append(names, name)
+                        }
+                }
+                if !result.IsTruncated || result.NextContinuationToken == "" {
+                        break
+                }
+                continuationToken = result.NextContinuationToken
+        }
+        sort.Strings(names)
+        return names, nil
+}
+
+func (s *s3Storage) key(name string) string {
+        if s.prefix == "" {
+                return name
+        }
+        return s.prefix + "/" + name
+}
+
+func (s *s3Storage) put(name string, body []byte) error {
+        resp, err := s.do("PUT", s.key(name), nil, body)
+        if err != nil {
+                return errors.WithMessagef(err, "cannot write object %s", s.Location(name))
+        }
+        defer closeQuietly(resp.Body)
+        if resp.StatusCode >= 300 {
+                return errors.Errorf("cannot write object %s: %s", s.Location(name), responseMessage(resp))
+        }
+        return nil
+}
+
+func (s *s3Storage) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+        u := *s.endpoint
+        u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket
+        if key != "" {
+                u.Path += "/" + key
+        }
+        u.RawPath = s3EscapePath(u.Path)
+        u.RawQuery = s3CanonicalQuery(query)
+
+        req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+        if err != nil {
+                return nil, err
+        }
+        req = req.WithContext(s.ctx)
+        req.ContentLength = int64(len(body))
+        s.sign(req, body, time.Now().UTC())
+        return s.httpClient.Do(req)
+}
+
+// sign adds an AWS signature version 4 authorization header to the request
+func (s *s3Storage) sign(req *http.Request, body []byte, now time.Time) {
+        amzDate := now.Format("20060102T150405Z")
+        date := now.Format("20060102")
+        payloadHash := sha256Hex(body)
+
+        req.Header.Set("Host", req.URL.Host)
+        req.Header.Set("X-Amz-Date", amzDate)
+        req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+        if s.sessionToken != "" {
+                req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+        }
+
+        var headerNames []string
+        for name := range req.Header {
+                headerNames = append(headerNames, strings.ToLower(name))
+        }
+        sort.Strings(headerNames)
+        var canonicalHeaders strings.Builder
+        for _, name := range headerNames {
+                canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+        }
+        signedHeaders := strings.Join(headerNames, ";")
+
+        canonicalRequest := strings.Join([]string{
+                req.Method,
+                s3EscapePath(req.URL.Path),
+                req.URL.RawQuery,
+                canonicalHeaders.String(),
+                signedHeaders,
+                payloadHash,
+        }, "\n")
+
+        scope := date + "/" + s.region + "/s3/aws4_request"
+        stringToSign := strings.Join([]string{
+                "AWS4-HMAC-SHA256",
+                amzDate,
+                scope,
+                sha256Hex([]byte(canonicalRequest)),
+        }, "\n")
+
+        key := hmacSha256([]byte("AWS4"+s.secretKey), date)
+        key = hmacSha256(key, s.region)
+        key = hmacSha256(key, "s3")
+        key = hmacSha256(key, "aws4_request")
+        signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+        req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+                s.accessKey, scope, signedHeaders, signature))
+}
+
+type s3Writer struct {
+        storage *s3Storage
+        name    string
+        buffer  bytes.Buffer
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+        return w.buffer.Write(p)
+}
+
+func (w *s3Writer) Close() error {
+        return w.storage.put(w.name, w.buffer.Bytes())
+}
+
+type s3ListBucketResult struct {
+        Contents []struct {
+                Key string `xml:"Key"`
+        } `xml:"Contents"`
+        IsTruncated           bool   `xml:"IsTruncated"`
+        NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func s3CanonicalQuery(query url.Values) string {
+        if len(query) == 0 {
+                return ""
+        }
+        keys := make([]string, 0, len(query))
+        for k := range query {
+                keys = append(keys, k)
+        }
+        sort.Strings(keys)
+        parts := make([]string, 0, len(keys))
+        for _, k := range keys {
+                parts = append(parts, s3Escape(k, true)+"="+s3Escape(query.Get(k), true))
+        }
+        return strings.Join(parts, "&")
+}
+
+func s3EscapePath(path string) string {
+        return s3Escape(path, false)
+}
+
+// s3Escape uri encodes every byte except the unreserved characters of RFC 3986, slashes are
+// kept as they are unless encodeSlash is set
+func s3Escape(s string, encodeSlash bool) string {
+        var b strings.Builder
+        for i := 0; i < len(s); i++ {
+                c := s[i]
+                switch {
+                case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+                        c == '-', c == '_', c == '.', c == '~':
+                        b.WriteByte(c)
+                case c == '/' && !encodeSlash:
+                        b.WriteByte(c)
+                default:
+                        b.WriteString(fmt.Sprintf("%%%02X", c))
+                }
+        }
+        return b.String()
+}
+
+func sha256Hex(data []byte) string {
+        sum := sha256.Sum256(data)
+        return hex.EncodeToString(sum[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+        mac := hmac.New(sha256.New, key)
+        _, _ = mac.Write([]byte(data))
+        return mac.Sum(nil)
+}
+
+func responseMessage(resp *http.Response) string {
+        body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+        return strings.TrimSpace(resp.Status + " " + string(body))
+}
@@ -0,0 +1,149 @@
+package internal
+
+import (
+        "github.com/pkg/errors"
+        "strconv"
+        "strings"
+        "time"
+)
+
+// Schedule returns the next time a job runs after the given time
+type Schedule interface {
+        Next(after time.Time) time.Time
+}
+
+// ParseSchedule parses an interval like 15m or a cron expression with the five fields minute,
+// hour, day of month, month and day of week, e.g. */15 * * * 1-5
+func ParseSchedule(spec string) (Schedule, error) {
+        spec = strings.TrimSpace(spec)
+        if interval, err := time.ParseDuration(spec); err == nil {
+                if interval <= 0 {
+                        return nil, errors.Errorf("interval %s has to be positive", spec)
+                }
+                return intervalSchedule(interval), nil
+        }
+        cron, err := parseCron(spec)
+        if err != nil {
+                return nil, err
+        }
+        if cron.Next(time.Now()).IsZero() {
+                return nil, errors.Errorf("cron expression %q never matches", spec)
+        }
+        return cron, nil
+}
+
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) Next(after time.Time) time.Time {
+        return after.Add(time.Duration(s))
+}
+
+type cronSchedule struct {
+        minutes, hours, days, months, weekDays map[int]bool
+        // if both days and week days are restricted, a time matches if any of them matches
+        anyDay, anyWeekDay bool
+}
+
+// maxCronSteps bounds the search for the next time of a cron expression which never matches,
+// like 0 0 31 2 *
+const maxCronSteps = 100000
+
+func parseCron(spec string) (*cronSchedule, error) {
+        fields := strings.Fields(spec)
+        if len(fields) != 5 {
+                return nil, errors.Errorf("schedule %q is neither an interval nor a cron expression with 5 fields", spec)
+        }
+        s := &cronSchedule{anyDay: fields[2] == "*", anyWeekDay: fields[4] == "*"}
+        var err error
+        if s.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+                return nil, errors.WithMessage(err, "minute")
+        }
+        if s.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+                return nil, errors.WithMessage(err, "hour")
+        }
+        if s.days, err = parseCronField(fields[2], 1, 31); err != nil {
+                return nil, errors.WithMessage(err, "day of month")
+        }
+        if s.months, err = parseCronField(fields[3], 1, 12); err != nil {
+                return nil, errors.WithMessage(err, "month")
+        }
+        if s.weekDays, err = parseCronField(fields[4], 0, 7); err != nil {
+                return nil, errors.WithMessage(err, "day of week")
+        }
+        // 7 is sunday as well
+        if s.weekDays[7] {
+                s.weekDays[0] = true
+        }
+        return s, nil
+}
+
+// parseCronField parses a comma separated list of *, single values and ranges, each with an
+// optional step like */5 or 1-10/2
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+        values := map[int]bool{}
+        for _, part := range strings.Split(field, ",") {
+                step := 1
+                if i := strings.Index(part, "/"); i >= 0 {
+                        var err error
+                        if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+                                return nil, errors.Errorf("invalid step in %q", part)
+                        }
+                        part = part[:i]
+                }
+                from, to := min, max
+                switch {
+                case part == "*":
+                case strings.Contains(part, "-"):
+                        bounds := strings.SplitN(part, "-", 2)
+                        var err1, err2 error
+                        from, err1 = strconv.Atoi(bounds[0])
+                        to, err2 = strconv.Atoi(bounds[1])
+                        if err1 != nil || err2 != nil {
+                                return nil, errors.Errorf("invalid range %q", part)
+                        }
+                default:
+                        value, err := strconv.Atoi(part)
+                        if err != nil {
+                                return nil, errors.Errorf("invalid value %q", part)
+                        }
+                        from, to = value, value
+                        if step > 1 {
+                                to = max
+                        }
+                }
+                if from < min || to > max || from > to {
+                        return nil, errors.Errorf("%q is out of range %d-%d", part, min, max)
+                }
+                for v := from; v <= to; v += step {
+                        values[v] = true
+                }
+        }
+        return values, nil
+}
+
+func (s *cronSchedule) Next(after time.Time) time.Time {
+        t := after.Truncate(time.Minute).Add(time.Minute)
+        for i := 0; i < maxCronSteps; i++ {
+                switch {
+                case !s.months[int(t.Month())]:
+                        t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+                case !s.dayMatches(t):
+                        t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+                case !s.hours[t.Hour()]:
+                        t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+                case !s.minutes[t.Minute()]:
+                        t = t.Add(time.Minute)
+                default:
+                        return t
+                }
+        }
+        return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+        day, weekDay := s.days[t.Day()], s.weekDays[int(t.Weekday())]
+        if !s.anyDay && !s.anyWeekDay {
+                return day || weekDay
+        }
+        return day && weekDay
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+        "testing"
+        "time"
+)
+
+func TestScheduleNext(t *testing.T) {
+        // 2024-01-01 is a monday
+        date := func(month time.Month, day, hour, min int) time.Time {
+                return time.Date(2024, month, day, hour, min, 0, 0, time.UTC)
+        }
+        tests := []struct {
+                spec  string
+                after time.Time
+                want  time.Time
+        }{
+                {"15m", date(1, 1, 10, 7), date(1, 1, 10, 22)},
+                {" 1h ", date(1, 1, 10, 7), date(1, 1, 11, 7)},
+                {"*/15 * * * *", date(1, 1, 10, 7), date(1, 1, 10, 15)},
+                {"*/15 * * * *", date(1, 1, 10, 15), date(1, 1, 10, 30)},
+                {"*/15 * * * *", date(1, 1, 10, 14).Add(30 * time.Second), date(1, 1, 10, 15)},
+                {"*/15 * * * *", date(12, 31, 23, 59), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+                {"0 9 * * 1-5", date(1, 5, 10, 0), date(1, 8, 9, 0)},
+                {"0 9 * * 1-5", date(1, 8, 8, 0), date(1, 8, 9, 0)},
+                {"30 2 1 * *", date(1, 1, 3, 0), date(2, 1, 2, 30)},
+                {"0 0 * * 0", date(1, 1, 0, 0), date(1, 7, 0, 0)},
+                {"0 0 * * 7", date(1, 1, 0, 0), date(1, 7, 0, 0)},
+                {"0 0 13 * 5", date(1, 1, 0, 0), date(1, 5, 0, 0)},
+                {"0 0 13 * 5", date(1, 12, 0, 0), date(1, 13, 0, 0)},
+                {"0 0 29 2 *", date(3, 1, 0, 0), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+                {"5,10-12/2 * * * *", date(1, 1, 10, 5), date(1, 1, 10, 10)},
+                {"5,10-12/2 * * * *", date(1, 1, 10, 10), date(1, 1, 10, 12)},
+                {"5,10-12/2 * * * *", date(1, 1, 10, 12), date(1, 1, 11, 5)},
+                {"5/20 * * * *", date(1, 1, 10, 25), date(1, 1, 10, 45)},
+                {"0 0 1 */3 *", date(2, 1, 0, 0), date(4, 1, 0, 0)},
+        }
+        for _, test := range tests {
+                schedule, err := ParseSchedule(test.spec)
+                if err != nil {
+                        t.Errorf("ParseSchedule(%q): %s", test.spec, err)
+                        continue
+                }
+                if got := schedule.Next(test.after); !got.Equal(test.want) {
+                        t.Errorf("%q: next after %s = %s, want %s", test.spec, test.after, got, test.want)
+                }
+        }
+}
+
+func TestParseScheduleRejectsInvalidSpecs(t *testing.T) {
+        for _, spec := range []string{
+                "",
+                "0s",
+                "-5m",
+                "* * * *",
+                "* * * * * *",
+                "60 * * * *",
+                "* 24 * * *",
+                "* * 0 * *",
+                "* * * 13 *",
+                "* * * * 8",
+                "*/0 * * * *",
+                "a * * * *",
+                "1-x * * * *",
+                "5-1 * * * *",
+                "0 0 31 2 *",
+        } {
+                if _, err := ParseSchedule(spec); err == nil {
+                        t.Errorf("ParseSchedule(%q): expected an error", spec)
+                }
+        }
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+        "github.com/pkg/errors"
+        "regexp"
+        "sort"
+        "strconv"
+        "time"
+)
+
+// CurrentSnapshot names the config files, the other snapshots are the backup files written
+// before every pull
+const CurrentSnapshot = "current"
+
+var backupFileRegex = regexp.MustCompile(`^(\d+)_([a-z]+)\.yaml$`)
+
+// Snapshot is the state of the config files at a point in time, identified by the unix time of
+// its backup files. The backup files are written right before a pull replaces the config files,
+// so they hold what the pull before wrote: the content of a snapshot is from Pulled, not Time.
+type Snapshot struct {
+        Id string `json:"id"`
+        // Time is when the backup files were written and their content was replaced
+        Time time.Time `json:"time"`
+        // Pulled is when the content was written, the time of the previous snapshot of its types.
+        // It is unknown for the oldest snapshot.
+        Pulled *time.Time `json:"pulled,omitempty"`
+        Types  []string   `json:"types"`
+}
+
+// Snapshots returns the snapshots in the backup dir, oldest first
+func (b *backupService) Snapshots() ([]Snapshot, error) {
+        if b.backupStorage == nil {
+                return nil, errors.New("snapshots: backups are disabled")
+        }
+        names, err := b.backupStorage.List()
+        if err != nil {
+                return nil, errors.WithMessage(err, "snapshots")
+        }
+        byId := map[string]*Snapshot{}
+        var ids []string
+        for _, name := range names {
+                match := backupFileRegex.FindStringSubmatch(name)
+                if match == nil {
+                        continue
+                }
+                snapshot, ok := byId[match[1]]
+                if !ok {
+                        unix, err := strconv.ParseInt(match[1], 10, 64)
+                        if err != nil {
+                                continue
+                        }
+                        snapshot = &Snapshot{Id: match[1], Time: time.Unix(unix, 0).UTC()}
+                        byId[match[1]] = snapshot
+                        ids = append(ids, match[1])
+                }
+                snapshot.Types = append(snapshot.Types, match[2])
+        }
+        sort.Slice(ids, func(i, j int) bool {
+                return byId[ids[i]].Time.Before(byId[ids[j]].Time)
+        })
+        result := make([]Snapshot, 0, len(ids))
+        replaced := map[string]time.Time{}
+        for _, id := range ids {
+                snapshot := byId[id]
+                sort.Strings(snapshot.Types)
+                for _, configType := range snapshot.Types {
+                        if previous, ok := replaced[configType]; ok && (snapshot.Pulled == nil || previous.After(*snapshot.Pulled)) {
+                                pulled := previous
+                                snapshot.Pulled = &pulled
+                        }
+                        replaced[configType] = snapshot.Time
+                }
+                result = append(result, *snapshot)
+        }
+        return result, nil
+}
+
+// SnapshotElements returns the elements of a config type in a snapshot, CurrentSnapshot reads
+// the config file, which has no elements if it does not exist yet
+func (b *backupService) SnapshotElements(snapshot, configType string) ([]ConfigElement, error) {
+        client := b.client(configType)
+        if client == nil {
+                return nil, errors.Errorf("snapshot: unknown config type %s", configType)
+        }
+        if snapshot == CurrentSnapshot {
+                return b.readConfigFileIfExists(client)
+        }
+        if b.backupStorage == nil {
+                return nil, errors.New("snapshot: backups are disabled")
+        }
+        if _, err := strconv.ParseInt(snapshot, 10, 64); err != nil {
+                return nil, errors.Errorf("snapshot: invalid snapshot %s", snapshot)
+        }
+        name := snapshot + "_" + configType + ".yaml"
+        reader, err := b.backupStorage.Read(name)
+        if err != nil {
+                return nil, errors.WithMessagef(err, "snapshot %s", snapshot)
+        }
+        defer closeQuietly(reader)
+        elements, err := client.DecodeFile(reader)
+        if err != nil {
+                return nil, errors.WithMessagef(err, "snapshot: cannot decode %s", b.backupStorage.Location(name))
+        }
+        return elements, nil
+}
+
+// SnapshotElement returns the element with the given id or name in a snapshot, nil if there is none
+func (b *backupService) SnapshotElement(snapshot, configType, idOrName string) (ConfigElement, error) {
+        elements, err := b.SnapshotElements(snapshot, configType)
+        if err != nil {
+                return nil, err
+        }
+        return findElement(elements, idOrName), nil
+}
+
+// DiffSnapshots compares the elements of a config type in two snapshots, created elements only
+// exist in the second one, deleted elements only in the first one
+func (b *backupService) DiffSnapshots(configType, from, to string) ([]ElementDiff, error) {
+        fromElements, err := b.SnapshotElements(from, configType)
+        if err != nil {
+                return nil, err
+        }
+        toElements, err := b.SnapshotElements(to, configType)
+        if err != nil {
+                return nil, err
+        }
+        return DiffElements(configType, fromElements, toElements), nil
+}
+
+// client returns the config client of a type
+func (b *backupService) client(configType string) DatadogConfigClient {
+        for _, c := range b.configClients {
+                if c.ConfigClientName() == configType {
+                        return c
+                }
+        }
+        return nil
+}
+
+// findElement returns the element with the id, or else the first element with the name
+func findElement(elements []ConfigElement, idOrName string) ConfigElement {
+        if id, err := strconv.Atoi(idOrName); err == nil {
+                for _, e := range elements {
+                        if e.GetId() == id {
+                                return e
+                        }
+                }
+        }
+        for _, e := range elements {
+                if e.GetName() == idOrName {
+                        return e
+                }
+        }
+        return nil
+}
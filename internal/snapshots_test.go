@@ -0,0 +1,72 @@
+package internal
+
+import (
+        "reflect"
+        "testing"
+        "time"
+)
+
+func TestSnapshots(t *testing.T) {
+        service := snapshotService(t, map[string]string{
+                "100_monitors.yaml":         "[]",
+                "100_dashboards.yaml":       "[]",
+                "200_monitors.yaml":         "[]",
+                "300_monitors.yaml":         "[]",
+                "300_dashboards.yaml":       "[]",
+                "400_deleted-monitors.yaml": "[]",
+                "journal-500.yaml":          "{}",
+                "notes.txt":                 "",
+        }, nil)
+        snapshots, err := service.Snapshots()
+        if err != nil {
+                t.Fatal(err)
+        }
+        unix := func(seconds int64) *time.Time {
+                t := time.Unix(seconds, 0).UTC()
+                return &t
+        }
+        want := []Snapshot{
+                {Id: "100", Time: *unix(100), Types: []string{"dashboards", "monitors"}},
+                {Id: "200", Time: *unix(200), Pulled: unix(100), Types: []string{"monitors"}},
+                // the dashboards were last replaced at 100, the monitors at 200
+                {Id: "300", Time: *unix(300), Pulled: unix(200), Types: []string{"dashboards", "monitors"}},
+        }
+        if !reflect.DeepEqual(snapshots, want) {
+                t.Errorf("snapshots = %+v, want %+v", snapshots, want)
+        }
+}
+
+func TestDiffSnapshots(t *testing.T) {
+        service := snapshotService(t, map[string]string{
+                "100_monitors.yaml": monitorsFile(
+                        "{name: a, id: 1, delegate: {name: a, type: metric alert, query: q}}",
+                        "{name: b, id: 2, delegate: {name: b, type: metric alert, query: q}}",
+                ),
+        }, map[string]string{
+                "monitors.yaml": monitorsFile(
+                        "{name: a, id: 1, delegate: {name: a, type: metric alert, query: changed}}",
+                        "{name: c, id: 3, delegate: {name: c, type: metric alert, query: q}}",
+                ),
+        })
+        diffs, err := service.DiffSnapshots("monitors", "100", CurrentSnapshot)
+        if err != nil {
+                t.Fatal(err)
+        }
+        var got []string
+        for _, diff := range diffs {
+                got = append(got, diff.Name+":"+string(diff.Kind))
+        }
+        want := []string{"a:" + string(DiffChanged), "b:" + string(DiffDeleted), "c:" + string(DiffCreated)}
+        if !reflect.DeepEqual(got, want) {
+                t.Errorf("diffs = %v, want %v", got, want)
+        }
+
+        for _, snapshot := range []string{"200", "latest", "../100"} {
+                if _, err := service.DiffSnapshots("monitors", snapshot, CurrentSnapshot); err == nil {
+                        t.Errorf("snapshot %s: expected an error", snapshot)
+                }
+        }
+        if element, err := service.SnapshotElement("100", "monitors", "b"); err != nil || element == nil || element.GetId() != 2 {
+                t.Errorf("element b of snapshot 100 = %v, %v, want monitor 2", element, err)
+        }
+}
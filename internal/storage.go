@@ -0,0 +1,92 @@
+package internal
+
+import (
+        "context"
+        "github.com/pkg/errors"
+        "io"
+        "io/ioutil"
+        "os"
+        "path/filepath"
+        "sort"
+        "strings"
+        "time"
+)
+
+// Storage abstracts the place config and backup files are read from and written to.
+type Storage interface {
+        // Location returns a human readable location of the named file, used in logs and errors
+        Location(name string) string
+        Exists(name string) (bool, error)
+        Read(name string) (io.ReadCloser, error)
+        // Write truncates the named file, the content is persisted when the writer is closed
+        Write(name string) (io.WriteCloser, error)
+        // List returns the names of all files in the storage, sorted by name
+        List() ([]string, error)
+}
+
+type StorageConfig struct {
+        S3Endpoint string
+        S3Region   string
+        // Context aborts requests to remote storages when the run is cancelled, the background
+        // context is used if it is nil
+        Context context.Context
+        // RequestTimeout limits a single request to a remote storage, no limit if it is 0
+        RequestTimeout time.Duration
+}
+
+// NewStorage returns a storage for the given location, which is either a local directory
+// or an s3 url in the form s3://bucket/prefix
+func NewStorage(location string, config StorageConfig) (Storage, error) {
+        if strings.HasPrefix(location, "s3://") {
+                return newS3Storage(location, config)
+        }
+        return newLocalStorage(location)
+}
+
+type localStorage struct {
+        dir string
+}
+
+func newLocalStorage(dir string) (Storage, error) {
+        if _, err := os.Stat(dir); err != nil {
+                return nil, errors.WithMessagef(err, "local storage %s", dir)
+        }
+        return &localStorage{dir: dir}, nil
+}
+
+func (l *localStorage) Location(name string) string {
+        return l.dir + "/" + name
+}
+
+func (l *localStorage) Exists(name string) (bool, error) {
+        _, err := os.Stat(l.Location(name))
+        if os.IsNotExist(err) {
+                return false, nil
+        }
+        return err == nil, errors.WithMessagef(err, "cannot stat file %s", l.Location(name))
+}
+
+func (l *localStorage) Read(name string) (io.ReadCloser, error) {
+        file, err := os.OpenFile(l.Location(name), os.O_RDONLY, 0)
+        return file, errors.WithMessagef(err, "cannot open file %s", l.Location(name))
+}
+
+func (l *localStorage) Write(name string) (io.WriteCloser, error) {
+        file, err := os.OpenFile(l.Location(name), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+        return file, errors.WithMessagef(err, "cannot open file %s", l.Location(name))
+}
+
+func (l *localStorage) List() ([]string, error) {
+        infos, err := ioutil.ReadDir(l.dir)
+        if err != nil {
+                return nil, errors.WithMessagef(err, "cannot list dir %s", l.dir)
+        }
+        var names []string
+        for _, info := range infos {
+                if !info.IsDir() {
+                        names = append(names, filepath.Base(info.Name()))
+                }
+        }
+        sort.Strings(names)
+        return names, nil
+}
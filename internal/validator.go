@@ -0,0 +1,269 @@
+package internal
+
+import (
+        "bytes"
+        "fmt"
+        "github.com/pkg/errors"
+        "github.com/sirupsen/logrus"
+        "gopkg.in/yaml.v3"
+        "io"
+        "io/ioutil"
+        "reflect"
+        "regexp"
+        "strconv"
+        "strings"
+)
+
+// ValidationError is a problem in a config file at the given position
+type ValidationError struct {
+        File    string `json:"file"`
+        Line    int    `json:"line"`
+        Column  int    `json:"column"`
+        Message string `json:"message"`
+}
+
+func (v ValidationError) Error() string {
+        if v.Column == 0 {
+                return fmt.Sprintf("%s:%d: %s", v.File, v.Line, v.Message)
+        }
+        return fmt.Sprintf("%s:%d:%d: %s", v.File, v.Line, v.Column, v.Message)
+}
+
+// elementTypes are the types the config files of the clients are decoded into
+var elementTypes = map[string]reflect.Type{
+        "monitors":   reflect.TypeOf(monitorConfigElement{}),
+        "dashboards": reflect.TypeOf(dashboardConfigElement{}),
+        "downtimes":  reflect.TypeOf(downtimeConfigElement{}),
+}
+
+// requiredFields are the yaml paths every element of a config type needs
+var requiredFields = map[string][]string{
+        "monitors":   {"name", "delegate", "delegate.name", "delegate.type", "delegate.query"},
+        "dashboards": {"name", "delegate", "delegate.title"},
+        "downtimes":  {"delegate", "delegate.scope"},
+}
+
+var monitorTypes = []string{
+        "audit alert", "ci-pipelines alert", "composite", "database-monitoring alert", "error-tracking alert",
+        "event alert", "event-v2 alert", "log alert", "metric alert", "network-performance alert",
+        "process alert", "query alert", "rum alert", "service check", "slo alert", "synthetics alert",
+        "trace-analytics alert",
+}
+
+// typeErrorLine splits the errors of the yaml decoder into line and message
+var typeErrorLine = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// unknownField and wrongType match the messages of the yaml decoder, which only carry the line,
+// the node they refer to is looked up on that line for its column
+var (
+        unknownField = regexp.MustCompile(`^field (.+) not found in type `)
+        wrongType    = regexp.MustCompile("^cannot unmarshal (!!\\w+)(?: `(.*)`)? into ")
+)
+
+// decodeStrict decodes a config file like push does, unknown fields are errors
+func decodeStrict(reader io.Reader, out interface{}) error {
+        decoder := yaml.NewDecoder(reader)
+        decoder.KnownFields(true)
+        return decoder.Decode(out)
+}
+
+// Validate checks the config files strictly: unknown fields, values of the wrong type and
+// missing required fields are reported with their position in the file
+func (b *backupService) Validate() ([]ValidationError, error) {
+        var result []ValidationError
+        for _, c := range b.clients() {
+                validationErrors, err := b.validate(c)
+                if err != nil {
+                        return result, errors.WithMessagef(err, "validate client %s", c.ConfigClientName())
+                }
+                result = append(result, validationErrors...)
+        }
+        return result, nil
+}
+
+func (b *backupService) validate(client DatadogConfigClient) ([]ValidationError, error) {
+        configType := client.ConfigClientName()
+        name := b.configFileName(configType)
+        exists, err := b.configStorage.Exists(name)
+        if err != nil || !exists {
+                return nil, errors.WithMessage(err, "validate")
+        }
+        reader, err := b.configStorage.Read(name)
+        if err != nil {
+                return nil, errors.WithMessage(err, "validate")
+        }
+        defer closeQuietly(reader)
+        content, err := ioutil.ReadAll(reader)
+        if err != nil {
+                return nil, errors.WithMessagef(err, "validate: cannot read %s", b.configStorage.Location(name))
+        }
+
+        validationErrors := ValidateConfig(configType, b.configStorage.Location(name), content)
+        logger := b.log.WithFields(logrus.Fields{"client": configType, "action": "validate"})
+        if len(validationErrors) == 0 {
+                logger.Infof("validate: %s is valid", b.configStorage.Location(name))
+        }
+        for _, validationError := range validationErrors {
+                logger.Error(validationError.Error())
+        }
+        return validationErrors, nil
+}
+
+// ValidateConfig validates the content of the config file of the given type
+func ValidateConfig(configType, file string, content []byte) []ValidationError {
+        v := &configValidator{file: file}
+        var document yaml.Node
+        if err := yaml.Unmarshal(content, &document); err != nil {
+                v.add(&document, "invalid yaml: %s", err)
+                return v.errors
+        }
+        if len(document.Content) == 0 {
+                return nil
+        }
+        root := resolveAlias(document.Content[0])
+        if root.Kind != yaml.SequenceNode {
+                v.add(root, "expected a list of %s", configType)
+                return v.errors
+        }
+        if elementType, ok := elementTypes[configType]; ok {
+                v.validateDecoding(&document, content, elementType)
+        }
+        for _, element := range root.Content {
+                element = resolveAlias(element)
+                v.validateRequired(element, requiredFields[configType])
+                if configType == "monitors" {
+                        v.validateMonitorType(element)
+                }
+        }
+        return v.errors
+}
+
+type configValidator struct {
+        file   string
+        errors []ValidationError
+}
+
+func (v *configValidator) add(node *yaml.Node, format string, args ...interface{}) {
+        v.errors = append(v.errors, ValidationError{
+                File:    v.file,
+                Line:    node.Line,
+                Column:  node.Column,
+                Message: fmt.Sprintf(format, args...),
+        })
+}
+
+// validateDecoding decodes the file strictly into the go type of the elements, unknown fields
+// and values of the wrong type are reported by the decoder with their line only, the column is
+// taken from the node of the document they refer to
+func (v *configValidator) validateDecoding(document *yaml.Node, content []byte, elementType reflect.Type) {
+        elements := reflect.New(reflect.SliceOf(elementType))
+        err := decodeStrict(bytes.NewReader(content), elements.Interface())
+        var typeError *yaml.TypeError
+        if !errors.As(err, &typeError) {
+                if err != nil {
+                        v.add(&yaml.Node{}, "%s", err)
+                }
+                return
+        }
+        for _, message := range typeError.Errors {
+                node := &yaml.Node{}
+                if match := typeErrorLine.FindStringSubmatch(message); match != nil {
+                        node.Line, _ = strconv.Atoi(match[1])
+                        message = match[2]
+                        if found := findNode(document, node.Line, decoderErrorNode(message)); found != nil {
+                                node = found
+                        }
+                }
+                v.add(node, "%s", message)
+        }
+}
+
+// decoderErrorNode returns a matcher for the node a message of the yaml decoder refers to, the
+// key of an unknown field or the value of the wrong type. Long values are shortened by the decoder.
+func decoderErrorNode(message string) func(node *yaml.Node) bool {
+        if match := unknownField.FindStringSubmatch(message); match != nil {
+                return func(node *yaml.Node) bool {
+                        return node.Kind == yaml.ScalarNode && node.Value == match[1]
+                }
+        }
+        if match := wrongType.FindStringSubmatch(message); match != nil {
+                value := match[2]
+                shortened := strings.HasSuffix(value, "...") && len(value) == 10
+                return func(node *yaml.Node) bool {
+                        if node.ShortTag() != match[1] {
+                                return false
+                        }
+                        if node.Kind != yaml.ScalarNode {
+                                return true
+                        }
+                        return node.Value == value || (shortened && strings.HasPrefix(node.Value, value[:7]))
+                }
+        }
+        return func(node *yaml.Node) bool {
+                return true
+        }
+}
+
+// findNode returns the first matching node on the given line, nested nodes are matched before
+// the collections they are in
+func findNode(node *yaml.Node, line int, matches func(node *yaml.Node) bool) *yaml.Node {
+        for _, child := range node.Content {
+                if found := findNode(child, line, matches); found != nil {
+                        return found
+                }
+        }
+        if node.Line == line && node.Kind != yaml.DocumentNode && matches(node) {
+                return node
+        }
+        return nil
+}
+
+func (v *configValidator) validateRequired(element *yaml.Node, paths []string) {
+        for _, path := range paths {
+                if node := lookupNode(element, path); node == nil || node.Tag == "!!null" {
+                        v.add(element, "missing required field %s", path)
+                }
+        }
+}
+
+func (v *configValidator) validateMonitorType(element *yaml.Node) {
+        node := lookupNode(element, "delegate.type")
+        if node == nil || node.Kind != yaml.ScalarNode {
+                return
+        }
+        for _, monitorType := range monitorTypes {
+                if node.Value == monitorType {
+                        return
+                }
+        }
+        v.add(node, "delegate.type: unknown monitor type %q", node.Value)
+}
+
+// lookupNode returns the value node of a dotted path of mapping keys
+func lookupNode(node *yaml.Node, path string) *yaml.Node {
+        for _, key := range strings.Split(path, ".") {
+                node = resolveAlias(node)
+                if node.Kind != yaml.MappingNode {
+                        return nil
+                }
+                var next *yaml.Node
+                for i := 0; i+1 < len(node.Content); i += 2 {
+                        if node.Content[i].Value == key {
+                                next = node.Content[i+1]
+                                break
+                        }
+                }
+                if next == nil {
+                        return nil
+                }
+                node = next
+        }
+        return resolveAlias(node)
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+        for node.Kind == yaml.AliasNode && node.Alias != nil {
+                node = node.Alias
+        }
+        return node
+}
@@ -0,0 +1,266 @@
+package internal
+
+import (
+        "bytes"
+        "context"
+        "encoding/json"
+        "github.com/sirupsen/logrus"
+        "github.com/zorkian/go-datadog-api"
+        "gopkg.in/yaml.v3"
+        "io/ioutil"
+        "net/http"
+        "net/http/httptest"
+        "os"
+        "path/filepath"
+        "reflect"
+        "strings"
+        "testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+        tests := []struct {
+                name       string
+                configType string
+                content    string
+                want       []string
+        }{
+                {
+                        name:       "valid monitor",
+                        configType: "monitors",
+                        content: `
+- name: cpu
+  id: 1
+  delegate:
+    name: cpu
+    type: metric alert
+    query: avg(last_5m):avg:cpu{*} > 1
+    tags: [team:a]
+    options:
+      renotifyinterval: 10
+`,
+                },
+                {
+                        name:       "unknown field",
+                        configType: "monitors",
+                        content: `
+- name: cpu
+  delegate:
+    name: cpu
+    type: metric alert
+    query: q
+    renotify_interval: 10
+`,
+                        want: []string{"f:7:5: field renotify_interval not found in type datadog.Monitor"},
+                },
+                {
+                        name:       "wrong type",
+                        configType: "monitors",
+                        content: `
+- name: cpu
+  id: one
+  delegate:
+    name: cpu
+    type: metric alert
+    query: q
+`,
+                        want: []string{"f:3:7: cannot unmarshal !!str `one` into int"},
+                },
+                {
+                        name:       "threshold instead of thresholds",
+                        configType: "monitors",
+                        content: `
+- name: cpu
+  delegate:
+    name: cpu
+    type: metric alert
+    query: q
+    options: {renotifyinterval: 10, threshold: {critical: 1}}
+`,
+                        want: []string{"f:7:37: field threshold not found in type datadog.Options"},
+                },
+                {
+                        name:       "shortened value of the wrong type",
+                        configType: "monitors",
+                        content: `
+- name: cpu
+  delegate:
+    name: cpu
+    type: metric alert
+    query: q
+    options: {renotifyinterval: tenminutes_or_so}
+`,
+                        want: []string{"f:7:33: cannot unmarshal !!str `tenminu...` into int"},
+                },
+                {
+                        name:       "mapping instead of a value",
+                        configType: "monitors",
+                        content: `
+- name: {first: cpu}
+  delegate:
+    name: cpu
+    type: metric alert
+    query: q
+`,
+                        want: []string{"f:2:9: cannot unmarshal !!map into string"},
+                },
+                {
+                        name:       "missing required fields and unknown monitor type",
+                        configType: "monitors",
+                        content: `
+- name: cpu
+  delegate:
+    type: metric alarm
+`,
+                        want: []string{
+                                "f:2:3: missing required field delegate.name",
+                                "f:2:3: missing required field delegate.query",
+                                `f:4:11: delegate.type: unknown monitor type "metric alarm"`,
+                        },
+                },
+                {
+                        name:       "not a list",
+                        configType: "downtimes",
+                        content:    "delegate: {}\n",
+                        want:       []string{"f:1:1: expected a list of downtimes"},
+                },
+                {
+                        name:       "invalid yaml",
+                        configType: "dashboards",
+                        content:    "- name: [\n",
+                        want:       []string{"invalid yaml"},
+                },
+                {
+                        name:       "empty file",
+                        configType: "dashboards",
+                        content:    "",
+                },
+        }
+        for _, test := range tests {
+                t.Run(test.name, func(t *testing.T) {
+                        validationErrors := ValidateConfig(test.configType, "f", []byte(test.content))
+                        if len(validationErrors) != len(test.want) {
+                                t.Fatalf("got %d error(s) %v, want %v", len(validationErrors), validationErrors, test.want)
+                        }
+                        for i, want := range test.want {
+                                if got := validationErrors[i].Error(); !strings.Contains(got, want) {
+                                        t.Errorf("error %d = %q, want %q", i, got, want)
+                                }
+                        }
+                })
+        }
+}
+
+// pulled config files have to pass the strict decoding of validate and push
+func TestValidateConfigAcceptsPulledFiles(t *testing.T) {
+        monitors := &monitorsClient{}
+        dashboards := &dashboardsClient{}
+        downtimes := &downtimesClient{}
+        monitor := &datadog.Monitor{}
+        monitor.SetId(1)
+        monitor.SetName("cpu")
+        monitor.SetType("metric alert")
+        monitor.SetQuery("avg(last_5m):avg:cpu{*} > 1")
+        monitor.Options = &datadog.Options{}
+        monitor.Options.SetRenotifyInterval(10)
+        monitor.Options.Thresholds = &datadog.ThresholdCount{}
+        monitor.Options.Thresholds.SetCritical(json.Number("1"))
+        dashboard := &datadog.Dashboard{}
+        dashboard.SetId(2)
+        dashboard.SetTitle("overview")
+        dashboard.Graphs = []datadog.Graph{{}}
+        dashboard.Graphs[0].SetTitle("cpu")
+        downtime := &datadog.Downtime{}
+        downtime.SetId(3)
+        downtime.Scope = []string{"env:prod"}
+        downtime.SetMessage("maintenance")
+
+        tests := []struct {
+                client  DatadogConfigClient
+                element ConfigElement
+        }{
+                {monitors, monitors.newConfigElement(monitor.Name, monitor.Id, monitor)},
+                {dashboards, dashboards.newConfigElement(dashboard.Title, dashboard.Id, dashboard)},
+                {downtimes, downtimes.newConfigElement(downtime.Id, downtime)},
+        }
+        for _, test := range tests {
+                configType := test.client.ConfigClientName()
+                content, err := yaml.Marshal([]ConfigElement{test.element})
+                if err != nil {
+                        t.Fatal(err)
+                }
+                if validationErrors := ValidateConfig(configType, "f", content); len(validationErrors) > 0 {
+                        t.Errorf("%s: pulled file is invalid: %v", configType, validationErrors)
+                }
+                if _, err := test.client.DecodeFile(bytes.NewReader(content)); err != nil {
+                        t.Errorf("%s: pulled file cannot be decoded: %s", configType, err)
+                }
+        }
+}
+
+func TestDecodeFileRejectsUnknownFields(t *testing.T) {
+        content := "- name: cpu\n  delegate:\n    name: cpu\n    typo: x\n"
+        if _, err := (&monitorsClient{}).DecodeFile(strings.NewReader(content)); err == nil || !strings.Contains(err.Error(), "typo") {
+                t.Errorf("DecodeFile error = %v, want the unknown field typo", err)
+        }
+}
+
+func TestPushValidatesMonitorsRemotelyBeforeAnyWrite(t *testing.T) {
+        for _, dryRun := range []bool{false, true} {
+                var requests []string
+                server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                        var monitor datadog.Monitor
+                        if err := json.NewDecoder(r.Body).Decode(&monitor); err != nil {
+                                t.Error(err)
+                        }
+                        requests = append(requests, r.Method+" "+r.URL.Path)
+                        if monitor.Id != nil {
+                                t.Errorf("validated monitor %s has the read only id %d", monitor.GetName(), monitor.GetId())
+                        }
+                        if r.URL.Path == "/api/v1/monitor/validate" && monitor.GetQuery() == "bad" {
+                                w.WriteHeader(http.StatusBadRequest)
+                                _, _ = w.Write([]byte(`{"errors": ["The value provided for parameter 'query' is invalid"]}`))
+                        }
+                }))
+                defer server.Close()
+                ddClient := datadog.NewClient("api", "app")
+                ddClient.SetBaseUrl(server.URL)
+
+                dir, err := ioutil.TempDir("", "validate")
+                if err != nil {
+                        t.Fatal(err)
+                }
+                defer os.RemoveAll(dir)
+                content := "- {name: m0, id: 1, delegate: {name: m0, type: metric alert, query: good}}\n- {name: m1, id: 2, delegate: {name: m1, type: metric alert, query: bad}}\n"
+                if err := ioutil.WriteFile(filepath.Join(dir, "monitors.yaml"), []byte(content), 0644); err != nil {
+                        t.Fatal(err)
+                }
+                storage, err := newLocalStorage(dir)
+                if err != nil {
+                        t.Fatal(err)
+                }
+                linter, err := NewLinter(nil)
+                if err != nil {
+                        t.Fatal(err)
+                }
+                service := &backupService{
+                        log:           logrus.WithField("prefix", "test"),
+                        dryRun:        dryRun,
+                        configClients: []DatadogConfigClient{NewMonitorsClient(ddClient, newApiClient(ddClient, "api", "app"))},
+                        filter:        &Filter{},
+                        linter:        linter,
+                        report:        NewReport(),
+                        configStorage: storage,
+                        stop:          make(chan struct{}),
+                }
+
+                err = service.Push(context.Background())
+                if err == nil || !strings.Contains(err.Error(), "1 element(s) were rejected by datadog validation") {
+                        t.Errorf("dry run %t: error = %v, want the rejected monitor", dryRun, err)
+                }
+                // both monitors are validated, nothing else is sent
+                want := []string{"POST /api/v1/monitor/validate", "POST /api/v1/monitor/validate"}
+                if !reflect.DeepEqual(requests, want) {
+                        t.Errorf("dry run %t: requests = %v, want %v", dryRun, requests, want)
+                }
+        }
+}